@@ -2,10 +2,11 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/tomo-9925/cnet/pkg/docker"
+	"github.com/tomo-9925/cnet/pkg/container"
 	"github.com/tomo-9925/cnet/pkg/policy"
 )
 
@@ -17,19 +18,31 @@ const (
 	policyPath  string = "./policy.yml"
 
 	// iptables settings
-	chainName string = "DOCKER-USER"
-	ruleNum   uint16 = 1
-	protocol  string = "all"
-	queueNum  uint16 = 2
+	ruleNum  uint16 = 1
+	protocol string = "all"
+	queueNum uint16 = 2 // the first queue number of the queues balanced
 
 	// NFQueue settings
 	maxPacketsInQueue uint32 = 10000
+	// queueMonitorInterval is the interval to check the packets dropped by the overflow of the queues
+	queueMonitorInterval time.Duration = 10 * time.Second
+	// hostPortSyncInterval is the interval to follow the ports which the protected containers of the host network listen on
+	hostPortSyncInterval time.Duration = 5 * time.Second
 )
 
 var (
+	// chainName is the chain of the packets forwarded to and from the containers, which depends on the container runtime
+	chainName string
+	// localChainNames are the chains of the packets between the containers and the host, and of the containers of the host network
+	localChainNames []string = []string{"INPUT", "OUTPUT"}
+
 	err        error
 	logFile    *os.File
-	containers *docker.Containers
+	containers *container.Containers
 	policies   *policy.Policies
 	logLevel   logrus.Level
+	queueCount uint16
+	// queueBypass accepts the packets while cnet is down or the verdict could not be issued (fail-open), otherwise they are dropped (fail-closed)
+	// NOTE: The packets overflowing the queue are dropped by the kernel either way, because the binding does not set NFQA_CFG_F_FAIL_OPEN.
+	queueBypass bool
 )
@@ -4,11 +4,22 @@ import (
 	"os"
 
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/conntrack"
 	"github.com/tomo-9925/cnet/pkg/network"
 )
 
 func deinit() {
-	err = network.DeleteNFQueueRule(chainName, protocol, queueNum)
+	// NOTE: The jumps of the local chains are removed first, so that the chain of nfqueue rule is deleted with the last jumps.
+	for _, localChainName := range localChainNames {
+		_, err = network.RemoveOwnedRules(localChainName)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":      err,
+				"chain_name": localChainName,
+			}).Error("failed to remove the jump rules")
+		}
+	}
+	err = network.DeleteNFQueueRule(chainName, protocol, queueNum, queueCount, queueBypass)
 	if err != nil {
 		logrus.WithField("error", err).Error("failed to delete the nfqueue rule")
 	}
@@ -17,8 +28,17 @@ func deinit() {
 		"protocol": protocol,
 		"rule_num": ruleNum,
 		"queue_num": queueNum,
+		"queue_count": queueCount,
+		"queue_bypass": queueBypass,
 	}).Info("the nfqueue rule deleted")
 
+	// NOTE: The verdicts are cleared, and the next cnet sets the flows pending to decide them again.
+	err = conntrack.Verdicts.Clear()
+	if err != nil {
+		logrus.WithField("error", err).Error("failed to clear the verdicts of the flows")
+	}
+	conntrack.Verdicts.Close()
+
 	logrus.WithField("logfile", logFile).Infoln("cnet quits")
 
 	if !debug {
@@ -2,12 +2,19 @@ package main
 
 import (
 	"flag"
+	"math"
 	"os"
+	"runtime"
 
 	"github.com/sirupsen/logrus"
-	"github.com/tomo-9925/cnet/pkg/docker"
+	"github.com/tomo-9925/cnet/pkg/conntrack"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/containerd"
+	"github.com/tomo-9925/cnet/pkg/handler"
 	"github.com/tomo-9925/cnet/pkg/network"
+	"github.com/tomo-9925/cnet/pkg/podman"
 	"github.com/tomo-9925/cnet/pkg/policy"
+	"github.com/tomo-9925/cnet/pkg/proc"
 )
 
 func init() {
@@ -24,6 +31,16 @@ func init() {
 	}
 
 	logLevelFlag = flag.String("logLevel", defaultLogLevel, "specify logLevel")
+	queueCountFlag := flag.Uint("queues", uint(runtime.NumCPU()), "specify the number of nfqueues balanced")
+	backendFlag := flag.String("backend", network.AutoBackend, "specify the backend of the rules (auto, iptables-nft, iptables-legacy or nftables)")
+	flag.DurationVar(&proc.ContainerProcesses.SnapshotInterval, "snapshotInterval", proc.DefaultSnapshotInterval, "specify the interval to record the sockets of the containers whose processes forked or executed (0 disables the snapshots)")
+	flag.BoolVar(&queueBypass, "queueBypass", false, "accept the packets while cnet is down or its verdict is not decided in time (fail-open), though the packets overflowing the queue are still dropped")
+	var runtimeFlags runtimeOptions
+	flag.StringVar(&runtimeFlags.name, "runtime", autoRuntime, "specify the container runtime (auto, docker, podman, containerd or cri)")
+	flag.StringVar(&runtimeFlags.containerdAddress, "containerdAddress", containerd.DefaultAddress, "specify the socket of containerd")
+	flag.StringVar(&runtimeFlags.containerdNamespace, "containerdNamespace", containerd.DefaultNamespace, "specify the namespace of containerd")
+	flag.StringVar(&runtimeFlags.podmanAddress, "podmanAddress", podman.DefaultAddress, "specify the socket of podman")
+	flag.StringVar(&runtimeFlags.criAddress, "criAddress", "", "specify the socket of CRI (detected from containerd and CRI-O if empty)")
 	flag.Parse()
 	switch *logLevelFlag {
 	case "FATAL":
@@ -42,6 +59,11 @@ func init() {
 		logrus.WithField("logLevelFlag", *logLevelFlag).Fatal("the specified logLevel does not exist")
 	}
 
+	if *queueCountFlag == 0 || *queueCountFlag > uint(math.MaxUint16-queueNum+1) {
+		logrus.WithField("queueCountFlag", *queueCountFlag).Fatal("the specified number of queues is out of range")
+	}
+	queueCount = uint16(*queueCountFlag)
+
 	if !debug {
 		// Writing to a file in production environment only
 		logrus.SetFormatter(&logrus.TextFormatter{
@@ -60,29 +82,108 @@ func init() {
 	logrus.SetLevel(logLevel)
 	logrus.DeferExitHandler(deinit)
 
-	containers, err = docker.InitializeContainers()
+	container.CurrentRuntime, err = connectRuntime(runtimeFlags)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("failed to initialize cnet")
+	}
+	chainName = container.CurrentRuntime.ForwardChain()
+	logrus.WithFields(logrus.Fields{
+		"runtime":    container.CurrentRuntime.Name(),
+		"chain_name": chainName,
+	}).Info("the container runtime connected")
+
+	containers, err = container.InitializeContainers()
 	if err != nil {
 		logrus.WithField("error", err).Fatal("failed to initialize cnet")
 	}
 	logrus.WithField("containers", containers).Info("container information fetched")
 
+	err = proc.ContainerProcesses.StartWatching()
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to start watching process events, so processes are enumerated from proc filesystem each time")
+	} else {
+		logrus.Info("watching process events started")
+	}
+
+	err = conntrack.Verdicts.Open()
+	if err == nil {
+		// NOTE: The verdicts recorded by the previous cnet may differ from the current policy,
+		// and the flows established before cnet started are not new anymore, so they are decided with their next packets.
+		err = conntrack.Verdicts.Reset(true)
+	}
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to record the verdicts of the flows in conntrack table, so only the new flows are decided")
+	} else {
+		logrus.Info("the verdicts of the flows are recorded in conntrack table")
+	}
+
+	err = conntrack.Flows.StartWatching()
+	if err != nil {
+		logrus.WithField("error", err).Warn("failed to start watching conntrack events, so the caches of the flows are expired by time")
+	} else {
+		logrus.Info("watching conntrack events started")
+	}
+
 	policies, err = policy.Read(policyPath)
 	if err != nil {
 		logrus.WithField("error", err).Fatal("failed to initialize cnet")
 	}
 	logrus.WithField("policies", policies).Info("the security policy loaded")
 
-	err = network.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum)
+	network.CurrentBackend, err = network.SelectBackend(*backendFlag, chainName)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("failed to initialize cnet")
+	}
+	logrus.WithField("backend", network.CurrentBackend.Name()).Info("the backend of the rules selected")
+
+	// NOTE: The rules left by the previous cnet crashed without deinit are removed before the fresh ones are installed.
+	// The chain jumped to from the local chains is removed with the rules of the last chain.
+	for _, hookedChainName := range append(localChainNames, chainName) {
+		var removedRules int
+		removedRules, err = network.RemoveOwnedRules(hookedChainName)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":      err,
+				"chain_name": hookedChainName,
+			}).Warn("failed to remove the rules left by the previous cnet")
+		} else if removedRules != 0 {
+			logrus.WithFields(logrus.Fields{
+				"removed_rules": removedRules,
+				"chain_name":    hookedChainName,
+			}).Warn("the rules left by the previous cnet removed")
+		}
+	}
+
+	err = network.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum, queueCount, queueBypass)
 	if err != nil {
 		logrus.WithField("error", err).Fatal("failed to initialize cnet")
 	}
 	logrus.WithFields(logrus.Fields{
-		"chain_name": chainName,
-		"protocol":   protocol,
-		"rule_num":   ruleNum,
-		"queue_num":  queueNum,
+		"chain_name":  chainName,
+		"protocol":    protocol,
+		"rule_num":    ruleNum,
+		"queue_num":   queueNum,
+		"queue_count": queueCount,
+		"queue_bypass": queueBypass,
 	}).Info("the nfqueue rule added")
 
+	// NOTE: Only the packets of the containers protected by the policy jump to the nfqueue rule.
+	err = network.ProtectedContainers.Hook(chainName, ruleNum, false)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("failed to initialize cnet")
+	}
+	// NOTE: The local chains cover the packets between the containers and the host, and the containers of the host network.
+	for _, localChainName := range localChainNames {
+		err = network.ProtectedContainers.Hook(localChainName, ruleNum, true)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":      err,
+				"chain_name": localChainName,
+			}).Fatal("failed to initialize cnet")
+		}
+	}
+	handler.SyncProtectedContainers(containers, policies)
+
 	logrus.WithFields(logrus.Fields{
 		"logfile":    logFile,
 		"containers": containers,
@@ -9,7 +9,9 @@ import (
 
 	"github.com/AkihiroSuda/go-netfilter-queue"
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
 	"github.com/tomo-9925/cnet/pkg/handler"
+	"github.com/tomo-9925/cnet/pkg/network"
 	"github.com/tomo-9925/cnet/pkg/runnotify"
 )
 
@@ -17,36 +19,54 @@ func main() {
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
-	var queue *netfilter.NFQueue
-	queue, err = netfilter.NewNFQueue(queueNum, maxPacketsInQueue, netfilter.NF_DEFAULT_PACKET_SIZE)
-	if err != nil {
-		logrus.WithField("error", err).Fatal("failed to bind nfqueue")
+	waitGroup := &sync.WaitGroup{}
+	semaphore := make(chan int, runtime.NumCPU())
+
+	fallbackVerdict := netfilter.NF_DROP
+	if queueBypass {
+		fallbackVerdict = netfilter.NF_ACCEPT
 	}
-	packets := queue.GetPackets()
+
+	go handler.RecordVerdicts()
+	// NOTE: Each queue has the dedicated reader and worker, so that the packets of one flow keep the order.
+	for i := uint16(0); i < queueCount; i++ {
+		var queue *netfilter.NFQueue
+		queue, err = netfilter.NewNFQueue(queueNum+i, maxPacketsInQueue, netfilter.NF_DEFAULT_PACKET_SIZE)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":     err,
+				"queue_num": queueNum + i,
+			}).Fatal("failed to bind nfqueue")
+		}
+		go handler.ServeQueue(queueNum+i, queue, containers, policies, fallbackVerdict, waitGroup)
+	}
+	// NOTE: The packets overflowing the queue are dropped by the kernel regardless of queueBypass, so they are logged.
+	quitMonitor := make(chan struct{})
+	go network.MonitorNFQueueOverflow(queueNum, queueCount, queueMonitorInterval, quitMonitor)
+	go handler.SyncHostPorts(containers, policies, hostPortSyncInterval, quitMonitor)
 
 	runCh := make(chan string)
 	killCh := make(chan string)
+	updateCh := make(chan string)
 	runErrCh := make(chan error)
-	runNotifyAPI := runnotify.NewAPI(runCh, killCh, runErrCh)
+	runNotifyAPI := runnotify.NewAPI(container.CurrentRuntime, containers, runCh, killCh, updateCh, runErrCh)
 	go runNotifyAPI.Start()
 
-	waitGroup := &sync.WaitGroup{}
-	semaphore := make(chan int, runtime.NumCPU())
-
 	for {
 		select {
 		case s := <-sig:
+			close(quitMonitor)
 			waitGroup.Wait()
 			logrus.WithField("signal", s).Info("the signal received")
 			logrus.Exit(0)
 		case cid := <-runCh:
-			go handler.AddDockerContainerInspection(cid, containers, policies, waitGroup, semaphore)
+			go handler.AddContainerInspection(cid, containers, policies, waitGroup, semaphore)
 		case cid := <-killCh:
-			go handler.RemoveDockerContainerInspection(cid, containers, policies, waitGroup, semaphore)
-		case cid := <-runErrCh:
-			logrus.WithField("container_id", cid).Info("an error occurred when starting the container")
-		case p := <-packets:
-			go handler.PacketHandler(&p, containers, policies, waitGroup, semaphore)
+			go handler.RemoveContainerInspection(cid, containers, policies, waitGroup, semaphore)
+		case cid := <-updateCh:
+			go handler.UpdateContainerInspection(cid, containers, policies, waitGroup, semaphore)
+		case err := <-runErrCh:
+			logrus.WithField("error", err).Warn("the container events interrupted, so reconnecting")
 		}
 	}
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/containerd"
+	"github.com/tomo-9925/cnet/pkg/cri"
+	"github.com/tomo-9925/cnet/pkg/docker"
+	"github.com/tomo-9925/cnet/pkg/podman"
+)
+
+const (
+	// autoRuntime is the name to select the runtime detected from the host.
+	autoRuntime string = "auto"
+	// dockerSocketPath is the default socket of Docker Engine API, which is used unless DOCKER_HOST is set.
+	dockerSocketPath string = "/var/run/docker.sock"
+	// kubeletPath is the directory of kubelet, which exists on the nodes of Kubernetes.
+	kubeletPath string = "/var/lib/kubelet"
+)
+
+// runtimeOptions are the options of the container runtime given by the flags.
+type runtimeOptions struct {
+	name                string
+	containerdAddress   string
+	containerdNamespace string
+	podmanAddress       string
+	criAddress          string // the socket of CRI, which is detected if empty
+}
+
+// connectRuntime returns the runtime of the name, or detects the runtime if the name is autoRuntime.
+// Docker is preferred, because containerd run by docker has no container of its own in the namespace of nerdctl.
+func connectRuntime(options runtimeOptions) (runtime container.Runtime, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"name":                 options.name,
+		"containerd_address":   options.containerdAddress,
+		"containerd_namespace": options.containerdNamespace,
+		"podman_address":       options.podmanAddress,
+		"cri_address":          options.criAddress,
+	})
+	argFields.Debug("trying to connect to the container runtime")
+
+	name := options.name
+	if name == autoRuntime {
+		name = detectRuntime(options)
+	}
+	switch name {
+	case docker.RuntimeName:
+		runtime, err = docker.NewRuntime()
+	case podman.RuntimeName:
+		runtime, err = podman.NewRuntime(options.podmanAddress)
+	case containerd.RuntimeName:
+		runtime, err = containerd.NewRuntime(options.containerdAddress, options.containerdNamespace)
+	case cri.RuntimeName:
+		runtime, err = cri.NewRuntime(options.criAddress)
+	case autoRuntime:
+		err = errors.New("no container runtime available")
+	default:
+		err = errors.New("the container runtime not supported")
+	}
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to connect to the container runtime")
+		return
+	}
+	argFields.WithField("runtime", runtime.Name()).Debug("the container runtime connected")
+	return
+}
+
+// detectRuntime returns the name of the runtime whose socket exists, or autoRuntime if none.
+// CRI is preferred on the nodes of Kubernetes, because the containers are managed by kubelet.
+func detectRuntime(options runtimeOptions) (name string) {
+	name = autoRuntime
+	if _, statErr := os.Stat(kubeletPath); statErr == nil && (options.criAddress != "" || cri.DetectAddress() != "") {
+		name = cri.RuntimeName
+		return
+	}
+	if os.Getenv("DOCKER_HOST") != "" {
+		name = docker.RuntimeName
+		return
+	}
+	// NOTE: podman-docker links the socket of docker to the socket of podman.
+	if dockerSocket, evalErr := filepath.EvalSymlinks(dockerSocketPath); evalErr == nil {
+		name = docker.RuntimeName
+		if podmanSocket, podmanErr := filepath.EvalSymlinks(options.podmanAddress); podmanErr == nil && podmanSocket == dockerSocket {
+			name = podman.RuntimeName
+		}
+		return
+	}
+	if _, statErr := os.Stat(options.podmanAddress); statErr == nil {
+		name = podman.RuntimeName
+	} else if _, statErr := os.Stat(options.containerdAddress); statErr == nil {
+		name = containerd.RuntimeName
+	}
+	return
+}
@@ -1,21 +1,38 @@
 module github.com/tomo-9925/cnet
 
-go 1.15
+go 1.21
 
 require (
 	github.com/AkihiroSuda/go-netfilter-queue v0.0.0-20180724014230-5b02f804b4f2
+	github.com/containerd/containerd/api v1.8.0
+	github.com/docker/docker v1.13.1
+	github.com/google/go-cmp v0.6.0
+	github.com/google/gopacket v1.1.19
+	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/sys v0.28.0
+	google.golang.org/grpc v1.67.3
+	google.golang.org/protobuf v1.34.2
+	gopkg.in/yaml.v2 v2.3.0
+	k8s.io/cri-api v0.27.1
+)
+
+require (
 	github.com/Microsoft/go-winio v0.4.15 // indirect
+	github.com/containerd/log v0.1.0 // indirect
+	github.com/containerd/ttrpc v1.2.5 // indirect
+	github.com/containerd/typeurl/v2 v2.1.1 // indirect
 	github.com/docker/distribution v2.7.1+incompatible // indirect
-	github.com/docker/docker v1.13.1
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/docker/go-units v0.4.0 // indirect
-	github.com/google/go-cmp v0.5.3
-	github.com/google/gopacket v1.1.19
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
 	github.com/opencontainers/go-digest v1.0.0 // indirect
-	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/opencontainers/image-spec v1.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/sirupsen/logrus v1.7.0
-	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b // indirect
-	golang.org/x/sys v0.0.0-20201113233024-12cec1faf1ba // indirect
-	gopkg.in/yaml.v2 v2.3.0
+	github.com/rogpeppe/go-internal v1.10.0 // indirect
+	github.com/stretchr/testify v1.9.0 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
 )
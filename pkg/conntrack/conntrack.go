@@ -0,0 +1,122 @@
+package conntrack
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"golang.org/x/sys/unix"
+)
+
+// The message types and attributes of ctnetlink defined in linux/netfilter/nfnetlink_conntrack.h.
+const (
+	nfnlSubsysCTNetlink uint16 = 1
+	nfnetlinkV0         uint8  = 0
+
+	ipctnlMsgCTNew uint16 = 0
+	ipctnlMsgCTGet uint16 = 1
+
+	ctaTupleOrig  uint16 = 1
+	ctaTupleReply uint16 = 2
+	ctaTimeout    uint16 = 7
+	ctaMark       uint16 = 8
+	ctaZone       uint16 = 18
+	ctaMarkMask   uint16 = 21
+
+	ctaTupleIP    uint16 = 1
+	ctaTupleProto uint16 = 2
+
+	ctaIPv4Src uint16 = 1
+	ctaIPv4Dst uint16 = 2
+	ctaIPv6Src uint16 = 3
+	ctaIPv6Dst uint16 = 4
+
+	ctaProtoNum     uint16 = 1
+	ctaProtoSrcPort uint16 = 2
+	ctaProtoDstPort uint16 = 3
+)
+
+// Tuple is the tuple of the flow in conntrack table.
+type Tuple struct {
+	Protocol         uint8
+	SrcIP, DstIP     net.IP
+	SrcPort, DstPort uint16
+}
+
+func (t *Tuple) String() string {
+	return fmt.Sprintf("{Protocol:%d SrcIP:%s SrcPort:%d DstIP:%s DstPort:%d}", t.Protocol, t.SrcIP, t.SrcPort, t.DstIP, t.DstPort)
+}
+
+// Reverse returns the tuple of the opposite direction.
+func (t *Tuple) Reverse() *Tuple {
+	return &Tuple{Protocol: t.Protocol, SrcIP: t.DstIP, DstIP: t.SrcIP, SrcPort: t.DstPort, DstPort: t.SrcPort}
+}
+
+func (t *Tuple) family() uint8 {
+	if t.SrcIP.To4() != nil {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+func (t *Tuple) attribute(attributeType uint16) netlink.Attribute {
+	var ipAttribute netlink.Attribute
+	if srcIPv4, dstIPv4 := t.SrcIP.To4(), t.DstIP.To4(); srcIPv4 != nil && dstIPv4 != nil {
+		ipAttribute = netlink.NewNestedAttribute(ctaTupleIP,
+			netlink.NewAttribute(ctaIPv4Src, srcIPv4),
+			netlink.NewAttribute(ctaIPv4Dst, dstIPv4),
+		)
+	} else {
+		ipAttribute = netlink.NewNestedAttribute(ctaTupleIP,
+			netlink.NewAttribute(ctaIPv6Src, t.SrcIP.To16()),
+			netlink.NewAttribute(ctaIPv6Dst, t.DstIP.To16()),
+		)
+	}
+	return netlink.NewNestedAttribute(attributeType,
+		ipAttribute,
+		netlink.NewNestedAttribute(ctaTupleProto,
+			netlink.NewUint8Attribute(ctaProtoNum, t.Protocol),
+			netlink.NewUint16Attribute(ctaProtoSrcPort, t.SrcPort),
+			netlink.NewUint16Attribute(ctaProtoDstPort, t.DstPort),
+		),
+	)
+}
+
+// protocolNumbers is the protocols whose flows are identified by ports in conntrack table.
+var protocolNumbers map[gopacket.LayerType]uint8 = map[gopacket.LayerType]uint8{
+	layers.LayerTypeTCP:     uint8(layers.IPProtocolTCP),
+	layers.LayerTypeUDP:     uint8(layers.IPProtocolUDP),
+	layers.LayerTypeUDPLite: uint8(layers.IPProtocolUDPLite),
+	layers.LayerTypeSCTP:    uint8(layers.IPProtocolSCTP),
+	proc.LayerTypeDCCP:      uint8(proc.IPProtocolDCCP),
+}
+
+// TupleOfSocket returns the tuple of the flow sent from the container through the socket.
+func TupleOfSocket(socket *proc.Socket) (tuple *Tuple, err error) {
+	protocol, ok := protocolNumbers[socket.Protocol]
+	if !ok {
+		err = errors.New("the flow of the protocol not tracked by ports")
+		return
+	}
+	tuple = &Tuple{
+		Protocol: protocol,
+		SrcIP:    socket.LocalIP,
+		DstIP:    socket.RemoteIP,
+		SrcPort:  socket.LocalPort,
+		DstPort:  socket.RemotePort,
+	}
+	return
+}
+
+func newRequest(messageType uint16, flags uint16, family uint8, attributes ...netlink.Attribute) netlink.Message {
+	// NOTE: The nfgenmsg header, which is family, version and res_id, precedes the attributes.
+	header := []byte{family, nfnetlinkV0, 0, 0}
+	return netlink.Message{
+		Header: unix.NlMsghdr{Type: nfnlSubsysCTNetlink<<8 | messageType, Flags: flags},
+		Data:   append(header, netlink.MarshalAttributes(attributes)...),
+	}
+}
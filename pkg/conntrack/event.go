@@ -0,0 +1,156 @@
+package conntrack
+
+import (
+	"errors"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// The multicast groups and attributes of conntrack events defined in linux/netfilter/nfnetlink.h and nfnetlink_conntrack.h.
+const (
+	nfnlgrpConntrackNew     uint32 = 1
+	nfnlgrpConntrackDestroy uint32 = 3
+
+	ipctnlMsgCTDelete uint16 = 2
+
+	ctaCountersOrig  uint16 = 9
+	ctaCountersReply uint16 = 10
+	ctaID            uint16 = 12
+
+	ctaCountersPackets uint16 = 1
+	ctaCountersBytes   uint16 = 2
+
+	eventReadBufferSize int = 4 << 20
+)
+
+// flowEvent is the event of the flow received from conntrack.
+type flowEvent struct {
+	destroyed       bool
+	id              uint32
+	original, reply *Tuple
+	packets, bytes  uint64
+}
+
+func dialConntrackEvents() (conn *netlink.Conn, err error) {
+	logrus.Debug("trying to dial conntrack events")
+
+	conn, err = netlink.Dial(unix.NETLINK_NETFILTER, nfnlgrpConntrackNew, nfnlgrpConntrackDestroy)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to dial conntrack events")
+		return
+	}
+	err = conn.SetReadBuffer(eventReadBufferSize)
+	if err != nil {
+		logrus.WithField("warn", err).Debug("could not set read buffer of conntrack events")
+	}
+
+	logrus.Debug("conntrack events dialed")
+	return
+}
+
+func parseFlowEvent(message netlink.Message) (event flowEvent, err error) {
+	if message.Header.Type>>8 != nfnlSubsysCTNetlink {
+		err = errors.New("the message is not ctnetlink")
+		return
+	}
+	switch message.Header.Type & 0xff {
+	case ipctnlMsgCTNew:
+	case ipctnlMsgCTDelete:
+		event.destroyed = true
+	default:
+		err = errors.New("the message type of ctnetlink not supported")
+		return
+	}
+	if len(message.Data) < 4 {
+		err = errors.New("ctnetlink message truncated")
+		return
+	}
+	var attributes []netlink.Attribute
+	attributes, err = netlink.UnmarshalAttributes(message.Data[4:])
+	if err != nil {
+		return
+	}
+	for _, attribute := range attributes {
+		switch attribute.Kind() {
+		case ctaTupleOrig:
+			event.original, err = parseTuple(attribute)
+		case ctaTupleReply:
+			event.reply, err = parseTuple(attribute)
+		case ctaID:
+			event.id = attribute.Uint32()
+		case ctaCountersOrig, ctaCountersReply:
+			var packets, bytes uint64
+			packets, bytes, err = parseCounters(attribute)
+			event.packets += packets
+			event.bytes += bytes
+		}
+		if err != nil {
+			return
+		}
+	}
+	if event.original == nil || event.reply == nil {
+		err = errors.New("the tuples of the flow not found")
+	}
+	return
+}
+
+func parseTuple(tupleAttribute netlink.Attribute) (tuple *Tuple, err error) {
+	var attributes []netlink.Attribute
+	attributes, err = tupleAttribute.Nested()
+	if err != nil {
+		return
+	}
+	tuple = &Tuple{}
+	for _, attribute := range attributes {
+		var nestedAttributes []netlink.Attribute
+		nestedAttributes, err = attribute.Nested()
+		if err != nil {
+			return
+		}
+		for _, nestedAttribute := range nestedAttributes {
+			switch attribute.Kind() {
+			case ctaTupleIP:
+				switch nestedAttribute.Kind() {
+				case ctaIPv4Src, ctaIPv6Src:
+					tuple.SrcIP = net.IP(nestedAttribute.Data)
+				case ctaIPv4Dst, ctaIPv6Dst:
+					tuple.DstIP = net.IP(nestedAttribute.Data)
+				}
+			case ctaTupleProto:
+				switch nestedAttribute.Kind() {
+				case ctaProtoNum:
+					tuple.Protocol = nestedAttribute.Uint8()
+				case ctaProtoSrcPort:
+					tuple.SrcPort = nestedAttribute.Uint16()
+				case ctaProtoDstPort:
+					tuple.DstPort = nestedAttribute.Uint16()
+				}
+			}
+		}
+	}
+	if tuple.SrcIP == nil || tuple.DstIP == nil {
+		err = errors.New("the addresses of the tuple not found")
+	}
+	return
+}
+
+// parseCounters returns the counters, which are included only if nf_conntrack_acct is enabled.
+func parseCounters(countersAttribute netlink.Attribute) (packets, bytes uint64, err error) {
+	var attributes []netlink.Attribute
+	attributes, err = countersAttribute.Nested()
+	if err != nil {
+		return
+	}
+	for _, attribute := range attributes {
+		switch attribute.Kind() {
+		case ctaCountersPackets:
+			packets = attribute.Uint64()
+		case ctaCountersBytes:
+			bytes = attribute.Uint64()
+		}
+	}
+	return
+}
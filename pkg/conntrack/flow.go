@@ -0,0 +1,306 @@
+package conntrack
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"github.com/tomo-9925/cnet/pkg/policy"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// flowSweepInterval is the interval to remove the flows not found in conntrack table,
+	// such as the flows whose destroy events are lost.
+	flowSweepInterval time.Duration = time.Minute
+	// watchRetryInterval is the interval to subscribe conntrack events again after they fail.
+	watchRetryInterval time.Duration = time.Second
+)
+
+var (
+	// Flows stores the flows of containers decided by cnet, which are removed by the destroy events of conntrack or swept.
+	Flows *FlowTable = NewFlowTable()
+)
+
+// Flow is the flow of container decided by cnet.
+type Flow struct {
+	// ID is the id of the flow in conntrack table, which is zero until the flow is confirmed.
+	ID             uint32
+	Tuple          *Tuple
+	Socket         *proc.Socket
+	Container      *container.Container
+	Processes      []*proc.Process
+	Accepted       bool
+	// Packets and Bytes are counted only if nf_conntrack_acct is enabled.
+	Packets, Bytes uint64
+	Started        time.Time
+}
+
+func (f *Flow) String() string {
+	return fmt.Sprintf("{ID:%d Tuple:%s Container:%s Processes:%v Accepted:%t Packets:%d Bytes:%d}",
+		f.ID, f.Tuple, f.Container, f.Processes, f.Accepted, f.Packets, f.Bytes)
+}
+
+// expireCache deletes the caches of the socket and the decision of the flow.
+func (f *Flow) expireCache() {
+	proc.SocketCache.Delete(f.Socket.Hash())
+	proc.OwnerContainerCache.Delete(f.Socket.Hash())
+	policy.PolicyCache.Delete(policy.GenerateOwnersHash(f.Container, f.Processes, f.Socket))
+}
+
+// FlowTable is the table of flows updated by the new and destroy events of conntrack.
+type FlowTable struct {
+	flows   map[string]*Flow // the key is the tuple sent from the container
+	RWMutex sync.RWMutex
+}
+
+// NewFlowTable returns the empty FlowTable.
+func NewFlowTable() *FlowTable {
+	return &FlowTable{flows: map[string]*Flow{}}
+}
+
+// StartWatching subscribes the new and destroy events of conntrack and starts updating the table.
+// The flows not found in conntrack table are also swept periodically.
+func (t *FlowTable) StartWatching() (err error) {
+	logrus.Debug("trying to start watching conntrack events")
+
+	var conn *netlink.Conn
+	conn, err = dialConntrackEvents()
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to start watching conntrack events")
+		return
+	}
+	go t.watch(conn)
+	go t.sweepPeriodically()
+
+	logrus.Debug("watching conntrack events started")
+	return
+}
+
+func (t *FlowTable) watch(conn *netlink.Conn) {
+	for {
+		messages, err := conn.Receive()
+		if err == syscall.ENOBUFS {
+			// NOTE: The destroy events may be lost, so the caches of all flows are expired.
+			logrus.WithField("error", err).Warn("conntrack events lost")
+			t.Flush()
+			continue
+		} else if err != nil {
+			// NOTE: The events are lost until they are subscribed again.
+			logrus.WithField("error", err).Error("failed to receive conntrack events")
+			conn.Close()
+			t.Flush()
+			conn = t.redial()
+			continue
+		}
+		for _, message := range messages {
+			event, err := parseFlowEvent(message)
+			if err != nil {
+				logrus.WithField("error", err).Trace("the message of conntrack skipped")
+				continue
+			}
+			t.handleEvent(event)
+		}
+	}
+}
+
+// redial subscribes conntrack events again until it succeeds.
+func (t *FlowTable) redial() (conn *netlink.Conn) {
+	for {
+		time.Sleep(watchRetryInterval)
+		var err error
+		conn, err = dialConntrackEvents()
+		if err == nil {
+			logrus.Info("conntrack events subscribed again")
+			return
+		}
+		logrus.WithField("error", err).Warn("failed to subscribe conntrack events again")
+	}
+}
+
+func (t *FlowTable) sweepPeriodically() {
+	ticker := time.NewTicker(flowSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := t.Sweep(flowSweepInterval); err != nil {
+			logrus.WithField("warn", err).Debug("could not sweep the flows")
+		}
+	}
+}
+
+// Sweep removes the flows started before the age and not found in conntrack table,
+// which are the flows whose destroy events are lost or the flows never confirmed.
+func (t *FlowTable) Sweep(age time.Duration) (err error) {
+	var events []flowEvent
+	events, err = dumpMarkedFlows()
+	if err != nil {
+		return
+	}
+	found := make(map[string]struct{}, len(events)*2)
+	for _, event := range events {
+		found[event.original.String()] = struct{}{}
+		found[event.reply.String()] = struct{}{}
+	}
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+	for key, flow := range t.flows {
+		if _, exist := found[key]; exist || time.Since(flow.Started) < age {
+			continue
+		}
+		delete(t.flows, key)
+		flow.expireCache()
+		logrus.WithField("flow", flow).Debug("the flow not found in conntrack table swept")
+	}
+	return
+}
+
+func (t *FlowTable) handleEvent(event flowEvent) {
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+
+	// NOTE: The tuple sent from the container is the reply direction of the flow started from outside.
+	// The flow between the containers is recorded by both the tuples of the sender and the receiver.
+	for _, tuple := range []*Tuple{event.original, event.reply} {
+		key := tuple.String()
+		if flow, exist := t.flows[key]; exist {
+			t.updateFlow(key, flow, event)
+		}
+	}
+}
+
+func (t *FlowTable) updateFlow(key string, flow *Flow, event flowEvent) {
+	if !event.destroyed {
+		if flow.ID != 0 && flow.ID != event.id {
+			// NOTE: The destroy event of the previous flow having the same tuple was lost.
+			flow.expireCache()
+			flow.Packets, flow.Bytes = 0, 0
+			flow.Started = time.Now()
+		}
+		flow.ID = event.id
+		logrus.WithField("flow", flow).Trace("the flow confirmed")
+		return
+	}
+	if flow.ID != 0 && flow.ID != event.id {
+		return
+	}
+	flow.Packets, flow.Bytes = event.packets, event.bytes
+	delete(t.flows, key)
+	flow.expireCache()
+	logrus.WithFields(logrus.Fields{
+		"flow":     flow,
+		"duration": time.Since(flow.Started),
+	}).Debug("the flow ended")
+}
+
+// Record records the flow of the socket with the verdict, which has been marked in conntrack table.
+// The flow is confirmed before it is recorded, so the id of the flow is retrieved from conntrack table.
+func (t *FlowTable) Record(socket *proc.Socket, communicatedContainer *container.Container, processes []*proc.Process, accepted bool) (err error) {
+	var tuple *Tuple
+	tuple, err = TupleOfSocket(socket)
+	if err != nil {
+		return
+	}
+	key := tuple.String()
+	id, lookupErr := Verdicts.lookupID(tuple, socket.Inbound)
+	if lookupErr != nil {
+		logrus.WithFields(logrus.Fields{
+			"warn":  lookupErr,
+			"tuple": tuple,
+		}).Debug("could not retrieve the id of the flow")
+	}
+
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+	flow, exist := t.flows[key]
+	if !exist || (flow.ID != 0 && id != 0 && flow.ID != id) {
+		if exist {
+			// NOTE: The destroy event of the previous flow having the same tuple was lost.
+			flow.expireCache()
+		}
+		flow = &Flow{Tuple: tuple, Started: time.Now()}
+		t.flows[key] = flow
+	}
+	if id != 0 {
+		flow.ID = id
+	}
+	flow.Socket, flow.Container, flow.Processes, flow.Accepted = socket, communicatedContainer, processes, accepted
+	return
+}
+
+// Lookup returns the flow having the tuple sent from the container.
+func (t *FlowTable) Lookup(tuple *Tuple) (flow Flow, exist bool) {
+	t.RWMutex.RLock()
+	defer t.RWMutex.RUnlock()
+	var found *Flow
+	found, exist = t.flows[tuple.String()]
+	if exist {
+		flow = *found
+	}
+	return
+}
+
+// List returns the flows with the counters refreshed from conntrack table as far as possible.
+func (t *FlowTable) List() (flows []Flow) {
+	if err := t.refreshCounters(); err != nil {
+		logrus.WithField("warn", err).Debug("could not refresh the counters of the flows")
+	}
+	t.RWMutex.RLock()
+	defer t.RWMutex.RUnlock()
+	flows = make([]Flow, 0, len(t.flows))
+	for _, flow := range t.flows {
+		flows = append(flows, *flow)
+	}
+	return
+}
+
+func (t *FlowTable) refreshCounters() (err error) {
+	var events []flowEvent
+	events, err = dumpMarkedFlows()
+	if err != nil {
+		return
+	}
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+	for _, event := range events {
+		for _, tuple := range []*Tuple{event.original, event.reply} {
+			if flow, exist := t.flows[tuple.String()]; exist {
+				flow.Packets, flow.Bytes = event.packets, event.bytes
+			}
+		}
+	}
+	return
+}
+
+// dumpMarkedFlows returns the flows having the verdict bits of connmark in conntrack table.
+func dumpMarkedFlows() (events []flowEvent, err error) {
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		for _, mark := range []uint32{MarkAccepted, MarkDenied, MarkPending} {
+			var markedFlows []netlink.Message
+			markedFlows, err = Verdicts.dump(family, mark)
+			if err != nil {
+				return
+			}
+			for _, markedFlow := range markedFlows {
+				if event, parseErr := parseFlowEvent(markedFlow); parseErr == nil {
+					events = append(events, event)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Flush removes all flows and expires the caches of them.
+func (t *FlowTable) Flush() {
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+	for _, flow := range t.flows {
+		flow.expireCache()
+	}
+	t.flows = map[string]*Flow{}
+}
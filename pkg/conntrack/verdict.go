@@ -0,0 +1,259 @@
+package conntrack
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"golang.org/x/sys/unix"
+)
+
+// The connmark bits recording the verdict of the flow.
+const (
+	MarkMask     uint32 = 0x000c0000
+	MarkAccepted uint32 = 0x00040000
+	MarkDenied   uint32 = 0x00080000
+	// MarkPending is the verdict reset, so that the next packet of the flow is queued and decided again.
+	MarkPending uint32 = MarkMask
+)
+
+const (
+	// deniedFlowTimeout is the lifetime of the flow created to drop the packets of the denied flow in the kernel.
+	deniedFlowTimeout uint32 = 30
+	// markRetryCount and markRetryInterval are for the flow not confirmed yet when the verdict is issued.
+	markRetryCount    int           = 3
+	markRetryInterval time.Duration = 5 * time.Millisecond
+)
+
+var (
+	// Verdicts records the verdicts of the flows as connmark in conntrack table.
+	Verdicts *VerdictMarker = &VerdictMarker{}
+)
+
+// VerdictMarker records the verdicts of the flows as connmark, so that the subsequent packets of the flow are decided in the kernel.
+type VerdictMarker struct {
+	conn  *netlink.Conn
+	mutex sync.Mutex
+}
+
+// Open dials ctnetlink.
+func (m *VerdictMarker) Open() (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.conn != nil {
+		return
+	}
+	m.conn, err = netlink.Dial(unix.NETLINK_NETFILTER)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to open ctnetlink")
+		return
+	}
+	logrus.Debug("ctnetlink opened")
+	return
+}
+
+// Close closes ctnetlink.
+func (m *VerdictMarker) Close() (err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.conn == nil {
+		return
+	}
+	err = m.conn.Close()
+	m.conn = nil
+	return
+}
+
+func (m *VerdictMarker) execute(message netlink.Message) (replies []netlink.Message, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.conn == nil {
+		err = errors.New("ctnetlink not opened")
+		return
+	}
+	replies, err = m.conn.Execute(message)
+	return
+}
+
+// Accept marks the flow of the socket as accepted.
+func (m *VerdictMarker) Accept(socket *proc.Socket) (err error) {
+	argFields := logrus.WithField("target_socket", socket)
+	argFields.Debug("trying to mark the flow as accepted")
+
+	var tuple *Tuple
+	tuple, err = TupleOfSocket(socket)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to mark the flow as accepted")
+		return
+	}
+	// NOTE: The packet is queued before the flow is confirmed, so the flow may not be found for a moment.
+	for i := 0; i < markRetryCount; i++ {
+		err = m.setMark(tuple, socket.Inbound, MarkAccepted)
+		if !errors.Is(err, syscall.ENOENT) {
+			break
+		}
+		time.Sleep(markRetryInterval)
+	}
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to mark the flow as accepted")
+		return
+	}
+	argFields.Debug("the flow marked as accepted")
+	return
+}
+
+// Deny marks the flow of the socket as denied.
+// The flow started from outside is found by the tuple sent from the container, which is its reply tuple,
+// because the original tuple may be translated by DNAT, such as for the published ports.
+// The dropped packet of new flow sent from the container is not confirmed, so the flow is created to drop the subsequent packets.
+func (m *VerdictMarker) Deny(socket *proc.Socket) (err error) {
+	argFields := logrus.WithField("target_socket", socket)
+	argFields.Debug("trying to mark the flow as denied")
+
+	var tuple *Tuple
+	tuple, err = TupleOfSocket(socket)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to mark the flow as denied")
+		return
+	}
+	// NOTE: The flow accepted before may be denied by the reloaded policy.
+	err = m.setMark(tuple, socket.Inbound, MarkDenied)
+	if errors.Is(err, syscall.ENOENT) {
+		if socket.Inbound {
+			// NOTE: The original tuple of the new flow started from outside is not known before DNAT,
+			// and the unconfirmed flow is destroyed with the dropped packet, so the subsequent packets are queued again.
+			err = errors.New("the flow started from outside not confirmed")
+		} else {
+			_, err = m.execute(newRequest(ipctnlMsgCTNew, unix.NLM_F_CREATE|unix.NLM_F_EXCL, tuple.family(),
+				tuple.attribute(ctaTupleOrig),
+				tuple.Reverse().attribute(ctaTupleReply),
+				netlink.NewUint32Attribute(ctaTimeout, deniedFlowTimeout),
+				netlink.NewUint32Attribute(ctaMark, MarkDenied),
+			))
+			if errors.Is(err, syscall.EEXIST) {
+				// NOTE: The flow may be confirmed after it is not found.
+				err = m.setMark(tuple, socket.Inbound, MarkDenied)
+			}
+		}
+	}
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to mark the flow as denied")
+		return
+	}
+	argFields.Debug("the flow marked as denied")
+	return
+}
+
+// setMark changes the verdict bits of connmark of the flow having the tuple sent from the container.
+func (m *VerdictMarker) setMark(tuple *Tuple, inbound bool, mark uint32) (err error) {
+	// NOTE: The tuple sent from the container is the reply direction of the flow started from outside.
+	direction := ctaTupleOrig
+	if inbound {
+		direction = ctaTupleReply
+	}
+	_, err = m.execute(newRequest(ipctnlMsgCTNew, 0, tuple.family(),
+		tuple.attribute(direction),
+		netlink.NewUint32Attribute(ctaMark, mark),
+		netlink.NewUint32Attribute(ctaMarkMask, MarkMask),
+	))
+	return
+}
+
+// lookupID returns the id of the flow having the tuple sent from the container.
+func (m *VerdictMarker) lookupID(tuple *Tuple, inbound bool) (id uint32, err error) {
+	direction := ctaTupleOrig
+	if inbound {
+		direction = ctaTupleReply
+	}
+	var replies []netlink.Message
+	replies, err = m.execute(newRequest(ipctnlMsgCTGet, 0, tuple.family(), tuple.attribute(direction)))
+	if err != nil {
+		return
+	}
+	for _, reply := range replies {
+		var event flowEvent
+		if event, err = parseFlowEvent(reply); err == nil {
+			id = event.id
+			return
+		}
+	}
+	err = errors.New("the flow not found in conntrack table")
+	return
+}
+
+// Reset sets the verdicts of the decided flows pending, so that the flows are decided again by the current policy.
+// If undecided is true, the flows not decided yet, such as the flows established before cnet started, are also set pending.
+func (m *VerdictMarker) Reset(undecided bool) (err error) {
+	logrus.WithField("undecided", undecided).Debug("trying to reset the verdicts of the flows")
+	marks := []uint32{MarkAccepted, MarkDenied}
+	if undecided {
+		marks = append(marks, 0)
+	}
+	err = m.remark(marks, MarkPending)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to reset the verdicts of the flows")
+		return
+	}
+	logrus.Debug("the verdicts of the flows reset")
+	return
+}
+
+// Clear removes the verdicts of all flows, so that nothing cnet recorded is left in conntrack table.
+func (m *VerdictMarker) Clear() (err error) {
+	logrus.Debug("trying to clear the verdicts of the flows")
+	err = m.remark([]uint32{MarkAccepted, MarkDenied, MarkPending}, 0)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to clear the verdicts of the flows")
+		return
+	}
+	logrus.Debug("the verdicts of the flows cleared")
+	return
+}
+
+// remark replaces the verdict bits of the flows having one of the marks with the new mark.
+func (m *VerdictMarker) remark(marks []uint32, newMark uint32) (err error) {
+	for _, family := range []uint8{unix.AF_INET, unix.AF_INET6} {
+		for _, mark := range marks {
+			var flows []netlink.Message
+			flows, err = m.dump(family, mark)
+			if err != nil {
+				return
+			}
+			for _, flow := range flows {
+				if len(flow.Data) < 4 {
+					continue
+				}
+				attributes, parseErr := netlink.UnmarshalAttributes(flow.Data[4:])
+				if parseErr != nil {
+					continue
+				}
+				// NOTE: The original tuple and zone in the dump identify the flow as they are.
+				request := []netlink.Attribute{
+					netlink.NewUint32Attribute(ctaMark, newMark),
+					netlink.NewUint32Attribute(ctaMarkMask, MarkMask),
+				}
+				for _, attribute := range attributes {
+					if kind := attribute.Kind(); kind == ctaTupleOrig || kind == ctaZone {
+						request = append(request, attribute)
+					}
+				}
+				// NOTE: The flow may have been already gone.
+				_, _ = m.execute(newRequest(ipctnlMsgCTNew, 0, family, request...))
+			}
+		}
+	}
+	return
+}
+
+// dump returns the flows having the verdict bits of connmark.
+func (m *VerdictMarker) dump(family uint8, mark uint32) (flows []netlink.Message, err error) {
+	flows, err = m.execute(newRequest(ipctnlMsgCTGet, unix.NLM_F_DUMP, family,
+		netlink.NewUint32Attribute(ctaMark, mark),
+		netlink.NewUint32Attribute(ctaMarkMask, MarkMask),
+	))
+	return
+}
@@ -13,6 +13,26 @@ type Container struct {
 	IPAddresses   []net.IP
 	Name          string
 	Pid           int // ID of container's main running process
+	HostNetwork   bool   // whether container uses the network namespace of the host, so it has no IP address of its own
+	Labels        map[string]string // labels given by the runtime, such as docker and nerdctl
+	Pod           *Pod              // pod of Kubernetes which the container belongs to (nil if the runtime has no pod)
+}
+
+// Pod is information about the pod of Kubernetes, whose containers share the network namespace and the IP addresses.
+type Pod struct {
+	ID        string
+	Name      string
+	Namespace string
+	Labels    map[string]string
+}
+
+// HasLabels reports whether p has all the labels with the same values.
+func (p *Pod) HasLabels(labels map[string]string) bool {
+	return hasLabels(p.Labels, labels)
+}
+
+func (p *Pod) String() string {
+	return fmt.Sprintf("{ID:%s Name:%s Namespace:%s}", p.ID, p.Name, p.Namespace)
 }
 
 // Equal reports whether c and x are the same container.
@@ -32,6 +52,20 @@ func (c *Container) Equal(x *Container) bool {
 	return c.Name == x.Name[1:]
 }
 
+// HasLabels reports whether c has all the labels with the same values.
+func (c *Container) HasLabels(labels map[string]string) bool {
+	return hasLabels(c.Labels, labels)
+}
+
+func hasLabels(given, labels map[string]string) bool {
+	for key, value := range labels {
+		if givenValue, exist := given[key]; !exist || givenValue != value {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Container)String() string {
 	return fmt.Sprintf("{ID:%s Name:%s}", c.ID, c.Name)
 }
@@ -41,11 +75,206 @@ func (c *Container)Hash() string{
 }
 
 // Containers is the structure that have list of Container and mutex.
+// The containers are also indexed by the IP address, the ID and the name, so that they are looked up in constant time.
+// The network namespaces are indexed after they are resolved by IndexNetworkNamespaces, because they are read from the proc filesystem.
 type Containers struct {
-	List    []*Container
-	RWMutex sync.RWMutex
+	List        []*Container
+	RWMutex     sync.RWMutex
+	byIPAddress map[string]*Container
+	byID        map[string]*Container
+	byName      map[string]*Container
+	// byNetworkNamespace has the containers sharing the network namespace, and networkNamespaces has the network namespace of each indexed container,
+	// which is empty if it cannot be resolved, such as for the container already stopped.
+	byNetworkNamespace map[string][]*Container
+	networkNamespaces  map[*Container]string
+}
+
+// NewContainers returns Containers having the list of Container indexed.
+func NewContainers(list []*Container) (c *Containers) {
+	c = &Containers{List: make([]*Container, 0, len(list))}
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+	for _, container := range list {
+		c.add(container)
+	}
+	return
 }
 
 func (c *Containers)String() string {
 	return fmt.Sprint(c.List)
 }
+
+// trimName removes the slash at the beginning of the container name got from the Docker Engine API.
+func trimName(name string) string {
+	return strings.TrimPrefix(name, "/")
+}
+
+// Add adds the container to the list and the indexes.
+// The container having the same ID is replaced, so that the changes of its networks are reflected.
+func (c *Containers)Add(container *Container) {
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+	c.add(container)
+}
+
+func (c *Containers)add(container *Container) {
+	if c.byID == nil {
+		c.byIPAddress, c.byID, c.byName = map[string]*Container{}, map[string]*Container{}, map[string]*Container{}
+		c.byNetworkNamespace, c.networkNamespaces = map[string][]*Container{}, map[*Container]string{}
+	}
+	if _, exist := c.byID[container.ID]; exist {
+		c.remove(container.ID)
+	}
+	c.List = append(c.List, container)
+	c.byID[container.ID] = container
+	if container.Name != "" {
+		c.byName[trimName(container.Name)] = container
+	}
+	for _, ipAddress := range container.IPAddresses {
+		if ipAddress.To16() != nil {
+			c.byIPAddress[string(ipAddress.To16())] = container
+		}
+	}
+}
+
+// Replace replaces the container having the same ID, and reports whether it is replaced.
+// The container not in the list is not added, so that the container removed meanwhile is not added again.
+func (c *Containers)Replace(container *Container) (replaced bool) {
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+	if _, replaced = c.byID[container.ID]; replaced {
+		c.add(container)
+	}
+	return
+}
+
+// Remove removes the container having the ID from the list and the indexes.
+func (c *Containers)Remove(cid string) (removed *Container, exist bool) {
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+	return c.remove(cid)
+}
+
+func (c *Containers)remove(cid string) (removed *Container, exist bool) {
+	removed, exist = c.byID[cid]
+	if !exist {
+		return
+	}
+	delete(c.byID, cid)
+	if indexed, ok := c.byName[trimName(removed.Name)]; ok && indexed == removed {
+		delete(c.byName, trimName(removed.Name))
+	}
+	// NOTE: The list is copied, so that the list got before is not changed under the readers.
+	list := make([]*Container, 0, len(c.List))
+	for _, container := range c.List {
+		if container != removed {
+			list = append(list, container)
+		}
+	}
+	c.List = list
+	if networkNamespace, indexed := c.networkNamespaces[removed]; indexed {
+		delete(c.networkNamespaces, removed)
+		sharing := make([]*Container, 0, len(c.byNetworkNamespace[networkNamespace]))
+		for _, container := range c.byNetworkNamespace[networkNamespace] {
+			if container != removed {
+				sharing = append(sharing, container)
+			}
+		}
+		if len(sharing) == 0 {
+			delete(c.byNetworkNamespace, networkNamespace)
+		} else {
+			c.byNetworkNamespace[networkNamespace] = sharing
+		}
+	}
+	// NOTE: The IP addresses shared by the containers of the pod are indexed by the remaining one.
+	for _, ipAddress := range removed.IPAddresses {
+		key := string(ipAddress.To16())
+		if indexed, ok := c.byIPAddress[key]; !ok || indexed != removed {
+			continue
+		}
+		delete(c.byIPAddress, key)
+		for _, container := range c.List {
+			if hasIPAddress(container, ipAddress) {
+				c.byIPAddress[key] = container
+			}
+		}
+	}
+	return
+}
+
+func hasIPAddress(container *Container, ipAddress net.IP) bool {
+	for _, containerIPAddress := range container.IPAddresses {
+		if containerIPAddress.Equal(ipAddress) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupByIPAddress returns the container having the IP address.
+func (c *Containers)LookupByIPAddress(ipAddress net.IP) (container *Container, exist bool) {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+	container, exist = c.byIPAddress[string(ipAddress.To16())]
+	return
+}
+
+// LookupByID returns the container having the full ID.
+func (c *Containers)LookupByID(cid string) (container *Container, exist bool) {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+	container, exist = c.byID[cid]
+	return
+}
+
+// LookupByName returns the container having the name, with or without the slash at the beginning.
+func (c *Containers)LookupByName(name string) (container *Container, exist bool) {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+	container, exist = c.byName[trimName(name)]
+	return
+}
+
+// IndexNetworkNamespaces indexes the containers not indexed yet by the network namespaces resolved by the function,
+// such as the containers added or replaced after the last indexing. The container whose network namespace is not resolved is not retried until it is replaced.
+func (c *Containers)IndexNetworkNamespaces(resolve func(*Container) (string, error)) {
+	c.RWMutex.RLock()
+	indexed := len(c.networkNamespaces) == len(c.List)
+	c.RWMutex.RUnlock()
+	if indexed {
+		return
+	}
+
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+	for _, container := range c.List {
+		if _, exist := c.networkNamespaces[container]; exist {
+			continue
+		}
+		networkNamespace, err := resolve(container)
+		if err != nil {
+			c.networkNamespaces[container] = ""
+			continue
+		}
+		c.networkNamespaces[container] = networkNamespace
+		c.byNetworkNamespace[networkNamespace] = append(c.byNetworkNamespace[networkNamespace], container)
+	}
+}
+
+// LookupSharingNetworkNamespace returns the containers sharing the network namespace with the container, which is placed first.
+// Only the container is returned if its network namespace is not indexed.
+func (c *Containers)LookupSharingNetworkNamespace(container *Container) (sharingContainers []*Container) {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+	sharingContainers = []*Container{container}
+	networkNamespace := c.networkNamespaces[container]
+	if networkNamespace == "" {
+		return
+	}
+	for _, sharingContainer := range c.byNetworkNamespace[networkNamespace] {
+		if sharingContainer != container {
+			sharingContainers = append(sharingContainers, sharingContainer)
+		}
+	}
+	return
+}
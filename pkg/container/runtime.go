@@ -0,0 +1,141 @@
+package container
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// CurrentRuntime is the container runtime running the containers controlled by cnet.
+	CurrentRuntime Runtime
+)
+
+// EventAction is the change of the container notified by the runtime.
+type EventAction string
+
+const (
+	// ActionRun is notified when the container starts or resumes running.
+	ActionRun EventAction = "run"
+	// ActionKill is notified when the container dies or is paused.
+	ActionKill EventAction = "kill"
+	// ActionUpdate is notified when the networks or the name of the container change, such as by docker network connect.
+	ActionUpdate EventAction = "update"
+)
+
+// Event is the event of the container notified by the runtime.
+type Event struct {
+	Action EventAction
+	ID     string
+}
+
+// Runtime is the container runtime, such as Docker, from which cnet gets the information of the containers.
+// The packet path depends only on this interface, so that the other runtimes and the fakes can be plugged in.
+type Runtime interface {
+	Name() string
+	// List returns the inspections of the running containers.
+	List() ([]*Container, error)
+	// Inspect returns the inspection of the container having the ID.
+	Inspect(cid string) (*Container, error)
+	// Events starts monitoring the events of the containers until the done channel is closed.
+	// The channels are not sent after the done channel is closed, so that the abandoned events do not block.
+	Events(done <-chan struct{}) (<-chan Event, <-chan error)
+	// DNSHelperPID returns the pid of the process resolving names for the containers, such as dockerd, or 0 if none.
+	DNSHelperPID() int
+	// ForwardChain returns the chain of iptables through which the packets forwarded to and from the containers pass, such as DOCKER-USER.
+	ForwardChain() string
+}
+
+// DNSHelperPID returns the pid of the DNS helper of the current runtime, or 0 if no runtime is set.
+func DNSHelperPID() int {
+	if CurrentRuntime == nil {
+		return 0
+	}
+	return CurrentRuntime.DNSHelperPID()
+}
+
+// InitializeContainers returns Containers of the running containers listed by the current runtime.
+func InitializeContainers() (containers *Containers, err error) {
+	logrus.Debug("trying to fetch container inspections")
+
+	if CurrentRuntime == nil {
+		err = errors.New("no container runtime set")
+		logrus.WithField("error", err).Debug("container inspections not fetched")
+		return
+	}
+	var list []*Container
+	list, err = CurrentRuntime.List()
+	if err != nil {
+		logrus.WithField("error", err).Debug("container inspections not fetched")
+		return
+	}
+	containers = NewContainers(list)
+
+	logrus.WithField("containers", containers).Debug("container inspections fetched")
+	return
+}
+
+// AddContainer adds the inspection of the container fetched from the current runtime.
+// The inspection of the container already added is replaced by the current one.
+func (c *Containers)AddContainer(cid string) (err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"containers": c,
+		"added_container_id": cid,
+	})
+	if CurrentRuntime == nil {
+		err = errors.New("no container runtime set")
+		argFields.WithField("error", err).Debug("failed to add container inspection")
+		return
+	}
+	var container *Container
+	container, err = CurrentRuntime.Inspect(cid)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to add container inspection")
+		return
+	}
+	c.Add(container)
+	argFields.Debug("container inspection added")
+	return
+}
+
+// UpdateContainer replaces the inspection of the container already added by the one fetched from the current runtime.
+// The container not added or not running is not updated, so that the events after the container dies do not add it again.
+func (c *Containers)UpdateContainer(cid string) (updated bool, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"containers": c,
+		"updated_container_id": cid,
+	})
+	if CurrentRuntime == nil {
+		err = errors.New("no container runtime set")
+		argFields.WithField("error", err).Debug("failed to update container inspection")
+		return
+	}
+	if _, exist := c.LookupByID(cid); !exist {
+		argFields.Debug("container inspection not updated, because the container not added")
+		return
+	}
+	var container *Container
+	container, err = CurrentRuntime.Inspect(cid)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to update container inspection")
+		return
+	}
+	if container.Pid == 0 {
+		argFields.Debug("container inspection not updated, because the container not running")
+		return
+	}
+	updated = c.Replace(container)
+	argFields.WithField("updated", updated).Debug("container inspection updated")
+	return
+}
+
+// RemoveContainer removes the inspection of the container.
+func (c *Containers)RemoveContainer(cid string) {
+	if _, exist := c.Remove(cid); !exist {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"containers": c,
+		"removed_container_id": cid,
+	}).Debug("container inspection removed")
+}
@@ -0,0 +1,166 @@
+package containerd
+
+import (
+	"context"
+
+	containers "github.com/containerd/containerd/api/services/containers/v1"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	tasks "github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// RuntimeName is the name of containerd as the container runtime.
+	RuntimeName string = "containerd"
+	// DefaultAddress is the unix socket of containerd API.
+	DefaultAddress string = "/run/containerd/containerd.sock"
+	// DefaultNamespace is the namespace of containerd used by nerdctl.
+	DefaultNamespace string = "default"
+
+	// namespaceHeader is the metadata of the namespace of containerd.
+	namespaceHeader string = "containerd-namespace"
+	// nameLabel is the label of the container name given by nerdctl.
+	nameLabel string = "nerdctl/name"
+	// hostPid is the process in the network namespace of the host.
+	hostPid int = 1
+	// forwardChain is the chain of iptables through which the packets of the CNI bridges are forwarded.
+	forwardChain string = "FORWARD"
+)
+
+// Runtime is the container runtime of containerd API, such as the containers run by nerdctl.
+// The IP addresses are retrieved from the network namespace of the task, because containerd does not manage the networks.
+// The connection is dialed again by gRPC after it is closed, such as by the restart of containerd.
+type Runtime struct {
+	conn       *grpc.ClientConn
+	tasks      tasks.TasksClient
+	containers containers.ContainersClient
+	events     events.EventsClient
+	address    string
+	namespace  string
+}
+
+var _ container.Runtime = (*Runtime)(nil)
+
+// NewRuntime returns the Runtime connecting to containerd API for the namespace of containerd.
+func NewRuntime(address, namespace string) (runtime *Runtime, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"address":   address,
+		"namespace": namespace,
+	})
+	argFields.Debug("trying to connect to containerd")
+
+	runtime = &Runtime{address: address, namespace: namespace}
+	runtime.conn, err = grpc.NewClient("unix:"+address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to connect to containerd")
+		return
+	}
+	runtime.tasks = tasks.NewTasksClient(runtime.conn)
+	runtime.containers = containers.NewContainersClient(runtime.conn)
+	runtime.events = events.NewEventsClient(runtime.conn)
+	argFields.Debug("containerd connected")
+	return
+}
+
+// context returns the context of the calls in the namespace of containerd.
+func (r *Runtime) context() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), namespaceHeader, r.namespace)
+}
+
+// Name returns RuntimeName.
+func (r *Runtime) Name() string {
+	return RuntimeName
+}
+
+// DNSHelperPID returns 0, because containerd does not resolve names for the containers.
+func (r *Runtime) DNSHelperPID() int {
+	return 0
+}
+
+// List returns the inspections of the running tasks.
+func (r *Runtime) List() (inspections []*container.Container, err error) {
+	logrus.Debug("trying to list containerd tasks")
+
+	var response *tasks.ListTasksResponse
+	response, err = r.tasks.List(r.context(), &tasks.ListTasksRequest{})
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to list containerd tasks")
+		return
+	}
+	for _, process := range response.GetTasks() {
+		if process.GetStatus() != task.Status_RUNNING {
+			continue
+		}
+		var inspection *container.Container
+		inspection, err = r.inspect(process.GetContainerID(), int(process.GetPid()))
+		if err != nil {
+			logrus.WithField("error", err).Debug("failed to list containerd tasks")
+			return
+		}
+		inspections = append(inspections, inspection)
+	}
+	logrus.WithField("inspections", inspections).Debug("containerd tasks listed")
+	return
+}
+
+// Inspect returns the inspection of the container having the task.
+func (r *Runtime) Inspect(cid string) (inspection *container.Container, err error) {
+	cidField := logrus.WithField("container_id", cid)
+	cidField.Debug("trying to get containerd task")
+
+	var response *tasks.GetResponse
+	response, err = r.tasks.Get(r.context(), &tasks.GetRequest{ContainerID: cid})
+	if err != nil {
+		cidField.WithField("error", err).Debug("failed to get containerd task")
+		return
+	}
+	inspection, err = r.inspect(cid, int(response.GetProcess().GetPid()))
+	return
+}
+
+// inspect returns the inspection of the container from its labels and the network namespace of the task.
+func (r *Runtime) inspect(cid string, pid int) (inspection *container.Container, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"container_id": cid,
+		"pid":          pid,
+	})
+	argFields.Debug("trying to inspect containerd container")
+
+	var response *containers.GetContainerResponse
+	response, err = r.containers.Get(r.context(), &containers.GetContainerRequest{ID: cid})
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to inspect containerd container")
+		return
+	}
+	labels := response.GetContainer().GetLabels()
+	inspection = &container.Container{ID: cid, Name: labels[nameLabel], Pid: pid, Labels: labels}
+	if inspection.Name == "" {
+		inspection.Name = cid
+	}
+
+	// NOTE: The container sharing the network namespace of the host has no IP address of its own.
+	networkNamespace, namespaceErr := proc.RetrieveNetworkNamespace(pid)
+	hostNetworkNamespace, hostErr := proc.RetrieveNetworkNamespace(hostPid)
+	if namespaceErr == nil && hostErr == nil && networkNamespace == hostNetworkNamespace {
+		inspection.HostNetwork = true
+	} else {
+		inspection.IPAddresses, err = proc.RetrieveIPAddresses(pid)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to inspect containerd container")
+			return
+		}
+	}
+	argFields.WithField("inspection", inspection).Debug("containerd container inspected")
+	return
+}
+
+// ForwardChain returns FORWARD, from which the chains of the CNI plugins used by nerdctl are jumped.
+func (r *Runtime) ForwardChain() string {
+	return forwardChain
+}
@@ -0,0 +1,108 @@
+package containerd
+
+import (
+	"context"
+
+	apievents "github.com/containerd/containerd/api/events"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	"github.com/containerd/containerd/api/types"
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+)
+
+// actions maps the topics of the task events to the actions of cnet.
+var actions map[string]container.EventAction = map[string]container.EventAction{
+	"/tasks/start":   container.ActionRun,
+	"/tasks/resumed": container.ActionRun,
+	"/tasks/paused":  container.ActionKill,
+	"/tasks/exit":    container.ActionKill,
+}
+
+// Events subscribes the task events of containerd.
+func (r *Runtime) Events(done <-chan struct{}) (<-chan container.Event, <-chan error) {
+	logrus.Debug("trying to subscribe containerd events")
+
+	request := &events.SubscribeRequest{}
+	for topic := range actions {
+		request.Filters = append(request.Filters, `topic=="`+topic+`"`)
+	}
+	eventCh, errCh := make(chan container.Event), make(chan error, 1)
+	ctx, cancel := context.WithCancel(r.context())
+	stream, err := r.events.Subscribe(ctx, request)
+	if err != nil {
+		cancel()
+		logrus.WithField("error", err).Debug("failed to subscribe containerd events")
+		errCh <- err
+		return eventCh, errCh
+	}
+	// NOTE: The stream is canceled when the done channel is closed, which ends the receiving goroutine.
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		for {
+			envelope, err := stream.Recv()
+			if err != nil {
+				logrus.WithField("error", err).Debug("containerd events not received")
+				errCh <- err
+				return
+			}
+			event, ok := r.parseEnvelope(envelope)
+			if !ok {
+				continue
+			}
+			select {
+			case eventCh <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return eventCh, errCh
+}
+
+// parseEnvelope returns the event of the container from the envelope of the task event in the namespace.
+func (r *Runtime) parseEnvelope(envelope *types.Envelope) (event container.Event, ok bool) {
+	if envelope.GetNamespace() != r.namespace {
+		return
+	}
+	topic := envelope.GetTopic()
+	action, exist := actions[topic]
+	if !exist {
+		return
+	}
+	message, err := envelope.GetEvent().UnmarshalNew()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"topic": topic,
+		}).Debug("failed to parse containerd event")
+		return
+	}
+	var cid string
+	switch taskEvent := message.(type) {
+	case *apievents.TaskStart:
+		cid = taskEvent.GetContainerID()
+	case *apievents.TaskResumed:
+		cid = taskEvent.GetContainerID()
+	case *apievents.TaskPaused:
+		cid = taskEvent.GetContainerID()
+	case *apievents.TaskExit:
+		// NOTE: The exit of the exec process is also notified, whose id differs from the container id.
+		if taskEvent.GetID() != taskEvent.GetContainerID() {
+			return
+		}
+		cid = taskEvent.GetContainerID()
+	}
+	logrus.WithFields(logrus.Fields{
+		"topic":        topic,
+		"container_id": cid,
+	}).Debug("containerd event received")
+	event, ok = container.Event{Action: action, ID: cid}, cid != ""
+	return
+}
@@ -0,0 +1,238 @@
+// Package cri is the container runtime of Kubernetes nodes through the container runtime interface (CRI), such as containerd and CRI-O.
+package cri
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+const (
+	// RuntimeName is the name of CRI as the container runtime.
+	RuntimeName string = "cri"
+
+	// forwardChain is the chain of iptables from which the chains of the CNI plugins are jumped.
+	forwardChain string = "FORWARD"
+	// infoKey is the key of the verbose information of the container in JSON, which has the pid of containerd and CRI-O.
+	infoKey string = "info"
+)
+
+var (
+	// DefaultAddresses are the unix sockets of CRI served by containerd and CRI-O, which are tried in order.
+	DefaultAddresses []string = []string{"/run/containerd/containerd.sock", "/var/run/crio/crio.sock"}
+)
+
+// Runtime is the container runtime of CRI. The containers are the containers of the pods, and the pod sandboxes are not listed.
+// The containers of the pod share the IP addresses of the pod sandbox.
+// The connection is dialed again by gRPC after it is closed, such as by the restart of the runtime.
+type Runtime struct {
+	conn    *grpc.ClientConn
+	client  runtimeapi.RuntimeServiceClient
+	address string
+}
+
+var _ container.Runtime = (*Runtime)(nil)
+
+// NewRuntime returns the Runtime connecting to CRI on the unix socket, or on the first existing one of DefaultAddresses if the address is empty.
+func NewRuntime(address string) (runtime *Runtime, err error) {
+	if address == "" {
+		address = DetectAddress()
+	}
+	addressField := logrus.WithField("address", address)
+	addressField.Debug("trying to connect to cri")
+
+	if address == "" {
+		err = errors.New("cri socket not found")
+		addressField.WithField("error", err).Debug("failed to connect to cri")
+		return
+	}
+	runtime = &Runtime{address: address}
+	runtime.conn, err = grpc.NewClient("unix:"+address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		addressField.WithField("error", err).Debug("failed to connect to cri")
+		return
+	}
+	runtime.client = runtimeapi.NewRuntimeServiceClient(runtime.conn)
+	addressField.Debug("cri connected")
+	return
+}
+
+// DetectAddress returns the first existing socket of DefaultAddresses, or empty if none.
+func DetectAddress() string {
+	for _, address := range DefaultAddresses {
+		if _, err := os.Stat(address); err == nil {
+			return address
+		}
+	}
+	return ""
+}
+
+// Name returns RuntimeName.
+func (r *Runtime) Name() string {
+	return RuntimeName
+}
+
+// DNSHelperPID returns 0, because the names are resolved by the pods of the cluster DNS.
+func (r *Runtime) DNSHelperPID() int {
+	return 0
+}
+
+// ForwardChain returns FORWARD, from which the chains of the CNI plugins of the node are jumped.
+func (r *Runtime) ForwardChain() string {
+	return forwardChain
+}
+
+// List returns the inspections of the running containers of the pods.
+func (r *Runtime) List() (inspections []*container.Container, err error) {
+	logrus.Debug("trying to list cri containers")
+
+	var items []*runtimeapi.Container
+	items, err = r.listContainers(&runtimeapi.ContainerFilter{State: &runtimeapi.ContainerStateValue{State: runtimeapi.ContainerState_CONTAINER_RUNNING}})
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to list cri containers")
+		return
+	}
+	// NOTE: The pod sandbox is inspected once for its containers.
+	pods := map[string]*podSandbox{}
+	for _, item := range items {
+		var inspection *container.Container
+		inspection, err = r.inspect(item, pods)
+		if err != nil {
+			logrus.WithField("error", err).Debug("failed to list cri containers")
+			return
+		}
+		inspections = append(inspections, inspection)
+	}
+	logrus.WithField("inspections", inspections).Debug("cri containers listed")
+	return
+}
+
+// Inspect returns the inspection of the container of the pod.
+func (r *Runtime) Inspect(cid string) (inspection *container.Container, err error) {
+	cidField := logrus.WithField("container_id", cid)
+	cidField.Debug("trying to inspect cri container")
+
+	var items []*runtimeapi.Container
+	items, err = r.listContainers(&runtimeapi.ContainerFilter{Id: cid})
+	if err == nil && len(items) == 0 {
+		err = errors.New("cri container not found")
+	}
+	if err != nil {
+		cidField.WithField("error", err).Debug("failed to inspect cri container")
+		return
+	}
+	inspection, err = r.inspect(items[0], map[string]*podSandbox{})
+	return
+}
+
+// listContainers returns the containers matched by the filter.
+func (r *Runtime) listContainers(filter *runtimeapi.ContainerFilter) (items []*runtimeapi.Container, err error) {
+	var response *runtimeapi.ListContainersResponse
+	response, err = r.client.ListContainers(context.Background(), &runtimeapi.ListContainersRequest{Filter: filter})
+	if err != nil {
+		return
+	}
+	items = response.GetContainers()
+	return
+}
+
+// podSandbox is the pod and its network inspected from the pod sandbox.
+type podSandbox struct {
+	pod         *container.Pod
+	ipAddresses []net.IP
+	hostNetwork bool
+}
+
+// inspect returns the inspection of the container from the container, its status and its pod sandbox.
+func (r *Runtime) inspect(item *runtimeapi.Container, pods map[string]*podSandbox) (inspection *container.Container, err error) {
+	cid, sandboxID := item.GetId(), item.GetPodSandboxId()
+	argFields := logrus.WithFields(logrus.Fields{
+		"container_id":   cid,
+		"pod_sandbox_id": sandboxID,
+	})
+	argFields.Debug("trying to inspect cri container")
+
+	sandbox, exist := pods[sandboxID]
+	if !exist {
+		sandbox, err = r.inspectPodSandbox(sandboxID)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to inspect cri container")
+			return
+		}
+		pods[sandboxID] = sandbox
+	}
+	var pid int
+	pid, err = r.retrievePID(cid)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to inspect cri container")
+		return
+	}
+	inspection = &container.Container{
+		ID:          cid,
+		IPAddresses: sandbox.ipAddresses,
+		Name:        item.GetMetadata().GetName(),
+		Pid:         pid,
+		HostNetwork: sandbox.hostNetwork,
+		Labels:      item.GetLabels(),
+		Pod:         sandbox.pod,
+	}
+	argFields.WithField("inspection", inspection).Debug("cri container inspected")
+	return
+}
+
+// inspectPodSandbox returns the pod and its network from the status of the pod sandbox.
+func (r *Runtime) inspectPodSandbox(sandboxID string) (sandbox *podSandbox, err error) {
+	var response *runtimeapi.PodSandboxStatusResponse
+	response, err = r.client.PodSandboxStatus(context.Background(), &runtimeapi.PodSandboxStatusRequest{PodSandboxId: sandboxID})
+	if err != nil {
+		return
+	}
+	status := response.GetStatus()
+	sandbox = &podSandbox{pod: &container.Pod{
+		ID:        sandboxID,
+		Name:      status.GetMetadata().GetName(),
+		Namespace: status.GetMetadata().GetNamespace(),
+		Labels:    status.GetLabels(),
+	}}
+	// NOTE: The pod of the host network has the IP address of the host, which is not the address of the pod.
+	if status.GetLinux().GetNamespaces().GetOptions().GetNetwork() == runtimeapi.NamespaceMode_NODE {
+		sandbox.hostNetwork = true
+		return
+	}
+	ipAddresses := []string{status.GetNetwork().GetIp()}
+	for _, podIP := range status.GetNetwork().GetAdditionalIps() {
+		ipAddresses = append(ipAddresses, podIP.GetIp())
+	}
+	for _, ipAddress := range ipAddresses {
+		if parsedIPAddress := net.ParseIP(ipAddress); parsedIPAddress != nil {
+			sandbox.ipAddresses = append(sandbox.ipAddresses, parsedIPAddress)
+		}
+	}
+	return
+}
+
+// retrievePID returns the pid of the main process of the container from the verbose information of its status.
+func (r *Runtime) retrievePID(cid string) (pid int, err error) {
+	var response *runtimeapi.ContainerStatusResponse
+	response, err = r.client.ContainerStatus(context.Background(), &runtimeapi.ContainerStatusRequest{ContainerId: cid, Verbose: true})
+	if err != nil {
+		return
+	}
+	var info struct {
+		Pid int `json:"pid"`
+	}
+	err = json.Unmarshal([]byte(response.GetInfo()[infoKey]), &info)
+	if err == nil && info.Pid == 0 {
+		err = errors.New("pid of cri container not found")
+	}
+	pid = info.Pid
+	return
+}
@@ -0,0 +1,62 @@
+package cri
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// actions maps ContainerEventType to the actions of cnet.
+var actions map[runtimeapi.ContainerEventType]container.EventAction = map[runtimeapi.ContainerEventType]container.EventAction{
+	runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT: container.ActionRun,
+	runtimeapi.ContainerEventType_CONTAINER_STOPPED_EVENT: container.ActionKill,
+}
+
+// Events subscribes the container events of CRI, which are supported by containerd v1.7 and CRI-O with the evented PLEG.
+func (r *Runtime) Events(done <-chan struct{}) (<-chan container.Event, <-chan error) {
+	logrus.Debug("trying to subscribe cri events")
+
+	eventCh, errCh := make(chan container.Event), make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := r.client.GetContainerEvents(ctx, &runtimeapi.GetEventsRequest{})
+	if err != nil {
+		cancel()
+		logrus.WithField("error", err).Debug("failed to subscribe cri events")
+		errCh <- err
+		return eventCh, errCh
+	}
+	// NOTE: The stream is canceled when the done channel is closed, which ends the receiving goroutine.
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	go func() {
+		defer cancel()
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				logrus.WithField("error", err).Debug("cri events not received")
+				errCh <- err
+				return
+			}
+			cid := event.GetContainerId()
+			logrus.WithFields(logrus.Fields{
+				"event_type":   event.GetContainerEventType(),
+				"container_id": cid,
+			}).Debug("cri event received")
+			if action, exist := actions[event.GetContainerEventType()]; exist && cid != "" {
+				select {
+				case eventCh <- container.Event{Action: action, ID: cid}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return eventCh, errCh
+}
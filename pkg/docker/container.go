@@ -2,100 +2,67 @@ package docker
 
 import (
 	"context"
-	"fmt"
 	"net"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/sirupsen/logrus"
 	"github.com/tomo-9925/cnet/pkg/container"
-	basedContainer "github.com/tomo-9925/cnet/pkg/container"
 )
 
-type Containers basedContainer.Containers
-
-func (c *Containers)String() string {
-	return fmt.Sprint(c.List)
-}
-
-func fetchContainerInspection(cid string) (container *basedContainer.Container, err error) {
+// Inspect returns the inspection of the docker container.
+func (r *Runtime) Inspect(cid string) (inspection *container.Container, err error) {
 	cidField := logrus.WithField("container_id", cid)
 	cidField.Debug("trying to fetch docker container inspection")
 
 	var inspect types.ContainerJSON
-	inspect, err = cli.ContainerInspect(context.Background(), cid)
+	inspect, err = r.cli.ContainerInspect(context.Background(), cid)
 	if err != nil {
 		cidField.WithField("error", err).Debug("container inspection not fetched")
 		return
 	}
 
 	cidField.WithField("container_inspection", inspect).Debug("container inspection fetched")
-	ipAddresses := make([]net.IP, 0, len(inspect.NetworkSettings.Networks))
+	ipAddresses := make([]net.IP, 0, 2*len(inspect.NetworkSettings.Networks))
 	for _, network := range inspect.NetworkSettings.Networks {
-		ipAddresses = append(ipAddresses, net.ParseIP(network.IPAddress))
+		for _, ipAddress := range []string{network.IPAddress, network.GlobalIPv6Address} {
+			if parsedIPAddress := net.ParseIP(ipAddress); parsedIPAddress != nil {
+				ipAddresses = append(ipAddresses, parsedIPAddress)
+			}
+		}
 	}
-	container = &basedContainer.Container{ID: inspect.ID, IPAddresses: ipAddresses, Name: inspect.Name, Pid: inspect.State.Pid}
+	hostNetwork := inspect.HostConfig != nil && inspect.HostConfig.NetworkMode.IsHost()
+	var labels map[string]string
+	if inspect.Config != nil {
+		labels = inspect.Config.Labels
+	}
+	inspection = &container.Container{ID: inspect.ID, IPAddresses: ipAddresses, Name: inspect.Name, Pid: inspect.State.Pid, HostNetwork: hostNetwork, Labels: labels}
 	return
 }
 
-// InitializeContainers return the information slice of Docker container.
-func InitializeContainers() (containers *Containers, err error) {
+// List returns the inspections of the running docker containers.
+// The paused containers are not listed, as they are removed by the pause events.
+func (r *Runtime) List() (inspections []*container.Container, err error) {
 	logrus.Debugln("trying to fetch docker container inspections")
 
+	filter := filters.NewArgs()
+	filter.Add("status", "running")
 	var dockerContainerList []types.Container
-	dockerContainerList, err = cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	dockerContainerList, err = r.cli.ContainerList(context.Background(), types.ContainerListOptions{Filters: filter})
 	if err != nil {
 		logrus.WithField("error", err).Debug("container list not fetched")
 		return
 	}
 
-	containers = &Containers{List: make([]*container.Container, len(dockerContainerList))}
+	inspections = make([]*container.Container, len(dockerContainerList))
 	for i, dockerContainer := range dockerContainerList {
-		var container *container.Container
-		container, err = fetchContainerInspection(dockerContainer.ID)
+		inspections[i], err = r.Inspect(dockerContainer.ID)
 		if err != nil {
 			logrus.WithField("error", err).Debug("container inspections not fetched")
 			return
 		}
-		containers.List[i] = container
 	}
 
-	logrus.WithField("containers", containers).Debug("container inspections fetched")
-	return
-}
-
-// AddContainer add container information to Containers List
-func (c *Containers)AddContainer(cid string) (err error) {
-	var container *basedContainer.Container
-	container, err = fetchContainerInspection(cid)
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"containers": c,
-			"added_container_id": cid,
-		}).Debug("failed to add container inspection")
-		return
-	}
-	c.RWMutex.Lock()
-	c.List = append(c.List, container)
-	c.RWMutex.Unlock()
-	logrus.WithFields(logrus.Fields{
-		"containers": c,
-		"added_container_id": cid,
-	}).Debug("container inspection added")
+	logrus.WithField("inspections", inspections).Debug("docker container inspections fetched")
 	return
 }
-
-// RemoveContainer removes container information from Containers List.
-func (c *Containers)RemoveContainer(cid string) {
-	for i, container := range c.List {
-		if container.ID == cid {
-			c.RWMutex.Lock()
-			c.List = append(c.List[:i], c.List[i+1:]...)
-			c.RWMutex.Unlock()
-			logrus.WithFields(logrus.Fields{
-				"containers": c,
-				"removed_container_id": cid,
-			}).Debug("container inspection removed")
-			return
-		}
-	}
-}
@@ -3,37 +3,86 @@ package docker
 import (
 	"io/ioutil"
 	"strconv"
-	"unsafe"
+	"strings"
 
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
 )
 
-var (
-	// PID is docker daemon pid
-	PID int
+const (
+	// RuntimeName is the name of Docker as the container runtime.
+	RuntimeName string = "docker"
 
-	cli  *client.Client
+	pidFilePath string = "/var/run/docker.pid"
+	// forwardChain is the chain of iptables prepared by docker for the rules of the user.
+	forwardChain string = "DOCKER-USER"
 )
 
-func init() {
+// Runtime is the container runtime of Docker Engine API.
+type Runtime struct {
+	cli *client.Client
+	// pid is docker daemon pid, which resolves names for the containers by the embedded DNS server.
+	pid int
+}
+
+var _ container.Runtime = (*Runtime)(nil)
+
+// NewRuntime returns the Runtime connecting to Docker Engine API by the environment variables.
+func NewRuntime() (runtime *Runtime, err error) {
 	logrus.Debug("trying to initialize docker engine api client")
-	var err error
-	cli, err = client.NewEnvClient()
-	cliField := logrus.WithField("client", *cli)
+	runtime = &Runtime{}
+	runtime.cli, err = client.NewEnvClient()
 	if err != nil {
-		cliField.WithField("error", err).Fatal("faild to initialize docker engine api client")
+		logrus.WithField("error", err).Debug("failed to initialize docker engine api client")
+		return
 	}
-	cliField.Debug("docker engine api client initialized")
+	logrus.WithField("client", runtime.cli).Debug("docker engine api client initialized")
 
 	logrus.Debug("trying to retrieve pid of docker daemon")
 	var file []byte
-	file, err = ioutil.ReadFile("/var/run/docker.pid")
+	file, err = ioutil.ReadFile(pidFilePath)
 	if err != nil {
-		logrus.WithField("error", err).Fatal("failed to retrieve dockerd process id")
+		// NOTE: The runtime can be used without docker daemon (e.g. testing with synthetic proc filesystem).
+		logrus.WithField("error", err).Warn("failed to retrieve dockerd process id")
+		err = nil
+		return
 	}
-	PID, err = strconv.Atoi(*(*string)(unsafe.Pointer(&file)))
+	runtime.pid, err = strconv.Atoi(strings.TrimSpace(string(file)))
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to retrieve dockerd process id")
+		return
+	}
+	logrus.WithField("pid", runtime.pid).Debug("pid of docker daemon retrieved")
+	return
+}
+
+// NewCompatibleRuntime returns the Runtime connecting to the API compatible with Docker Engine API on the host, such as podman.
+// The pid of docker daemon is not retrieved, because the daemon serving the API is not docker.
+func NewCompatibleRuntime(host string) (runtime *Runtime, err error) {
+	hostField := logrus.WithField("host", host)
+	hostField.Debug("trying to initialize docker engine api client")
+	runtime = &Runtime{}
+	runtime.cli, err = client.NewClient(host, client.DefaultVersion, nil, nil)
 	if err != nil {
-		logrus.WithField("error", err).Fatal("failed to retrieve dockerd process id")
+		hostField.WithField("error", err).Debug("failed to initialize docker engine api client")
+		return
 	}
+	hostField.WithField("client", runtime.cli).Debug("docker engine api client initialized")
+	return
+}
+
+// Name returns RuntimeName.
+func (r *Runtime) Name() string {
+	return RuntimeName
+}
+
+// DNSHelperPID returns docker daemon pid, which sends the DNS requests of the containers on behalf of them.
+func (r *Runtime) DNSHelperPID() int {
+	return r.pid
+}
+
+// ForwardChain returns DOCKER-USER, which docker jumps to before its own rules of the forwarded packets.
+func (r *Runtime) ForwardChain() string {
+	return forwardChain
 }
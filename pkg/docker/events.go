@@ -2,24 +2,84 @@ package docker
 
 import (
 	"context"
+	"io"
+	"path/filepath"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
 )
 
-// NewEventWatcher starts monitoring docker events.
-func NewEventWatcher() (msg <-chan events.Message, err <-chan error) {
-	logrus.Debugln("trying to fetch docker container inspection")
+// actions maps the actions of docker events to the actions of cnet.
+// NOTE: The compatible API of podman before v4 notifies "died" instead of "die".
+var actions map[string]container.EventAction = map[string]container.EventAction{
+	"start":   container.ActionRun,
+	"unpause": container.ActionRun,
+	"pause":   container.ActionKill,
+	"die":     container.ActionKill,
+	"died":    container.ActionKill,
+	"rename":  container.ActionUpdate,
+	// the network events of the container connected to or disconnected from the network
+	"connect":    container.ActionUpdate,
+	"disconnect": container.ActionUpdate,
+}
+
+// networkContainerAttribute is the attribute of the network events having the ID of the container.
+const networkContainerAttribute string = "container"
+
+// containerID returns the ID of the container of the docker event.
+func containerID(message events.Message) string {
+	if message.Type == events.NetworkEventType {
+		return message.Actor.Attributes[networkContainerAttribute]
+	}
+	return filepath.Base(message.ID)
+}
+
+// Events starts monitoring docker events.
+// The events end with the error, after which they must be subscribed again.
+func (r *Runtime) Events(done <-chan struct{}) (<-chan container.Event, <-chan error) {
+	logrus.Debugln("trying to monitor docker events")
 
 	filter := filters.NewArgs()
-	filter.Add("type", "container")
-	filter.Add("event", "start")
-	filter.Add("event", "unpause")
-	filter.Add("event", "pause")
-	filter.Add("event", "die")
-
-	msg, err = cli.Events(context.Background(), types.EventsOptions{Filters: filter})
-	return
+	filter.Add("type", events.ContainerEventType)
+	filter.Add("type", events.NetworkEventType)
+	for action := range actions {
+		filter.Add("event", action)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, errs := r.cli.Events(ctx, types.EventsOptions{Filters: filter})
+	eventCh, errCh := make(chan container.Event), make(chan error, 1)
+	// NOTE: The messages of docker client are never closed, and the errors are closed after the error is sent.
+	// The request is canceled when the done channel is closed, so that the goroutine of docker client also ends.
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case message := <-messages:
+				logrus.WithField("message", message).Debug("docker event received")
+				if action, ok := actions[message.Action]; ok && containerID(message) != "" {
+					select {
+					case eventCh <- container.Event{Action: action, ID: containerID(message)}:
+					case <-done:
+						logrus.Debug("docker events done")
+						return
+					}
+				}
+			case <-done:
+				logrus.Debug("docker events done")
+				return
+			case err := <-errs:
+				if err == nil {
+					err = io.EOF
+				}
+				logrus.WithField("error", err).Debug("docker events not received")
+				errCh <- err
+				return
+			}
+		}
+	}()
+	return eventCh, errCh
 }
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/network"
+	"github.com/tomo-9925/cnet/pkg/policy"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"github.com/tomo-9925/cnet/pkg/utility"
+)
+
+func AddContainerInspection(cid string, containers *container.Containers, policies *policy.Policies, waitGroup *sync.WaitGroup, semaphore chan int) {
+	waitGroup.Add(1)
+	semaphore <- 1
+	defer func(){
+		<- semaphore
+		waitGroup.Done()
+	}()
+
+	containerFields := logrus.WithFields(logrus.Fields{
+		"container_id": cid,
+		"containers":   containers,
+	})
+	err := containers.AddContainer(cid)
+	if err != nil {
+		containerFields.WithField("error", err).Error("failed to add the container inspection")
+	}
+	containerFields.WithField("containers", containers).Info("the container inspection added")
+
+	err = policies.Reload()
+	if err != nil {
+		containerFields.WithField("error", err).Error("failed to parse security policy")
+	}
+	logrus.WithField("policies", policies).Info("the security policy data reloaded")
+
+	SyncProtectedContainers(containers, policies)
+
+	utility.ClearCache()
+}
+
+// UpdateContainerInspection replaces the inspection of the container whose networks or name change,
+// so that the new IP addresses are identified and the policies for the name follow the container.
+func UpdateContainerInspection(cid string, containers *container.Containers, policies *policy.Policies, waitGroup *sync.WaitGroup, semaphore chan int) {
+	waitGroup.Add(1)
+	semaphore <- 1
+	defer func(){
+		<- semaphore
+		waitGroup.Done()
+	}()
+
+	containerFields := logrus.WithFields(logrus.Fields{
+		"container_id": cid,
+		"containers":   containers,
+	})
+	updated, err := containers.UpdateContainer(cid)
+	if err != nil {
+		containerFields.WithField("error", err).Error("failed to update the container inspection")
+		return
+	}
+	if !updated {
+		return
+	}
+	containerFields.WithField("containers", containers).Info("the container inspection updated")
+
+	err = policies.Reload()
+	if err != nil {
+		containerFields.WithField("error", err).Error("failed to parse security policy")
+	}
+	logrus.WithField("policies", policies).Info("the security policy data reloaded")
+
+	SyncProtectedContainers(containers, policies)
+
+	utility.ClearCache()
+}
+
+func RemoveContainerInspection(cid string, containers *container.Containers, policies *policy.Policies, waitGroup *sync.WaitGroup, semaphore chan int) {
+	waitGroup.Add(1)
+	semaphore <- 1
+	defer func(){
+		<- semaphore
+		waitGroup.Done()
+	}()
+
+	containers.RemoveContainer(cid)
+	proc.ContainerProcesses.RemoveContainer(cid)
+	proc.NetworkNamespaceCache.Delete(cid)
+	logrus.WithFields(logrus.Fields{
+		"container_id": cid,
+		"containers":   containers,
+	}).Info("the container inspection removed")
+
+	SyncProtectedContainers(containers, policies)
+
+	utility.ClearCache()
+}
+
+// syncMutex serializes the synchronizations, so that the jump rules are not replaced by the stale IP addresses.
+var syncMutex sync.Mutex
+
+// SyncProtectedContainers sets the jump rules for the IP addresses of the containers protected by the policy,
+// so that the packets of the other containers are not queued.
+// The containers of the host network have no IP address of their own, so the packets of the sockets in their cgroups
+// and the packets to the ports they listen on are queued for them.
+func SyncProtectedContainers(containers *container.Containers, policies *policy.Policies) {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	var (
+		ipAddresses []net.IP
+		hostCgroups []string
+		hostPorts   []network.HostPort
+	)
+	// NOTE: The containers started with --network container:<id> send and receive the packets by the IP addresses of the container,
+	// so the IP addresses of every container in the network namespace of a protected container are jumped.
+	protectedNetworkNamespaces := map[string]struct{}{}
+	containers.RWMutex.RLock()
+	for _, container := range containers.List {
+		if policies.Protects(container) {
+			ipAddresses = append(ipAddresses, container.IPAddresses...)
+			if container.HostNetwork {
+				hostCgroup, hostCgroupErr := hostCgroupOfContainer(container)
+				if hostCgroupErr != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":            hostCgroupErr,
+						"target_container": container,
+					}).Warn("the cgroup of the container of the host network not found, so its packets are not queued")
+					continue
+				}
+				hostCgroups = append(hostCgroups, hostCgroup)
+				hostPorts = append(hostPorts, hostPortsOfContainer(container)...)
+			}
+			if networkNamespace, err := proc.NetworkNamespaceOfContainer(container); err == nil {
+				protectedNetworkNamespaces[networkNamespace] = struct{}{}
+			}
+		}
+	}
+	for _, container := range containers.List {
+		if networkNamespace, err := proc.NetworkNamespaceOfContainer(container); err == nil {
+			if _, ok := protectedNetworkNamespaces[networkNamespace]; ok {
+				ipAddresses = append(ipAddresses, container.IPAddresses...)
+			}
+		}
+	}
+	containers.RWMutex.RUnlock()
+
+	updated, err := network.ProtectedContainers.Update(ipAddresses, hostCgroups, hostPorts)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":        err,
+			"ip_addresses": ipAddresses,
+			"host_cgroups": hostCgroups,
+			"host_ports":   hostPorts,
+		}).Error("failed to update the jump rules of the protected containers")
+		return
+	}
+	if updated {
+		logrus.WithField("protected_containers", network.ProtectedContainers).Info("the jump rules of the protected containers synchronized")
+	}
+}
+
+// SyncHostPorts synchronizes the jump rules at the interval until quit is closed,
+// because the ports which the containers of the host network listen on change without the events of the containers.
+func SyncHostPorts(containers *container.Containers, policies *policy.Policies, interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		SyncProtectedContainers(containers, policies)
+	}
+}
+
+// hostCgroupOfContainer returns the cgroup of the container of the host network in the unified hierarchy.
+// An error is returned if the cgroup is unknown, such as on cgroup v1 only, or the root, which has every socket of the host.
+func hostCgroupOfContainer(targetContainer *container.Container) (cgroup string, err error) {
+	cgroup, err = proc.RetrieveUnifiedCgroupPath(targetContainer.Pid)
+	if err == nil && cgroup == "/" {
+		err = errors.New("the container in the root cgroup")
+	}
+	return
+}
+
+// hostPortsOfContainer returns the ports which the container of the host network listens on.
+// The packets to the ports not listed are not queued in INPUT, so the error is only logged.
+func hostPortsOfContainer(targetContainer *container.Container) (hostPorts []network.HostPort) {
+	ports, err := proc.ListeningPortsOfContainer(targetContainer)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":            err,
+			"target_container": targetContainer,
+		}).Warn("failed to list the ports of the container of the host network")
+		return
+	}
+	for _, port := range ports {
+		hostPorts = append(hostPorts, network.HostPort{Protocol: strings.ToLower(port.Protocol.String()), Port: port.Port})
+	}
+	return
+}
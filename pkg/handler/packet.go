@@ -1,35 +1,61 @@
 package handler
 
 import (
-	"sync"
+	"errors"
 	"time"
 
 	"github.com/AkihiroSuda/go-netfilter-queue"
+	"github.com/google/gopacket"
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/conntrack"
 	"github.com/tomo-9925/cnet/pkg/container"
-	"github.com/tomo-9925/cnet/pkg/docker"
 	"github.com/tomo-9925/cnet/pkg/policy"
 	"github.com/tomo-9925/cnet/pkg/proc"
 )
 
-func PacketHandler(p *netfilter.NFPacket, containers *docker.Containers, policies *policy.Policies, waitGroup *sync.WaitGroup, semaphore chan int) {
-	waitGroup.Add(1)
-	semaphore <- 1
-	defer func(){
-		<- semaphore
-		waitGroup.Done()
-	}()
+// verdictBacklog is the number of the verdicts waiting for the recorder.
+const verdictBacklog int = 256
 
-	logrus.WithField("packet", *p).Debug("the packet received")
+// verdictRecord is the verdict of the flow to be recorded in conntrack table.
+type verdictRecord struct {
+	decisions []*endpointDecision
+	accepted  bool
+}
+
+// verdictRecords are the verdicts recorded one by one by RecordVerdicts, so that the handlers do not wait for the flows confirmed.
+var verdictRecords chan verdictRecord = make(chan verdictRecord, verdictBacklog)
+
+// PacketHandler decides the verdict of the packet.
+// The packet between the containers is accepted only if both the egress policy of the sender and the ingress policy of the receiver define it.
+func PacketHandler(p *GuardedPacket, containers *container.Containers, policies *policy.Policies) {
+	logrus.WithField("packet", *p.NFPacket).Debug("the packet received")
 	var (
-		existCache            bool
-		timeReceivedPacket    time.Time = time.Now()
-		targetSocket          *proc.Socket
-		communicatedContainer *container.Container
-		communicatedProcess   *proc.Process
-		err                   error
+		timeReceivedPacket time.Time = time.Now()
+		endpoints          []*proc.Endpoint
+		decisions          []*endpointDecision
+		accepted           bool = true
+		err                error
 	)
-	targetSocket, communicatedContainer, err = proc.CheckSocketAndCommunicatedDockerContainer(&p.Packet, containers)
+	endpoints, err = proc.CheckSocketsAndCommunicatedDockerContainers(&p.Packet, containers)
+	// NOTE: The packets of the host are queued with the packets of the containers of the host network.
+	// The packet whose owner is ambiguous is dropped only if any candidate of the owner is protected.
+	if errors.Is(err, proc.ErrOwnerNotFound) && !protectsAnyEndpoint(endpoints, policies) {
+		err = proc.ErrContainerNotFound
+	}
+	if errors.Is(err, proc.ErrContainerNotFound) {
+		p.SetVerdict(netfilter.NF_ACCEPT)
+		logrus.WithField("processing_time", time.Since(timeReceivedPacket)).Debug("the packet of no container accepted")
+		return
+	}
+	if errors.Is(err, proc.ErrOwnerNotFound) {
+		p.SetVerdict(netfilter.NF_DROP)
+		logrus.WithFields(logrus.Fields{
+			"error":           err,
+			"endpoints":       endpoints,
+			"processing_time": time.Since(timeReceivedPacket),
+		}).Warn("the packet of the host network attributed to several containers dropped")
+		return
+	}
 	if err != nil {
 		p.SetVerdict(netfilter.NF_DROP)
 		logrus.WithFields(logrus.Fields{
@@ -38,28 +64,139 @@ func PacketHandler(p *netfilter.NFPacket, containers *docker.Containers, policie
 			}).Warn("the packet with unspecified structure dropped")
 		return
 	}
-	_, existCache = proc.SocketCache.Get(targetSocket.Hash())
-	communicatedProcess, err = proc.IdentifyProcessOfContainer(targetSocket, communicatedContainer, &p.Packet)
-	if err != nil {
-		p.SetVerdict(netfilter.NF_DROP)
-		logrus.WithField("error", err).WithFields(logrus.Fields{
-			"communicated_container": communicatedContainer,
+	for _, endpoint := range endpoints {
+		if !policies.Protects(endpoint.Container) {
+			logrus.WithField("communicated_container", endpoint.Container).Debug("the endpoint of the unprotected container not evaluated")
+			continue
+		}
+		var decision *endpointDecision
+		decision, err = decideEndpoint(endpoint, &p.Packet, policies)
+		if err != nil {
+			p.SetVerdict(netfilter.NF_DROP)
+			logrus.WithField("error", err).WithFields(logrus.Fields{
+				"communicated_container": endpoint.Container,
+				"processing_time": time.Since(timeReceivedPacket),
+				"target_socket":          endpoint.Socket,
+			}).Warn("the packet with unidentified process dropped")
+			return
+		}
+		decisions = append(decisions, decision)
+		accepted = accepted && decision.defined
+	}
+	if len(decisions) == 0 {
+		p.SetVerdict(netfilter.NF_ACCEPT)
+		logrus.WithFields(logrus.Fields{
+			"endpoints": endpoints,
 			"processing_time": time.Since(timeReceivedPacket),
-			"target_socket":          targetSocket,
-		}).Warn("the packet with unidentified process dropped")
+		}).Debug("the packet of the unprotected container accepted")
 		return
 	}
+	verdictFields := logrus.WithFields(logrus.Fields{
+		"endpoints":       endpoints,
+		"processing_time": time.Since(timeReceivedPacket),
+	})
+	if accepted {
+		p.SetVerdict(netfilter.NF_ACCEPT)
+		verdictFields.Info("the defined packet accepted")
+	} else {
+		p.SetVerdict(netfilter.NF_DROP)
+		verdictFields.Info("the undefined packet dropped")
+	}
+	select {
+	case verdictRecords <- verdictRecord{decisions: decisions, accepted: accepted}:
+	default:
+		logrus.WithField("endpoints", endpoints).Debug("the recorder of the verdicts busy, so the verdict of the flow not recorded")
+	}
+}
+
+// protectsAnyEndpoint reports whether the container of any endpoint is protected by the policy.
+func protectsAnyEndpoint(endpoints []*proc.Endpoint, policies *policy.Policies) bool {
+	for _, endpoint := range endpoints {
+		if policies.Protects(endpoint.Container) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointDecision is the decision of the policy of the container at the endpoint.
+type endpointDecision struct {
+	endpoint  *proc.Endpoint
+	processes []*proc.Process
+	defined   bool
+}
+
+// decideEndpoint identifies the processes owning the socket of the endpoint and checks the policy of the container for them.
+// The sender is checked by the egress policy, and the receiver is checked by the ingress policy.
+func decideEndpoint(endpoint *proc.Endpoint, packet *gopacket.Packet, policies *policy.Policies) (decision *endpointDecision, err error) {
+	attribution := "proc"
+	_, existCache := proc.SocketCache.Get(endpoint.Socket.Hash())
+	processes, err := proc.IdentifyProcessesOfContainer(endpoint.Socket, endpoint.Container, packet)
+	if err != nil {
+		// NOTE: The socket of short-lived process may have already gone.
+		recalledProcess, recallErr := proc.RecallProcessOfContainer(endpoint.Socket, endpoint.Container)
+		if recallErr != nil {
+			return
+		}
+		processes, err = []*proc.Process{recalledProcess}, nil
+		attribution = "history"
+	}
+	decision = &endpointDecision{
+		endpoint:  endpoint,
+		processes: processes,
+		defined:   policies.IsDefinedForOwners(endpoint.Container, processes, endpoint.Socket),
+	}
+
+	direction := "egress"
+	if endpoint.Socket.Inbound {
+		direction = "ingress"
+	}
 	communicationFields := logrus.WithFields(logrus.Fields{
+		"attribution":            attribution,
 		"has_used_cache":         existCache,
-		"target_socket":          targetSocket,
-		"communicated_container": communicatedContainer,
-		"communicated_process":   communicatedProcess,
+		"direction":              direction,
+		"target_socket":          endpoint.Socket,
+		"communicated_container": endpoint.Container,
+		"communicated_process":   processes[0],
 	})
-	if policies.IsDefined(communicatedContainer, communicatedProcess, targetSocket) {
-		p.SetVerdict(netfilter.NF_ACCEPT)
-		communicationFields.WithField("processing_time", time.Since(timeReceivedPacket)).Info("the defined packet accepted")
+	if len(processes) > 1 {
+		communicationFields = communicationFields.WithField("socket_owners", processes)
+	}
+	if decision.defined {
+		communicationFields.Info("the packet defined by the policy of the container")
+	} else {
+		communicationFields.Info("the packet undefined by the policy of the container")
+	}
+	return
+}
+
+// RecordVerdicts records the verdicts issued by PacketHandler until the program exits.
+func RecordVerdicts() {
+	for record := range verdictRecords {
+		recordVerdict(record.decisions, record.accepted)
+	}
+}
+
+// recordVerdict records the verdict of the flow in conntrack table, so that the subsequent packets of the flow are decided in the kernel.
+// The flow is recorded in the flow table only after the verdict is recorded, because the flow not in conntrack table is never destroyed.
+func recordVerdict(decisions []*endpointDecision, accepted bool) {
+	// NOTE: The sender and the receiver share the flow in conntrack table, so the verdict is recorded once.
+	targetSocket := decisions[0].endpoint.Socket
+	var err error
+	if accepted {
+		err = conntrack.Verdicts.Accept(targetSocket)
+	} else {
+		err = conntrack.Verdicts.Deny(targetSocket)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":         err,
+			"target_socket": targetSocket,
+			"accepted":      accepted,
+		}).Debug("failed to record the verdict of the flow")
 		return
 	}
-	p.SetVerdict(netfilter.NF_DROP)
-	communicationFields.WithField("processing_time", time.Since(timeReceivedPacket)).Info("the undefined packet dropped")
+	for _, decision := range decisions {
+		conntrack.Flows.Record(decision.endpoint.Socket, decision.endpoint.Container, decision.processes, accepted)
+	}
 }
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/AkihiroSuda/go-netfilter-queue"
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/policy"
+)
+
+const (
+	// verdictTimeout is the time limit of the decision, because the queue is blocked until the verdict is issued.
+	// The decision scanning proc filesystem takes tens of milliseconds at most, so the handler beyond the limit is stuck.
+	verdictTimeout time.Duration = 100 * time.Millisecond
+	// workBacklog is the number of the packets waiting for the worker of the queue.
+	workBacklog int = 64
+	// maxRunningHandlers is the limit of the handlers of the queue, including the handlers still running after the time limit.
+	maxRunningHandlers int = 8
+)
+
+// GuardedPacket is the packet whose verdict is issued at most once, because the binding is blocked by the second verdict.
+type GuardedPacket struct {
+	*netfilter.NFPacket
+	issued bool
+	mutex  sync.Mutex
+}
+
+// SetVerdict issues the verdict if no verdict has been issued, and reports whether it is issued.
+func (p *GuardedPacket) SetVerdict(v netfilter.Verdict) (issued bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.issued {
+		return
+	}
+	p.NFPacket.SetVerdict(v)
+	p.issued, issued = true, true
+	return
+}
+
+// ServeQueue reads the packets of the queue and handles them one by one by the dedicated worker of the queue.
+// The packets of a flow are distributed to the same queue by --queue-balance, so they keep the relative order,
+// while the different flows are handled in parallel by the workers of the other queues.
+// The fallbackVerdict is issued to the packet whose verdict is not decided in time or could not be issued.
+func ServeQueue(queueNum uint16, queue *netfilter.NFQueue, containers *container.Containers, policies *policy.Policies, fallbackVerdict netfilter.Verdict, waitGroup *sync.WaitGroup) {
+	queueFields := logrus.WithFields(logrus.Fields{
+		"queue_num":        queueNum,
+		"fallback_verdict": fallbackVerdict,
+	})
+	queueFields.Debug("the queue served")
+
+	// NOTE: The reader must always be ready to receive, because the packet arriving without a receiver is dropped by the binding.
+	// So the packet is not handed to the worker busy with the backlog, and the fallback verdict is issued instead.
+	work := make(chan *GuardedPacket, workBacklog)
+	handlers := make(chan struct{}, maxRunningHandlers)
+	go func() {
+		for p := range work {
+			handleGuardedPacket(queueFields, p, containers, policies, fallbackVerdict, handlers)
+			waitGroup.Done()
+		}
+	}()
+	for p := range queue.GetPackets() {
+		p := p
+		guardedPacket := &GuardedPacket{NFPacket: &p}
+		waitGroup.Add(1)
+		select {
+		case work <- guardedPacket:
+		default:
+			guardedPacket.SetVerdict(fallbackVerdict)
+			waitGroup.Done()
+			queueFields.WithField("packet", p.Packet).Error("the worker of the queue busy, so the fallback verdict issued")
+		}
+	}
+	close(work)
+	queueFields.Debug("the queue closed")
+}
+
+// handleGuardedPacket runs PacketHandler with the time limit, and issues the fallback verdict if the handler did not.
+// The handler cannot be interrupted, so the handlers running after the time limit are bounded by the handlers semaphore,
+// and the fallback verdict is issued without the handler while it is full.
+func handleGuardedPacket(queueFields *logrus.Entry, p *GuardedPacket, containers *container.Containers, policies *policy.Policies, fallbackVerdict netfilter.Verdict, handlers chan struct{}) {
+	var (
+		err                error
+		timeReceivedPacket time.Time     = time.Now()
+		done               chan struct{} = make(chan struct{})
+	)
+	select {
+	case handlers <- struct{}{}:
+		go func() {
+			defer func() { <-handlers }()
+			defer close(done)
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					queueFields.WithFields(logrus.Fields{
+						"error":  recovered,
+						"packet": p.Packet,
+					}).Error("the packet handler panicked")
+				}
+			}()
+			PacketHandler(p, containers, policies)
+		}()
+
+		timer := time.NewTimer(verdictTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+			err = errors.New("the handler returned without the verdict")
+		case <-timer.C:
+			// NOTE: The verdict issued by the handler after the time limit is ignored.
+			err = errors.New("the verdict not decided in time")
+		}
+	default:
+		err = errors.New("too many handlers running after the time limit")
+	}
+	if p.SetVerdict(fallbackVerdict) {
+		queueFields.WithFields(logrus.Fields{
+			"error":           err,
+			"packet":          p.Packet,
+			"processing_time": time.Since(timeReceivedPacket),
+		}).Error("the fallback verdict issued")
+	}
+}
@@ -0,0 +1,145 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Attribute is a netlink attribute.
+type Attribute struct {
+	Type uint16
+	Data []byte
+}
+
+// NewAttribute returns Attribute having the raw data.
+func NewAttribute(attributeType uint16, data []byte) Attribute {
+	return Attribute{Type: attributeType, Data: data}
+}
+
+// NewNestedAttribute returns Attribute having the attributes.
+func NewNestedAttribute(attributeType uint16, attributes ...Attribute) Attribute {
+	return Attribute{Type: attributeType | unix.NLA_F_NESTED, Data: MarshalAttributes(attributes)}
+}
+
+// NewStringAttribute returns Attribute having the null-terminated string.
+func NewStringAttribute(attributeType uint16, value string) Attribute {
+	return Attribute{Type: attributeType, Data: append([]byte(value), 0)}
+}
+
+// NewUint8Attribute returns Attribute having the value.
+func NewUint8Attribute(attributeType uint16, value uint8) Attribute {
+	return Attribute{Type: attributeType, Data: []byte{value}}
+}
+
+// NewUint16Attribute returns Attribute having the value in network byte order.
+func NewUint16Attribute(attributeType uint16, value uint16) Attribute {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, value)
+	return Attribute{Type: attributeType, Data: data}
+}
+
+// NewUint32Attribute returns Attribute having the value in network byte order.
+func NewUint32Attribute(attributeType uint16, value uint32) Attribute {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, value)
+	return Attribute{Type: attributeType, Data: data}
+}
+
+// NewUint64Attribute returns Attribute having the value in network byte order.
+func NewUint64Attribute(attributeType uint16, value uint64) Attribute {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, value)
+	return Attribute{Type: attributeType, Data: data}
+}
+
+// Kind returns the type of the attribute without the flags.
+func (a *Attribute) Kind() uint16 {
+	return a.Type &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+}
+
+// Uint8 returns the value of the attribute.
+func (a *Attribute) Uint8() uint8 {
+	if len(a.Data) < 1 {
+		return 0
+	}
+	return a.Data[0]
+}
+
+// Uint16 returns the value of the attribute in network byte order.
+func (a *Attribute) Uint16() uint16 {
+	if len(a.Data) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(a.Data)
+}
+
+// Uint32 returns the value of the attribute in network byte order.
+func (a *Attribute) Uint32() uint32 {
+	if len(a.Data) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(a.Data)
+}
+
+// Uint64 returns the value of the attribute in network byte order.
+func (a *Attribute) Uint64() uint64 {
+	if len(a.Data) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(a.Data)
+}
+
+// String returns the null-terminated string of the attribute.
+func (a *Attribute) String() string {
+	for i, b := range a.Data {
+		if b == 0 {
+			return string(a.Data[:i])
+		}
+	}
+	return string(a.Data)
+}
+
+// Nested returns the attributes nested in the attribute.
+func (a *Attribute) Nested() ([]Attribute, error) {
+	return UnmarshalAttributes(a.Data)
+}
+
+// MarshalAttributes returns the binary of the attributes.
+func MarshalAttributes(attributes []Attribute) (buf []byte) {
+	for _, attribute := range attributes {
+		length := unix.SizeofNlAttr + len(attribute.Data)
+		encoded := make([]byte, nlaAlign(length))
+		NativeEndian.PutUint16(encoded[0:2], uint16(length))
+		NativeEndian.PutUint16(encoded[2:4], attribute.Type)
+		copy(encoded[unix.SizeofNlAttr:], attribute.Data)
+		buf = append(buf, encoded...)
+	}
+	return
+}
+
+// UnmarshalAttributes returns the attributes from the binary.
+func UnmarshalAttributes(buf []byte) (attributes []Attribute, err error) {
+	for len(buf) >= unix.SizeofNlAttr {
+		length := int(NativeEndian.Uint16(buf[0:2]))
+		if length < unix.SizeofNlAttr || length > len(buf) {
+			err = errors.New("netlink attribute length invalid")
+			return
+		}
+		attributes = append(attributes, Attribute{
+			Type: NativeEndian.Uint16(buf[2:4]),
+			Data: buf[unix.SizeofNlAttr:length],
+		})
+		next := nlaAlign(length)
+		if next > len(buf) {
+			break
+		}
+		buf = buf[next:]
+	}
+	return
+}
+
+func nlaAlign(length int) int {
+	return (length + unix.NLA_ALIGNTO - 1) &^ (unix.NLA_ALIGNTO - 1)
+}
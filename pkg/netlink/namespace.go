@@ -0,0 +1,64 @@
+package netlink
+
+import (
+	"os"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// DialNetworkNamespace opens the netlink socket of the protocol in the network namespace of the path, such as /proc/<pid>/ns/net.
+// The socket stays in the network namespace, so that the requests such as sock_diag are answered about the namespace.
+func DialNetworkNamespace(protocol int, namespacePath string) (conn *Conn, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"protocol":       protocol,
+		"namespace_path": namespacePath,
+	})
+	argFields.Debug("trying to dial netlink socket in network namespace")
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	// NOTE: The namespace is switched on the locked thread. If the thread cannot return to the original namespace,
+	// it is not unlocked, so that the thread is terminated with the goroutine.
+	go func() {
+		runtime.LockOSThread()
+		original, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			runtime.UnlockOSThread()
+			resultCh <- result{err: err}
+			return
+		}
+		defer original.Close()
+		target, err := os.Open(namespacePath)
+		if err != nil {
+			runtime.UnlockOSThread()
+			resultCh <- result{err: err}
+			return
+		}
+		defer target.Close()
+		if err = unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			resultCh <- result{err: err}
+			return
+		}
+		conn, dialErr := Dial(protocol)
+		if err = unix.Setns(int(original.Fd()), unix.CLONE_NEWNET); err != nil {
+			logrus.WithField("error", err).Warn("failed to restore network namespace, so the thread is terminated")
+		} else {
+			runtime.UnlockOSThread()
+		}
+		resultCh <- result{conn: conn, err: dialErr}
+	}()
+	dialed := <-resultCh
+	conn, err = dialed.conn, dialed.err
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to dial netlink socket in network namespace")
+		return
+	}
+	argFields.WithField("port_id", conn.pid).Debug("netlink socket dialed in network namespace")
+	return
+}
@@ -0,0 +1,242 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	receiveBufferSize int = 1 << 16
+)
+
+// NativeEndian is the byte order used in the header of netlink messages and attributes.
+var NativeEndian binary.ByteOrder
+
+func init() {
+	buf := [2]byte{}
+	*(*uint16)(unsafe.Pointer(&buf[0])) = uint16(0xABCD)
+	switch buf {
+	case [2]byte{0xCD, 0xAB}:
+		NativeEndian = binary.LittleEndian
+	case [2]byte{0xAB, 0xCD}:
+		NativeEndian = binary.BigEndian
+	default:
+		logrus.Fatalln("native endianness not determined")
+	}
+}
+
+// Message is a netlink message.
+type Message struct {
+	Header unix.NlMsghdr
+	Data   []byte
+}
+
+// Conn is a netlink socket.
+type Conn struct {
+	fd  int
+	pid uint32
+	seq uint32
+}
+
+// Dial opens the netlink socket of the protocol and joins the multicast groups.
+func Dial(protocol int, groups ...uint32) (conn *Conn, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"protocol": protocol,
+		"groups":   groups,
+	})
+	argFields.Debug("trying to dial netlink socket")
+
+	var fd int
+	fd, err = unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to dial netlink socket")
+		return
+	}
+	err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	if err != nil {
+		unix.Close(fd)
+		argFields.WithField("error", err).Debug("failed to dial netlink socket")
+		return
+	}
+	var sockaddr unix.Sockaddr
+	sockaddr, err = unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		argFields.WithField("error", err).Debug("failed to dial netlink socket")
+		return
+	}
+	conn = &Conn{fd: fd, pid: sockaddr.(*unix.SockaddrNetlink).Pid}
+	for _, group := range groups {
+		err = unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_ADD_MEMBERSHIP, int(group))
+		if err != nil {
+			unix.Close(fd)
+			conn = nil
+			argFields.WithField("error", err).Debug("failed to dial netlink socket")
+			return
+		}
+	}
+
+	argFields.WithField("port_id", conn.pid).Debug("netlink socket dialed")
+	return
+}
+
+// SetReadBuffer sets the size of the receive buffer of the socket.
+func (c *Conn) SetReadBuffer(bytes int) (err error) {
+	err = unix.SetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, bytes)
+	if err != nil {
+		err = unix.SetsockoptInt(c.fd, unix.SOL_SOCKET, unix.SO_RCVBUF, bytes)
+	}
+	return
+}
+
+// Close closes the socket.
+func (c *Conn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// Send sends the message to the kernel, and returns the sequence number of the message.
+func (c *Conn) Send(message Message) (seq uint32, err error) {
+	seq = atomic.AddUint32(&c.seq, 1)
+	message.Header.Len = uint32(unix.NLMSG_HDRLEN + len(message.Data))
+	message.Header.Seq = seq
+	message.Header.Pid = c.pid
+	err = unix.Sendto(c.fd, marshalMessage(message), 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	return
+}
+
+// SendBatch sends the messages to the kernel at once, and returns the sequence numbers of the messages.
+func (c *Conn) SendBatch(messages []Message) (seqs []uint32, err error) {
+	var buf []byte
+	seqs = make([]uint32, len(messages))
+	for i, message := range messages {
+		seqs[i] = atomic.AddUint32(&c.seq, 1)
+		message.Header.Len = uint32(unix.NLMSG_HDRLEN + len(message.Data))
+		message.Header.Seq = seqs[i]
+		message.Header.Pid = c.pid
+		buf = append(buf, marshalMessage(message)...)
+	}
+	err = unix.Sendto(c.fd, buf, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+	return
+}
+
+// Receive receives the messages from the kernel.
+func (c *Conn) Receive() (messages []Message, err error) {
+	buf := make([]byte, receiveBufferSize)
+	var n int
+	n, _, err = unix.Recvfrom(c.fd, buf, 0)
+	if err != nil {
+		return
+	}
+	messages, err = parseMessages(buf[:n])
+	return
+}
+
+// Execute sends the request message with the acknowledgement flag, and returns the reply messages.
+func (c *Conn) Execute(message Message) (replies []Message, err error) {
+	message.Header.Flags |= unix.NLM_F_REQUEST | unix.NLM_F_ACK
+	var seq uint32
+	seq, err = c.Send(message)
+	if err != nil {
+		return
+	}
+	replies, err = c.receiveUntilAcknowledged(map[uint32]struct{}{seq: {}})
+	return
+}
+
+// ExecuteBatch sends the request messages at once, and returns the reply messages.
+// Only the messages having the acknowledgement flag are waited for, because the messages enclosing the batch are not acknowledged.
+func (c *Conn) ExecuteBatch(messages []Message) (replies []Message, err error) {
+	for i := range messages {
+		messages[i].Header.Flags |= unix.NLM_F_REQUEST
+	}
+	var seqs []uint32
+	seqs, err = c.SendBatch(messages)
+	if err != nil {
+		return
+	}
+	waiting := make(map[uint32]struct{}, len(seqs))
+	for i, seq := range seqs {
+		if messages[i].Header.Flags&unix.NLM_F_ACK != 0 {
+			waiting[seq] = struct{}{}
+		}
+	}
+	replies, err = c.receiveUntilAcknowledged(waiting)
+	return
+}
+
+// receiveUntilAcknowledged receives the reply messages until the requests of the sequence numbers are acknowledged or done.
+func (c *Conn) receiveUntilAcknowledged(waiting map[uint32]struct{}) (replies []Message, err error) {
+	for len(waiting) != 0 {
+		var messages []Message
+		messages, err = c.Receive()
+		if err != nil {
+			return
+		}
+		for _, message := range messages {
+			if _, ok := waiting[message.Header.Seq]; !ok {
+				continue
+			}
+			switch message.Header.Type {
+			case unix.NLMSG_ERROR:
+				delete(waiting, message.Header.Seq)
+				if len(message.Data) < 4 {
+					err = errors.New("netlink error message truncated")
+					return
+				}
+				if errno := int32(NativeEndian.Uint32(message.Data[:4])); errno != 0 {
+					err = syscall.Errno(-errno)
+					return
+				}
+			case unix.NLMSG_DONE:
+				delete(waiting, message.Header.Seq)
+			default:
+				replies = append(replies, message)
+			}
+		}
+	}
+	return
+}
+
+func marshalMessage(message Message) (buf []byte) {
+	buf = make([]byte, nlmsgAlign(int(message.Header.Len)))
+	NativeEndian.PutUint32(buf[0:4], message.Header.Len)
+	NativeEndian.PutUint16(buf[4:6], message.Header.Type)
+	NativeEndian.PutUint16(buf[6:8], message.Header.Flags)
+	NativeEndian.PutUint32(buf[8:12], message.Header.Seq)
+	NativeEndian.PutUint32(buf[12:16], message.Header.Pid)
+	copy(buf[unix.NLMSG_HDRLEN:], message.Data)
+	return
+}
+
+func parseMessages(buf []byte) (messages []Message, err error) {
+	for len(buf) >= unix.NLMSG_HDRLEN {
+		var message Message
+		message.Header.Len = NativeEndian.Uint32(buf[0:4])
+		message.Header.Type = NativeEndian.Uint16(buf[4:6])
+		message.Header.Flags = NativeEndian.Uint16(buf[6:8])
+		message.Header.Seq = NativeEndian.Uint32(buf[8:12])
+		message.Header.Pid = NativeEndian.Uint32(buf[12:16])
+		if message.Header.Len < unix.NLMSG_HDRLEN || int(message.Header.Len) > len(buf) {
+			err = errors.New("netlink message length invalid")
+			return
+		}
+		message.Data = buf[unix.NLMSG_HDRLEN:message.Header.Len]
+		messages = append(messages, message)
+		next := nlmsgAlign(int(message.Header.Len))
+		if next > len(buf) {
+			break
+		}
+		buf = buf[next:]
+	}
+	return
+}
+
+func nlmsgAlign(length int) int {
+	return (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+}
@@ -0,0 +1,108 @@
+package network
+
+import (
+	"errors"
+	"net"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// AutoBackend is the name to select the backend detected from the host.
+	AutoBackend string = "auto"
+)
+
+var (
+	// CurrentBackend is the backend setting the rules of cnet.
+	CurrentBackend Backend = IPTablesLegacy
+	// Backends are the supported backends in the order of detection.
+	Backends []Backend = []Backend{IPTablesNFT, IPTablesLegacy, NFTablesNative}
+)
+
+// Backend sets the rules queueing the packets of containers without spawning processes.
+// NFQueue rule is set in ContainerChainName, and the chain hooked by cnet jumps to it only for the protected containers.
+// Every rule set by the backend is tagged by OwnerComment.
+type Backend interface {
+	Name() string
+	// Available reports whether the backend can set the rules for the chain on the host.
+	Available(chainName string) bool
+	// InsertNFQueueRule sets NFQueue rule in ContainerChainName of the table of the chain, replacing the rules installed before.
+	InsertNFQueueRule(chainName, protocol string, ruleNum, queueNum, queueCount uint16, bypass bool) error
+	DeleteNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) error
+	ExistsNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) bool
+	// SetJumpRules replaces the rules tagged by OwnerComment in the chain by the rules jumping to ContainerChainName
+	// for the packets from and to the IP addresses, and for the packets of the sockets in the cgroups of the unified hierarchy,
+	// or to the ports of the host in the chain where the socket cannot be matched.
+	SetJumpRules(chainName string, ruleNum uint16, ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) error
+	// RemoveOwnedRules removes the rules tagged by OwnerComment in the chain and ContainerChainName, such as the leftovers of crashed cnet.
+	RemoveOwnedRules(chainName string) (removed int, err error)
+}
+
+// SelectBackend returns the backend of the name, or detects the backend if the name is AutoBackend.
+func SelectBackend(name, chainName string) (backend Backend, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"name":       name,
+		"chain_name": chainName,
+	})
+	argFields.Debug("trying to select the backend")
+
+	if name == AutoBackend {
+		backend, err = DetectBackend(chainName)
+		return
+	}
+	for _, candidate := range Backends {
+		if candidate.Name() == name {
+			backend = candidate
+			argFields.Debug("the backend selected")
+			return
+		}
+	}
+	err = errors.New("the backend not supported")
+	argFields.WithField("error", err).Debug("failed to select the backend")
+	return
+}
+
+// DetectBackend returns the backend for the chain on the host.
+// The iptables having the chain created by docker is preferred, so that the rules are ordered with the rules of docker.
+// Otherwise, nftables is used natively on the host without iptables, such as docker using nftables.
+// NOTE: iptables-nft is checked first, because reading the table of iptables-legacy loads its module.
+func DetectBackend(chainName string) (backend Backend, err error) {
+	argFields := logrus.WithField("chain_name", chainName)
+	argFields.Debug("trying to detect the backend")
+
+	for _, candidate := range Backends {
+		if candidate.Available(chainName) {
+			backend = candidate
+			argFields.WithField("backend", backend.Name()).Debug("the backend detected")
+			return
+		}
+	}
+	err = errors.New("no backend available")
+	argFields.WithField("error", err).Debug("failed to detect the backend")
+	return
+}
+
+// InsertNFQueueRule insert NFQueue rule in the specified chain and rule number by the current backend.
+func InsertNFQueueRule(chainName, protocol string, ruleNum, queueNum, queueCount uint16, bypass bool) (err error) {
+	return CurrentBackend.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum, queueCount, bypass)
+}
+
+// DeleteNFQueueRule delete NFQueue rule in the specified chain by the current backend.
+func DeleteNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) (err error) {
+	return CurrentBackend.DeleteNFQueueRule(chainName, protocol, queueNum, queueCount, bypass)
+}
+
+// ExistsNFQueueRule reports whether NFQueue rule is existed by the current backend.
+func ExistsNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) (exist bool) {
+	return CurrentBackend.ExistsNFQueueRule(chainName, protocol, queueNum, queueCount, bypass)
+}
+
+// SetJumpRules sets the rules jumping for the IP addresses, and the cgroups and the ports of the host network in the specified chain and rule number by the current backend.
+func SetJumpRules(chainName string, ruleNum uint16, ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) (err error) {
+	return CurrentBackend.SetJumpRules(chainName, ruleNum, ipAddresses, hostCgroups, hostPorts)
+}
+
+// RemoveOwnedRules removes the rules tagged by cnet in the chain by the current backend.
+func RemoveOwnedRules(chainName string) (removed int, err error) {
+	return CurrentBackend.RemoveOwnedRules(chainName)
+}
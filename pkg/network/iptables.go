@@ -1,89 +1,874 @@
 package network
 
 import (
+	"bytes"
 	"errors"
-	"os/exec"
-	"strconv"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
 	"unsafe"
 
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"golang.org/x/sys/unix"
 )
 
+// The socket options of ip_tables and ip6_tables defined in linux/netfilter_ipv4/ip_tables.h and netfilter_ipv6/ip6_tables.h.
 const (
-	jumpTarget string = "NFQUEUE"
+	iptSoSetReplace     int = 64
+	iptSoSetAddCounters int = 65
+	iptSoGetInfo        int = 64
+	iptSoGetEntries     int = 65
+
+	ip6tFlagProto uint8 = 0x01
+
+	xtTableMaxNameLen int = 32
+	nfInetNumHooks    int = 5
+	xtCountersSize    int = 16
+
+	// The sizes of ipt_getinfo, ipt_get_entries, ipt_replace and xt_counters_info.
+	getInfoSize      int = 84
+	getEntriesSize   int = 40
+	replaceSize      int = 96
+	countersInfoSize int = 40
+
+	filterTableName string = "filter"
+	// xtablesLockPath is the lock shared with iptables, which serializes the replacement of the tables.
+	xtablesLockPath string = "/run/xtables.lock"
+	// replaceRetryCount is for the table replaced by the others at the same time.
+	replaceRetryCount int = 3
+)
+
+var (
+	// errChainNotFound is returned when the chain to be modified is not found in the table.
+	errChainNotFound error = errors.New("the chain not found")
+
+	// IPTablesLegacy is the backend setting the rules in the tables of x_tables by the socket options, like iptables-legacy.
+	IPTablesLegacy *IPTables = &IPTables{}
+
+	// builtinChainNames are the names of the chains of the hooks.
+	builtinChainNames [nfInetNumHooks]string = [nfInetNumHooks]string{"PREROUTING", "INPUT", "FORWARD", "OUTPUT", "POSTROUTING"}
+
+	// xtablesFamilies are the families of IPv4 and IPv6. The rule of IPv4 is required, and the rule of IPv6 is set only if the chain exists.
+	xtablesFamilies []*xtablesFamily = []*xtablesFamily{
+		{name: "ipv4", socketFamily: unix.AF_INET, level: unix.SOL_IP, entrySize: 112, addressSize: net.IPv4len,
+			protoOffset: 80, flagsOffset: 82, nfcacheOffset: 84},
+		{name: "ipv6", socketFamily: unix.AF_INET6, level: unix.SOL_IPV6, entrySize: 168, addressSize: net.IPv6len,
+			protoOffset: 128, flagsOffset: 131, protoFlag: ip6tFlagProto, nfcacheOffset: 136},
+	}
 )
 
-// InsertNFQueueRule insert NFQueue rule in the specified chain and rule number.
-func InsertNFQueueRule(chainName, protocol string, ruleNum, queueNum uint16) (err error) {
+// xtablesFamily is the layout of ipt_entry or ip6t_entry.
+type xtablesFamily struct {
+	name         string
+	socketFamily int
+	level        int
+	entrySize    int
+	// addressSize is the size of the source and destination addresses and their masks at the beginning of the entry.
+	addressSize   int
+	protoOffset   int
+	flagsOffset   int
+	protoFlag     uint8
+	nfcacheOffset int
+}
+
+func (f *xtablesFamily) targetOffsetOffset() int { return f.nfcacheOffset + 4 }
+func (f *xtablesFamily) nextOffsetOffset() int   { return f.nfcacheOffset + 6 }
+func (f *xtablesFamily) comefromOffset() int     { return f.nfcacheOffset + 8 }
+func (f *xtablesFamily) countersOffset() int     { return f.entrySize - xtCountersSize }
+
+// xtEntry is ipt_entry or ip6t_entry with the matches and target.
+type xtEntry struct {
+	data []byte
+	// oldIndex is the index in the table read from the kernel, or -1 for the new entry.
+	oldIndex int
+	offset   int
+	owned    bool
+}
+
+// xtChain is the chain of the table.
+type xtChain struct {
+	name string
+	// hook is the hook of the builtin chain, or -1 for the user-defined chain.
+	hook int
+	// head is the entry of ERROR target naming the user-defined chain.
+	head  *xtEntry
+	rules []*xtEntry
+	// tail is the policy of the builtin chain, or RETURN of the user-defined chain.
+	tail *xtEntry
+}
+
+func (c *xtChain) start() *xtEntry {
+	if len(c.rules) != 0 {
+		return c.rules[0]
+	}
+	return c.tail
+}
+
+// xtTable is the table of x_tables.
+type xtTable struct {
+	family     *xtablesFamily
+	name       string
+	validHooks uint32
+	chains     []*xtChain
+	end        *xtEntry
+	// jumps are the entries jumping to the user-defined chains.
+	jumps map[*xtEntry]*xtChain
+	// fallthroughs are the entries of the standard target jumping to the next entry, such as the rule without target.
+	fallthroughs map[*xtEntry]bool
+	oldEntries   int
+}
+
+// IPTables is the backend setting the rules by the socket options of x_tables without iptables.
+type IPTables struct{}
+
+// Name returns the name of the backend.
+func (b *IPTables) Name() string {
+	return "iptables-legacy"
+}
+
+// Available reports whether the filter table of IPv4 has the chain.
+// NOTE: The table is replaced with the pointer of 64 bits in ipt_replace, so that the other architectures are not supported.
+func (b *IPTables) Available(chainName string) bool {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		return false
+	}
+	table, err := readXTTable(xtablesFamilies[0], filterTableName)
+	if err != nil {
+		return false
+	}
+	return table.chain(chainName) != nil
+}
+
+// InsertNFQueueRule insert NFQueue rule in ContainerChainName of the table of the chain, replacing the rules installed before.
+// The chain is created if not existed. The rule number is used by SetJumpRules.
+func (b *IPTables) InsertNFQueueRule(chainName, protocol string, ruleNum, queueNum, queueCount uint16, bypass bool) (err error) {
 	argFields := logrus.WithFields(logrus.Fields{
-		"chain_name": chainName,
-		"protocol":  protocol,
-		"rule_num": ruleNum,
-		"queue_num":  queueNum,
+		"chain_name":  chainName,
+		"protocol":    protocol,
+		"rule_num":    ruleNum,
+		"queue_num":   queueNum,
+		"queue_count": queueCount,
+		"bypass":      bypass,
+		"backend":     b.Name(),
 	})
 	argFields.Debug("trying to insert nfqueue rule")
-	if ExistsNFQueueRule(chainName, protocol, queueNum) {
-		argFields.Debug("the nfqueue rule existed, so iptables setting not changed")
-		return
-	}
-	var out []byte
-	out, err = exec.Command(
-		"iptables",
-		"-I", chainName, strconv.Itoa(int(ruleNum)),
-		"-p", protocol,
-		"-j", jumpTarget,
-		"--queue-num", strconv.Itoa(int(queueNum)),
-	).CombinedOutput()
+
+	var rules []nfqueueRule
+	rules, err = nfqueueRules(protocol, queueNum, queueCount, bypass)
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to insert the nfqueue rule")
-		return errors.New(*(*string)(unsafe.Pointer(&out)))
+		return
+	}
+	for i, family := range xtablesFamilies {
+		familyFields := argFields.WithField("family", family.name)
+		err = modifyXTTable(family, filterTableName, func(table *xtTable) (bool, error) {
+			return table.insertNFQueueRules(chainName, rules)
+		})
+		if i != 0 && ignorableXTablesError(err) {
+			familyFields.WithField("warn", err).Warn("the chain not found, so nfqueue rule not inserted")
+			err = nil
+			continue
+		} else if err != nil {
+			familyFields.WithField("error", err).Debug("failed to insert the nfqueue rule")
+			return
+		}
 	}
 	argFields.Debug("the nfqueue rule inserted")
 	return
 }
 
-// DeleteNFQueueRule delete NFQueue rule in the specified chain.
-func DeleteNFQueueRule(chainName, protocol string, queueNum uint16) (err error) {
+// DeleteNFQueueRule delete NFQueue rule in the specified chain, which is every rule tagged by cnet.
+func (b *IPTables) DeleteNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) (err error) {
 	argFields := logrus.WithFields(logrus.Fields{
-		"chainName": chainName,
-		"protocol":  protocol,
-		"queueNum":  queueNum,
+		"chainName":  chainName,
+		"protocol":   protocol,
+		"queueNum":   queueNum,
+		"queueCount": queueCount,
+		"bypass":     bypass,
+		"backend":    b.Name(),
 	})
-	if !ExistsNFQueueRule(chainName, protocol, queueNum) {
-		argFields.Debug("the nfqueue rule existed, so iptables setting not changed")
-		return
-	}
-	var out []byte
-	out, err = exec.Command(
-		"iptables",
-		"-D", chainName,
-		"-p", protocol,
-		"-j", jumpTarget,
-		"--queue-num", strconv.Itoa(int(queueNum)),
-	).CombinedOutput()
+	_, err = b.RemoveOwnedRules(chainName)
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to delete the nfqueue rule")
-		return errors.New(*(*string)(unsafe.Pointer(&out)))
+		return
 	}
 	argFields.Debug("the nfqueue rule deleted")
 	return
 }
 
-// ExistsNFQueueRule reports whether NFQueue rule is existed.
-func ExistsNFQueueRule(chainName, protocol string, queueNum uint16) (exist bool) {
-	err := exec.Command(
-		"iptables",
-		"-C", chainName,
-		"-p", protocol,
-		"-j", jumpTarget,
-		"--queue-num", strconv.Itoa(int(queueNum)),
-	).Run()
-	exist = err == nil
+// ExistsNFQueueRule reports whether the rules tagged by cnet in ContainerChainName are the same number as NFQueue rule.
+func (b *IPTables) ExistsNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) (exist bool) {
+	rules, err := nfqueueRules(protocol, queueNum, queueCount, bypass)
+	if err == nil {
+		for i, family := range xtablesFamilies {
+			table, readErr := readXTTable(family, filterTableName)
+			if readErr != nil || table.chain(chainName) == nil {
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			containerChain := table.chain(ContainerChainName)
+			exist = containerChain != nil && containerChain.countOwnedRules() == len(rules)
+			if !exist {
+				break
+			}
+		}
+	}
 	logrus.WithFields(logrus.Fields{
-		"chain_name": chainName,
-		"protocol": protocol,
-		"queue_num": queueNum,
-		"exist": exist,
+		"chain_name":  chainName,
+		"protocol":    protocol,
+		"queue_num":   queueNum,
+		"queue_count": queueCount,
+		"bypass":      bypass,
+		"backend":     b.Name(),
+		"exist":       exist,
 	}).Debug("nfqueue rule exist checked")
 	return
 }
+
+// SetJumpRules replaces the rules tagged by cnet in the chain by the rules jumping to ContainerChainName at the rule number.
+// The IP addresses are set in the table of their family, and the cgroups and the ports are set in the tables of both families.
+func (b *IPTables) SetJumpRules(chainName string, ruleNum uint16, ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) (err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chain_name":   chainName,
+		"rule_num":     ruleNum,
+		"ip_addresses": ipAddresses,
+		"host_cgroups": hostCgroups,
+		"host_ports":   hostPorts,
+		"backend":      b.Name(),
+	})
+	argFields.Debug("trying to set the jump rules")
+
+	rules := xtJumpRules(chainName, ipAddresses, hostCgroups, hostPorts)
+	for i, family := range xtablesFamilies {
+		familyFields := argFields.WithField("family", family.name)
+		err = modifyXTTable(family, filterTableName, func(table *xtTable) (bool, error) {
+			return table.setJumpRules(chainName, ruleNum, rules)
+		})
+		if i != 0 && ignorableXTablesError(err) {
+			familyFields.WithField("warn", err).Debug("the chain not found, so the jump rules not set")
+			err = nil
+			continue
+		} else if err != nil {
+			familyFields.WithField("error", err).Debug("failed to set the jump rules")
+			return
+		}
+	}
+	argFields.Debug("the jump rules set")
+	return
+}
+
+// RemoveOwnedRules removes the rules tagged by cnet in the chain and ContainerChainName, and returns the number of the removed rules.
+// ContainerChainName is deleted if no chain jumps to it.
+func (b *IPTables) RemoveOwnedRules(chainName string) (removed int, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chain_name": chainName,
+		"backend":    b.Name(),
+	})
+	argFields.Debug("trying to remove the rules tagged by cnet")
+
+	for i, family := range xtablesFamilies {
+		err = modifyXTTable(family, filterTableName, func(table *xtTable) (modified bool, err error) {
+			if chain := table.chain(chainName); chain != nil {
+				count := chain.removeOwnedRules()
+				removed += count
+				modified = count != 0
+			}
+			containerChain := table.chain(ContainerChainName)
+			if containerChain == nil || table.referenced(containerChain) {
+				return
+			}
+			count := containerChain.removeOwnedRules()
+			removed += count
+			modified = modified || count != 0
+			if len(containerChain.rules) == 0 {
+				table.removeChain(containerChain)
+				modified = true
+			}
+			return
+		})
+		if i != 0 && ignorableXTablesError(err) {
+			err = nil
+			continue
+		} else if err != nil {
+			argFields.WithFields(logrus.Fields{
+				"error":  err,
+				"family": family.name,
+			}).Debug("failed to remove the rules tagged by cnet")
+			return
+		}
+	}
+	argFields.WithField("removed", removed).Debug("the rules tagged by cnet removed")
+	return
+}
+
+// NFQueueReplacement returns ipt_replace or ip6t_replace of the filter table, given as ipt_getinfo and the entries of ipt_get_entries,
+// after NFQueue rule is inserted like InsertNFQueueRule. The family is "ipv4" or "ipv6", and the pointer of the counters is zero.
+// It shows the layout of the rules without the kernel.
+func (b *IPTables) NFQueueReplacement(familyName string, info, entries []byte, chainName, protocol string, queueNum, queueCount uint16, bypass bool) (replacement []byte, err error) {
+	var rules []nfqueueRule
+	rules, err = nfqueueRules(protocol, queueNum, queueCount, bypass)
+	if err != nil {
+		return
+	}
+	replacement, err = xtReplacement(familyName, info, entries, func(table *xtTable) (bool, error) {
+		return table.insertNFQueueRules(chainName, rules)
+	})
+	return
+}
+
+// JumpReplacement returns ipt_replace or ip6t_replace of the filter table, given as ipt_getinfo and the entries of ipt_get_entries,
+// after the jump rules are set like SetJumpRules. The family is "ipv4" or "ipv6", and the pointer of the counters is zero.
+func (b *IPTables) JumpReplacement(familyName string, info, entries []byte, chainName string, ruleNum uint16, ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) (replacement []byte, err error) {
+	rules := xtJumpRules(chainName, ipAddresses, hostCgroups, hostPorts)
+	replacement, err = xtReplacement(familyName, info, entries, func(table *xtTable) (bool, error) {
+		return table.setJumpRules(chainName, ruleNum, rules)
+	})
+	return
+}
+
+func xtReplacement(familyName string, info, entries []byte, modify func(table *xtTable) (modified bool, err error)) (replacement []byte, err error) {
+	var family *xtablesFamily
+	for _, candidate := range xtablesFamilies {
+		if candidate.name == familyName {
+			family = candidate
+		}
+	}
+	if family == nil {
+		err = errors.New("the family of x_tables not supported")
+		return
+	}
+	if len(info) != getInfoSize {
+		err = errors.New("the info of x_tables invalid")
+		return
+	}
+	var table *xtTable
+	table, err = parseXTTable(family, filterTableName, info, entries)
+	if err != nil {
+		return
+	}
+	if _, err = modify(table); err != nil {
+		return
+	}
+	replacement, _ = table.marshalReplace()
+	return
+}
+
+// xtJumpRules returns the jump rules matched by x_tables.
+// NOTE: xt_cgroup matches only the packet attached to the socket, which the packet of the new connection in INPUT is not yet.
+func xtJumpRules(chainName string, ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) []jumpRule {
+	return jumpRules(ipAddresses, hostCgroups, hostPorts, chainName != "INPUT")
+}
+
+// ignorableXTablesError reports whether the error of the table of IPv6 is ignored, which is the chain or the table not found.
+func ignorableXTablesError(err error) bool {
+	return errors.Is(err, errChainNotFound) || errors.Is(err, syscall.ENOENT)
+}
+
+// insertNFQueueRules replaces the rules tagged by cnet in ContainerChainName by the rules, creating the chain if not existed.
+func (t *xtTable) insertNFQueueRules(chainName string, rules []nfqueueRule) (modified bool, err error) {
+	if t.chain(chainName) == nil {
+		err = errChainNotFound
+		return
+	}
+	containerChain := t.chain(ContainerChainName)
+	if containerChain == nil {
+		containerChain = t.addUserChain(ContainerChainName)
+	}
+	containerChain.removeOwnedRules()
+	for i := range rules {
+		containerChain.rules = append(containerChain.rules, &xtEntry{data: t.family.marshalRule(&rules[i]), oldIndex: -1})
+	}
+	modified = true
+	return
+}
+
+// setJumpRules replaces the rules tagged by cnet in the chain by the rules of the family jumping to ContainerChainName at the rule number.
+func (t *xtTable) setJumpRules(chainName string, ruleNum uint16, rules []jumpRule) (modified bool, err error) {
+	chain, containerChain := t.chain(chainName), t.chain(ContainerChainName)
+	if chain == nil || containerChain == nil {
+		err = errChainNotFound
+		return
+	}
+	chain.removeOwnedRules()
+	position := int(ruleNum) - 1
+	if position < 0 {
+		position = 0
+	} else if position > len(chain.rules) {
+		position = len(chain.rules)
+	}
+	var entries []*xtEntry
+	for i := range rules {
+		if !rules[i].anyFamily() && rules[i].ipv4() != (t.family.addressSize == net.IPv4len) {
+			continue
+		}
+		entry := &xtEntry{data: t.family.marshalJumpRule(&rules[i]), oldIndex: -1}
+		t.jumps[entry] = containerChain
+		entries = append(entries, entry)
+	}
+	chain.rules = append(chain.rules[:position], append(entries, chain.rules[position:]...)...)
+	modified = true
+	return
+}
+
+// marshalEntry returns the entry matching any packet with the matches and target.
+func (f *xtablesFamily) marshalEntry(matches []xtExtension, target xtExtension) (entry []byte) {
+	entry = make([]byte, f.entrySize)
+	for _, match := range matches {
+		entry = append(entry, match.marshal()...)
+	}
+	netlink.NativeEndian.PutUint16(entry[f.targetOffsetOffset():], uint16(len(entry)))
+	entry = append(entry, target.marshal()...)
+	netlink.NativeEndian.PutUint16(entry[f.nextOffsetOffset():], uint16(len(entry)))
+	return
+}
+
+// marshalRule returns the entry of the rule tagged by cnet.
+func (f *xtablesFamily) marshalRule(rule *nfqueueRule) (entry []byte) {
+	matches := append([]xtExtension{newCommentMatch(OwnerComment)}, rule.xtMatches()...)
+	var target xtExtension
+	switch rule.verdict {
+	case verdictDrop:
+		target = newStandardTarget(xtStandardVerdictDrop)
+	case verdictAccept:
+		target = newStandardTarget(xtStandardVerdictAccept)
+	case verdictQueue:
+		target = newNFQueueTarget(rule.queueNum, rule.queueCount, rule.bypass)
+	}
+	entry = f.marshalEntry(matches, target)
+	netlink.NativeEndian.PutUint16(entry[f.protoOffset:], uint16(rule.protocol))
+	if rule.protocol != 0 {
+		entry[f.flagsOffset] |= f.protoFlag
+	}
+	return
+}
+
+// marshalJumpRule returns the entry of the jump rule tagged by cnet.
+// NOTE: The verdict of the jump is the offset of the chain, which is set when the table is replaced.
+func (f *xtablesFamily) marshalJumpRule(rule *jumpRule) (entry []byte) {
+	matches := []xtExtension{newCommentMatch(OwnerComment)}
+	if rule.cgroupPath != "" {
+		matches = append(matches, newCgroupMatch(rule.cgroupPath))
+	}
+	if rule.port != 0 {
+		matches = append(matches, newPortMatch(rule.protocol, rule.port))
+	}
+	entry = f.marshalEntry(matches, newStandardTarget(0))
+	if rule.port != 0 {
+		// NOTE: The match of the port is valid only for the entry of its protocol.
+		netlink.NativeEndian.PutUint16(entry[f.protoOffset:], uint16(rule.protocol))
+		entry[f.flagsOffset] |= f.protoFlag
+	}
+	if rule.anyFamily() {
+		return
+	}
+	addressOffset, maskOffset := 0, 2*f.addressSize
+	if !rule.source {
+		addressOffset, maskOffset = f.addressSize, 3*f.addressSize
+	}
+	copy(entry[addressOffset:addressOffset+f.addressSize], rule.address())
+	copy(entry[maskOffset:maskOffset+f.addressSize], net.CIDRMask(8*f.addressSize, 8*f.addressSize))
+	return
+}
+
+// target returns the name and data of the target of the raw entry.
+func (t *xtTable) target(entry []byte) (name string, data []byte) {
+	targetOffset := int(netlink.NativeEndian.Uint16(entry[t.family.targetOffsetOffset():]))
+	if targetOffset+xtExtensionHeaderSize > len(entry) {
+		return
+	}
+	target := entry[targetOffset:]
+	name = cString(target[2 : 2+xtExtensionMaxNameLen])
+	data = target[xtExtensionHeaderSize:]
+	return
+}
+
+// owned reports whether the raw entry has the comment of cnet.
+func (t *xtTable) owned(entry []byte) bool {
+	targetOffset := int(netlink.NativeEndian.Uint16(entry[t.family.targetOffsetOffset():]))
+	for offset := t.family.entrySize; offset+xtExtensionHeaderSize <= targetOffset; {
+		match := entry[offset:]
+		matchSize := int(netlink.NativeEndian.Uint16(match[0:2]))
+		if matchSize < xtExtensionHeaderSize || offset+matchSize > targetOffset {
+			return false
+		}
+		if cString(match[2:2+xtExtensionMaxNameLen]) == "comment" &&
+			cString(match[xtExtensionHeaderSize:matchSize]) == OwnerComment {
+			return true
+		}
+		offset += matchSize
+	}
+	return false
+}
+
+func (t *xtTable) chain(chainName string) *xtChain {
+	for _, chain := range t.chains {
+		if chain.name == chainName {
+			return chain
+		}
+	}
+	return nil
+}
+
+// addUserChain appends the empty user-defined chain to the table.
+func (t *xtTable) addUserChain(chainName string) (chain *xtChain) {
+	chain = &xtChain{
+		name: chainName,
+		hook: -1,
+		head: &xtEntry{data: t.family.marshalEntry(nil, newErrorTarget(chainName)), oldIndex: -1},
+		tail: &xtEntry{data: t.family.marshalEntry(nil, newStandardTarget(xtStandardVerdictReturn)), oldIndex: -1},
+	}
+	t.chains = append(t.chains, chain)
+	return
+}
+
+func (t *xtTable) removeChain(chain *xtChain) {
+	for i, candidate := range t.chains {
+		if candidate == chain {
+			t.chains = append(t.chains[:i], t.chains[i+1:]...)
+			return
+		}
+	}
+}
+
+// referenced reports whether any rule in the table jumps to the chain.
+func (t *xtTable) referenced(chain *xtChain) bool {
+	for _, candidate := range t.chains {
+		for _, rule := range candidate.rules {
+			if t.jumps[rule] == chain {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *xtChain) removeOwnedRules() (removed int) {
+	rules := c.rules[:0]
+	for _, rule := range c.rules {
+		if rule.owned {
+			removed++
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	c.rules = rules
+	return
+}
+
+func (c *xtChain) countOwnedRules() (count int) {
+	for _, rule := range c.rules {
+		if rule.owned {
+			count++
+		}
+	}
+	return
+}
+
+// modifyXTTable replaces the table modified by the function, keeping the counters of the entries.
+func modifyXTTable(family *xtablesFamily, tableName string, modify func(table *xtTable) (modified bool, err error)) (err error) {
+	var unlock func()
+	unlock, err = lockXTables()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	for i := 0; i < replaceRetryCount; i++ {
+		var (
+			table    *xtTable
+			modified bool
+		)
+		table, err = readXTTable(family, tableName)
+		if err != nil {
+			return
+		}
+		modified, err = modify(table)
+		if err != nil || !modified {
+			return
+		}
+		err = table.replace()
+		// NOTE: The number of the entries is changed by the others since the table read.
+		if !errors.Is(err, syscall.EAGAIN) {
+			return
+		}
+	}
+	return
+}
+
+// lockXTables takes the lock shared with iptables, and returns the function to release it.
+// The table is not replaced without the lock, because the replacement by iptables at the same time may be lost.
+func lockXTables() (unlock func(), err error) {
+	var lockFile *os.File
+	lockFile, err = os.OpenFile(xtablesLockPath, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to open the lock of xtables")
+		return
+	}
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX)
+	if err != nil {
+		lockFile.Close()
+		logrus.WithField("error", err).Debug("failed to take the lock of xtables")
+		return
+	}
+	unlock = func() {
+		unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+		lockFile.Close()
+	}
+	return
+}
+
+func openXTablesSocket(family *xtablesFamily) (fd int, err error) {
+	fd, err = unix.Socket(family.socketFamily, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.IPPROTO_RAW)
+	return
+}
+
+// readXTTable reads the table from the kernel and parses the chains.
+func readXTTable(family *xtablesFamily, tableName string) (table *xtTable, err error) {
+	var fd int
+	fd, err = openXTablesSocket(family)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	info := make([]byte, getInfoSize)
+	copy(info[:xtTableMaxNameLen-1], tableName)
+	err = getsockopt(fd, family.level, iptSoGetInfo, info)
+	if err != nil {
+		return
+	}
+	size := int(netlink.NativeEndian.Uint32(info[80:84]))
+
+	entries := make([]byte, getEntriesSize+size)
+	copy(entries[:xtTableMaxNameLen-1], tableName)
+	netlink.NativeEndian.PutUint32(entries[32:36], uint32(size))
+	err = getsockopt(fd, family.level, iptSoGetEntries, entries)
+	if err != nil {
+		return
+	}
+	table, err = parseXTTable(family, tableName, info, entries[getEntriesSize:])
+	return
+}
+
+// parseXTTable parses the chains of the table from ipt_getinfo and the entries of ipt_get_entries.
+func parseXTTable(family *xtablesFamily, tableName string, info, entries []byte) (table *xtTable, err error) {
+	var hookEntry, underflow [nfInetNumHooks]int
+	validHooks := netlink.NativeEndian.Uint32(info[32:36])
+	for hook := 0; hook < nfInetNumHooks; hook++ {
+		hookEntry[hook] = int(netlink.NativeEndian.Uint32(info[36+4*hook:]))
+		underflow[hook] = int(netlink.NativeEndian.Uint32(info[56+4*hook:]))
+	}
+	numEntries := int(netlink.NativeEndian.Uint32(info[76:80]))
+	size := len(entries)
+
+	table = &xtTable{
+		family:       family,
+		name:         tableName,
+		validHooks:   validHooks,
+		jumps:        map[*xtEntry]*xtChain{},
+		fallthroughs: map[*xtEntry]bool{},
+		oldEntries:   numEntries,
+	}
+	var (
+		current *xtChain
+		parsed  []*xtEntry
+	)
+	for offset, index := 0, 0; offset < size; index++ {
+		if offset+family.entrySize > size {
+			err = errors.New("the entry of x_tables truncated")
+			return
+		}
+		nextOffset := int(netlink.NativeEndian.Uint16(entries[offset+family.nextOffsetOffset():]))
+		if nextOffset < family.entrySize || offset+nextOffset > size {
+			err = errors.New("the entry of x_tables invalid")
+			return
+		}
+		entry := &xtEntry{data: append([]byte{}, entries[offset:offset+nextOffset]...), oldIndex: index, offset: offset}
+		parsed = append(parsed, entry)
+		offset += nextOffset
+
+		for hook := 0; hook < nfInetNumHooks; hook++ {
+			if validHooks&(1<<uint(hook)) != 0 && hookEntry[hook] == entry.offset {
+				current = &xtChain{name: builtinChainNames[hook], hook: hook}
+				table.chains = append(table.chains, current)
+			}
+		}
+		if name, data := table.target(entry.data); name == xtErrorTarget {
+			if chainName := cString(data); chainName != xtErrorTarget {
+				current = &xtChain{name: chainName, hook: -1, head: entry}
+				table.chains = append(table.chains, current)
+				continue
+			}
+			table.end = entry
+			break
+		}
+		if current == nil {
+			err = errors.New("the entry out of the chains")
+			return
+		}
+		if current.hook >= 0 && underflow[current.hook] == entry.offset {
+			current.tail = entry
+			current = nil
+			continue
+		}
+		entry.owned = table.owned(entry.data)
+		current.rules = append(current.rules, entry)
+	}
+	if table.end == nil {
+		err = errors.New("the end of x_tables not found")
+		return
+	}
+
+	// NOTE: The last entry of the user-defined chain is RETURN.
+	chainStarts := map[int]*xtChain{}
+	for _, chain := range table.chains {
+		if chain.hook >= 0 {
+			continue
+		}
+		if len(chain.rules) == 0 {
+			err = errors.New("the user-defined chain without RETURN")
+			return
+		}
+		chain.tail = chain.rules[len(chain.rules)-1]
+		chain.rules = chain.rules[:len(chain.rules)-1]
+		chainStarts[chain.head.offset+len(chain.head.data)] = chain
+	}
+	for _, entry := range parsed {
+		name, data := table.target(entry.data)
+		if name != xtStandardTarget || len(data) < 4 {
+			continue
+		}
+		verdict := int32(netlink.NativeEndian.Uint32(data[0:4]))
+		if verdict < 0 {
+			continue
+		}
+		if int(verdict) == entry.offset+len(entry.data) {
+			table.fallthroughs[entry] = true
+			continue
+		}
+		chain, exist := chainStarts[int(verdict)]
+		if !exist {
+			err = errors.New("the jump not to the chain not supported")
+			return
+		}
+		table.jumps[entry] = chain
+	}
+	return
+}
+
+// replace replaces the table in the kernel, and restores the counters of the entries kept.
+func (t *xtTable) replace() (err error) {
+	buf, entries := t.marshalReplace()
+	oldCounters := make([]byte, xtCountersSize*(t.oldEntries+1))
+	netlink.NativeEndian.PutUint64(buf[88:96], uint64(uintptr(unsafe.Pointer(&oldCounters[0]))))
+
+	var fd int
+	fd, err = openXTablesSocket(t.family)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+	err = setsockopt(fd, t.family.level, iptSoSetReplace, buf)
+	runtime.KeepAlive(oldCounters)
+	if err != nil {
+		return
+	}
+
+	counters := make([]byte, countersInfoSize+xtCountersSize*len(entries))
+	copy(counters[:xtTableMaxNameLen-1], t.name)
+	netlink.NativeEndian.PutUint32(counters[32:36], uint32(len(entries)))
+	for i, entry := range entries {
+		if entry.oldIndex < 0 {
+			continue
+		}
+		copy(counters[countersInfoSize+xtCountersSize*i:], oldCounters[xtCountersSize*entry.oldIndex:xtCountersSize*(entry.oldIndex+1)])
+	}
+	if err = setsockopt(fd, t.family.level, iptSoSetAddCounters, counters); err != nil {
+		logrus.WithField("warn", err).Debug("could not restore the counters of x_tables")
+		err = nil
+	}
+	return
+}
+
+// marshalReplace returns ipt_replace or ip6t_replace of the table without the pointer of the counters, and the entries in the order.
+func (t *xtTable) marshalReplace() (buf []byte, entries []*xtEntry) {
+	var hookEntry, underflow [nfInetNumHooks]int
+	offset := 0
+	push := func(entry *xtEntry) {
+		entry.offset = offset
+		offset += len(entry.data)
+		entries = append(entries, entry)
+	}
+	for _, chain := range t.chains {
+		if chain.head != nil {
+			push(chain.head)
+		}
+		for _, rule := range chain.rules {
+			push(rule)
+		}
+		push(chain.tail)
+	}
+	push(t.end)
+	for _, chain := range t.chains {
+		if chain.hook >= 0 {
+			hookEntry[chain.hook] = chain.start().offset
+			underflow[chain.hook] = chain.tail.offset
+		}
+	}
+
+	buf = make([]byte, replaceSize, replaceSize+offset)
+	copy(buf[:xtTableMaxNameLen-1], t.name)
+	netlink.NativeEndian.PutUint32(buf[32:36], t.validHooks)
+	netlink.NativeEndian.PutUint32(buf[36:40], uint32(len(entries)))
+	netlink.NativeEndian.PutUint32(buf[40:44], uint32(offset))
+	for hook := 0; hook < nfInetNumHooks; hook++ {
+		netlink.NativeEndian.PutUint32(buf[44+4*hook:], uint32(hookEntry[hook]))
+		netlink.NativeEndian.PutUint32(buf[64+4*hook:], uint32(underflow[hook]))
+	}
+	netlink.NativeEndian.PutUint32(buf[84:88], uint32(t.oldEntries))
+	for _, entry := range entries {
+		data := append([]byte{}, entry.data...)
+		// NOTE: The counters are set by the kernel, and restored after the replacement.
+		netlink.NativeEndian.PutUint32(data[t.family.comefromOffset():], 0)
+		copy(data[t.family.countersOffset():t.family.entrySize], make([]byte, xtCountersSize))
+		// NOTE: The offsets of the jumps are changed by the entries inserted or removed.
+		if chain, exist := t.jumps[entry]; exist {
+			_, target := t.target(data)
+			netlink.NativeEndian.PutUint32(target, uint32(chain.head.offset+len(chain.head.data)))
+		} else if t.fallthroughs[entry] {
+			_, target := t.target(data)
+			netlink.NativeEndian.PutUint32(target, uint32(entry.offset+len(entry.data)))
+		}
+		buf = append(buf, data...)
+	}
+	return
+}
+
+func getsockopt(fd, level, name int, buf []byte) (err error) {
+	length := uint32(len(buf))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&length)), 0)
+	if errno != 0 {
+		err = errno
+	}
+	return
+}
+
+func setsockopt(fd, level, name int, buf []byte) (err error) {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0)
+	if errno != 0 {
+		err = errno
+	}
+	return
+}
+
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		return string(buf[:i])
+	}
+	return string(buf)
+}
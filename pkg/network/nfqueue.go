@@ -0,0 +1,116 @@
+package network
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	nfqueueStatisticsPath string = "/proc/net/netfilter/nfnetlink_queue"
+)
+
+// The columns of nfnetlink_queue in proc filesystem.
+const (
+	queueNumberColumn int = iota
+	peerPortIDColumn
+	queueTotalColumn
+	copyModeColumn
+	copyRangeColumn
+	queueDroppedColumn
+	userDroppedColumn
+	idSequenceColumn
+)
+
+// NFQueueStatistics is the statistics of the queue bound by the program.
+type NFQueueStatistics struct {
+	QueueNum   uint16
+	PeerPortID uint32
+	// QueueTotal is the number of packets waiting for the verdict.
+	QueueTotal uint32
+	// QueueDropped is the number of packets dropped because the queue was full.
+	QueueDropped uint32
+	// UserDropped is the number of packets dropped because the netlink socket of the program was full.
+	UserDropped uint32
+	IDSequence  uint32
+}
+
+// ReadNFQueueStatistics returns the statistics of the queues bound by the programs.
+func ReadNFQueueStatistics() (statistics map[uint16]*NFQueueStatistics, err error) {
+	var rawData []byte
+	rawData, err = ioutil.ReadFile(nfqueueStatisticsPath)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to read nfqueue statistics")
+		return
+	}
+	statistics = map[uint16]*NFQueueStatistics{}
+	lineScanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&rawData))))
+	for lineScanner.Scan() {
+		columns := strings.Fields(lineScanner.Text())
+		if len(columns) <= idSequenceColumn {
+			continue
+		}
+		var values [idSequenceColumn + 1]uint64
+		for i := range values {
+			values[i], err = strconv.ParseUint(columns[i], 10, 32)
+			if err != nil {
+				logrus.WithField("error", err).Debug("failed to read nfqueue statistics")
+				return
+			}
+		}
+		statistics[uint16(values[queueNumberColumn])] = &NFQueueStatistics{
+			QueueNum:     uint16(values[queueNumberColumn]),
+			PeerPortID:   uint32(values[peerPortIDColumn]),
+			QueueTotal:   uint32(values[queueTotalColumn]),
+			QueueDropped: uint32(values[queueDroppedColumn]),
+			UserDropped:  uint32(values[userDroppedColumn]),
+			IDSequence:   uint32(values[idSequenceColumn]),
+		}
+	}
+	return
+}
+
+// MonitorNFQueueOverflow logs the packets dropped by the overflow of the queues and the queues not bound at the interval.
+func MonitorNFQueueOverflow(queueNum, queueCount uint16, interval time.Duration, quit <-chan struct{}) {
+	previous := map[uint16]NFQueueStatistics{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		statistics, err := ReadNFQueueStatistics()
+		if err != nil {
+			logrus.WithField("error", err).Warn("failed to monitor nfqueue overflow")
+			continue
+		}
+		for i := uint16(0); i < queueCount; i++ {
+			current, exist := statistics[queueNum+i]
+			if !exist {
+				logrus.WithFields(logrus.Fields{
+					"error":     errors.New("the queue not bound"),
+					"queue_num": queueNum + i,
+				}).Error("the packets of the queue are not inspected")
+				continue
+			}
+			last := previous[current.QueueNum]
+			if current.QueueDropped != last.QueueDropped || current.UserDropped != last.UserDropped {
+				logrus.WithFields(logrus.Fields{
+					"queue_num":     current.QueueNum,
+					"queue_total":   current.QueueTotal,
+					"queue_dropped": current.QueueDropped - last.QueueDropped,
+					"user_dropped":  current.UserDropped - last.UserDropped,
+				}).Warn("the packets dropped by the overflow of the queue")
+			}
+			previous[current.QueueNum] = *current
+		}
+	}
+}
@@ -0,0 +1,972 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/conntrack"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"golang.org/x/sys/unix"
+)
+
+// The message types and attributes of nf_tables defined in linux/netfilter/nfnetlink.h and nf_tables.h.
+const (
+	nfnlSubsysNFTables uint16 = 10
+	nfnlMsgBatchBegin  uint16 = 0x10
+	nfnlMsgBatchEnd    uint16 = 0x11
+	nfprotoInet        uint8  = 1
+	nfprotoIPv4        uint8  = 2
+	nfprotoIPv6        uint8  = 10
+
+	nftMsgNewTable uint16 = 0
+	nftMsgGetTable uint16 = 1
+	nftMsgDelTable uint16 = 2
+	nftMsgNewChain uint16 = 3
+	nftMsgGetChain uint16 = 4
+	nftMsgDelChain uint16 = 5
+	nftMsgNewRule  uint16 = 6
+	nftMsgGetRule  uint16 = 7
+	nftMsgDelRule  uint16 = 8
+
+	nftaTableName uint16 = 1
+
+	nftaChainTable  uint16 = 1
+	nftaChainName   uint16 = 3
+	nftaChainHook   uint16 = 4
+	nftaChainPolicy uint16 = 5
+	nftaChainType   uint16 = 7
+
+	nftaHookHooknum  uint16 = 1
+	nftaHookPriority uint16 = 2
+
+	nftaRuleTable       uint16 = 1
+	nftaRuleChain       uint16 = 2
+	nftaRuleHandle      uint16 = 3
+	nftaRuleExpressions uint16 = 4
+	nftaRulePosition    uint16 = 6
+	nftaRuleUserdata    uint16 = 7
+
+	nftaListElem uint16 = 1
+	nftaExprName uint16 = 1
+	nftaExprData uint16 = 2
+
+	nftaDataValue    uint16 = 1
+	nftaDataVerdict  uint16 = 2
+	nftaVerdictCode  uint16 = 1
+	nftaVerdictChain uint16 = 2
+
+	nftaImmediateDreg uint16 = 1
+	nftaImmediateData uint16 = 2
+
+	nftaMetaDreg uint16 = 1
+	nftaMetaKey  uint16 = 2
+
+	nftaPayloadDreg   uint16 = 1
+	nftaPayloadBase   uint16 = 2
+	nftaPayloadOffset uint16 = 3
+	nftaPayloadLen    uint16 = 4
+
+	nftaCTDreg uint16 = 1
+	nftaCTKey  uint16 = 2
+
+	nftaBitwiseSreg uint16 = 1
+	nftaBitwiseDreg uint16 = 2
+	nftaBitwiseLen  uint16 = 3
+	nftaBitwiseMask uint16 = 4
+	nftaBitwiseXor  uint16 = 5
+
+	nftaSocketKey   uint16 = 1
+	nftaSocketDreg  uint16 = 2
+	nftaSocketLevel uint16 = 3
+
+	nftaCmpSreg uint16 = 1
+	nftaCmpOp   uint16 = 2
+	nftaCmpData uint16 = 3
+
+	nftaQueueNum   uint16 = 1
+	nftaQueueTotal uint16 = 2
+	nftaQueueFlags uint16 = 3
+
+	// The attributes of the matches and targets of x_tables, which are the same in nft_compat.
+	nftaCompatName uint16 = 1
+	nftaCompatRev  uint16 = 2
+	nftaCompatInfo uint16 = 3
+
+	nftRegVerdict uint32 = 0
+	nftReg1       uint32 = 1
+
+	nftMetaNFProto uint32 = 15
+	nftMetaL4Proto uint32 = 16
+	nftCTState     uint32 = 0
+	nftCTMark      uint32 = 3
+	// nftSocketCgroupv2 is the key of the socket expression for the ID of the cgroup of the unified hierarchy.
+	nftSocketCgroupv2 uint32 = 3
+	nftCmpEq          uint32 = 0
+	nftCmpNeq         uint32 = 1
+
+	// nftPayloadNetworkHeader is the base of the payload at the header of IPv4 or IPv6.
+	nftPayloadNetworkHeader uint32 = 1
+	// nftPayloadTransportHeader is the base of the payload at the header of the protocol, such as TCP.
+	nftPayloadTransportHeader uint32 = 2
+
+	nftQueueFlagBypass uint16 = 1
+
+	// nftUdataRuleComment is the type of the comment in the userdata of the rule, which is shared by nft and iptables-nft.
+	nftUdataRuleComment uint8 = 0
+
+	nfDrop   uint32 = 0
+	nfAccept uint32 = 1
+	// nftJump is NFT_JUMP, which is -3.
+	nftJump uint32 = 0xfffffffd
+
+	nfInetLocalIn  uint32 = 1
+	nfInetForward  uint32 = 2
+	nfInetLocalOut uint32 = 3
+)
+
+const (
+	nftablesTableName string = "cnet"
+	nftablesChainType string = "filter"
+	// nftablesPriority is before the filter chains of the other tables, such as the rules of docker, like DOCKER-USER.
+	nftablesPriority int32 = -1
+
+	// The offsets of the source address in the headers of IPv4 and IPv6, which the destination address follows.
+	ipv4SourceOffset uint32 = 12
+	ipv6SourceOffset uint32 = 8
+	// destinationPortOffset is the offset of the destination port in the headers of TCP, UDP and UDP-Lite.
+	destinationPortOffset uint32 = 2
+)
+
+var (
+	// IPTablesNFT is the backend setting the rules in the tables of iptables-nft by netlink, which iptables-nft can list.
+	IPTablesNFT *NFTables = &NFTables{name: "iptables-nft", families: []uint8{nfprotoIPv4, nfprotoIPv6}, table: filterTableName, compat: true}
+	// NFTablesNative is the backend setting the rules in the dedicated table of nftables by netlink.
+	NFTablesNative *NFTables = &NFTables{name: "nftables", families: []uint8{nfprotoInet}, table: nftablesTableName, dedicated: true}
+
+	// nftablesHooks are the hooks of the chains in the dedicated table, which are named after the chains of iptables.
+	nftablesHooks map[string]nftablesHook = map[string]nftablesHook{
+		"DOCKER-USER": {name: "forward", hooknum: nfInetForward},
+		"FORWARD":     {name: "forward", hooknum: nfInetForward},
+		"INPUT":       {name: "input", hooknum: nfInetLocalIn},
+		"OUTPUT":      {name: "output", hooknum: nfInetLocalOut},
+	}
+)
+
+type nftablesHook struct {
+	name    string
+	hooknum uint32
+}
+
+// nftablesRule is the rule listed from nf_tables.
+type nftablesRule struct {
+	handle uint64
+	owned  bool
+}
+
+// NFTables is the backend setting the rules by netlink of nf_tables.
+type NFTables struct {
+	name     string
+	families []uint8
+	table    string
+	// dedicated is true if the table and its base chain per hook are created by cnet. The rules of the chain are replaced atomically.
+	dedicated bool
+	// compat is true if the matches and target of x_tables are used instead of the expressions which iptables-nft cannot list.
+	compat bool
+}
+
+// Name returns the name of the backend.
+func (b *NFTables) Name() string {
+	return b.name
+}
+
+// chainName returns the chain in the table for the chain of iptables.
+func (b *NFTables) chainName(chainName string) (name string, err error) {
+	if !b.dedicated {
+		name = chainName
+		return
+	}
+	hook, exist := nftablesHooks[strings.ToUpper(chainName)]
+	if !exist {
+		err = errors.New("the chain not mapped to the hook of nftables")
+		return
+	}
+	name = hook.name
+	return
+}
+
+// Available reports whether the chain can be used by the backend.
+func (b *NFTables) Available(chainName string) bool {
+	name, err := b.chainName(chainName)
+	if err != nil {
+		return false
+	}
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if b.dedicated {
+		_, err = conn.Execute(newNFTablesRequest(nftMsgGetTable, b.families[0], unix.NLM_F_DUMP))
+	} else {
+		_, err = conn.Execute(newNFTablesRequest(nftMsgGetChain, b.families[0], 0,
+			netlink.NewStringAttribute(nftaChainTable, b.table),
+			netlink.NewStringAttribute(nftaChainName, name),
+		))
+	}
+	return err == nil
+}
+
+// InsertNFQueueRule insert NFQueue rule in ContainerChainName of the table, replacing the rules installed before.
+// The chain is created if not existed. The rule number is used by SetJumpRules.
+func (b *NFTables) InsertNFQueueRule(chainName, protocol string, ruleNum, queueNum, queueCount uint16, bypass bool) (err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chain_name":  chainName,
+		"protocol":    protocol,
+		"rule_num":    ruleNum,
+		"queue_num":   queueNum,
+		"queue_count": queueCount,
+		"bypass":      bypass,
+		"backend":     b.name,
+	})
+	argFields.Debug("trying to insert nfqueue rule")
+
+	var (
+		name  string
+		rules []nfqueueRule
+		conn  *netlink.Conn
+	)
+	name, err = b.chainName(chainName)
+	if err == nil {
+		rules, err = nfqueueRules(protocol, queueNum, queueCount, bypass)
+	}
+	if err == nil {
+		conn, err = netlink.Dial(unix.NETLINK_NETFILTER)
+	}
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to insert the nfqueue rule")
+		return
+	}
+	defer conn.Close()
+
+	for i, family := range b.families {
+		familyFields := argFields.WithField("family", family)
+		if !b.dedicated {
+			_, err = conn.Execute(b.getChainMessage(family, name))
+		}
+		if errors.Is(err, syscall.ENOENT) && i != 0 {
+			familyFields.WithField("warn", err).Warn("the chain not found, so nfqueue rule not inserted")
+			err = nil
+			continue
+		} else if err != nil {
+			familyFields.WithField("error", err).Debug("failed to insert the nfqueue rule")
+			return
+		}
+		messages := b.containerChainMessages(family)
+		for j := range rules {
+			messages = append(messages, b.newRuleMessage(family, ContainerChainName, b.expressions(&rules[j]), 0))
+		}
+		// NOTE: The rules installed before are replaced in one transaction.
+		_, err = conn.ExecuteBatch(nftablesBatch(messages))
+		if err != nil {
+			familyFields.WithField("error", err).Debug("failed to insert the nfqueue rule")
+			return
+		}
+	}
+	argFields.Debug("the nfqueue rule inserted")
+	return
+}
+
+// SetJumpRules replaces the rules tagged by cnet in the chain by the rules jumping to ContainerChainName at the rule number.
+// The rule number is ignored for the dedicated chain, whose rules are replaced atomically.
+// The cgroups are matched by the socket looked up by nftables, or by the socket attached to the packet for iptables-nft.
+func (b *NFTables) SetJumpRules(chainName string, ruleNum uint16, ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) (err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chain_name":   chainName,
+		"rule_num":     ruleNum,
+		"ip_addresses": ipAddresses,
+		"host_cgroups": hostCgroups,
+		"host_ports":   hostPorts,
+		"backend":      b.name,
+	})
+	argFields.Debug("trying to set the jump rules")
+
+	var (
+		name string
+		conn *netlink.Conn
+	)
+	name, err = b.chainName(chainName)
+	if err == nil {
+		conn, err = netlink.Dial(unix.NETLINK_NETFILTER)
+	}
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to set the jump rules")
+		return
+	}
+	defer conn.Close()
+
+	// NOTE: xt_cgroup of iptables-nft matches only the packet attached to the socket, which the packet of the new connection in INPUT is not yet.
+	rules := jumpRules(ipAddresses, hostCgroups, hostPorts, !b.compat || chainName != "INPUT")
+	for i, family := range b.families {
+		familyFields := argFields.WithField("family", family)
+		var expressions [][]netlink.Attribute
+		for j := range rules {
+			if family == nfprotoInet || rules[j].anyFamily() || rules[j].ipv4() == (family == nfprotoIPv4) {
+				var ruleExpressions []netlink.Attribute
+				ruleExpressions, err = b.jumpExpressions(&rules[j])
+				if err != nil {
+					familyFields.WithField("error", err).Debug("failed to set the jump rules")
+					return
+				}
+				expressions = append(expressions, ruleExpressions)
+			}
+		}
+		var messages []netlink.Message
+		if b.dedicated {
+			messages, err = b.dedicatedChainMessages(family, chainName)
+			for _, ruleExpressions := range expressions {
+				messages = append(messages, b.newRuleMessage(family, name, ruleExpressions, 0))
+			}
+		} else {
+			messages, err = b.replacingMessages(conn, family, name, int(ruleNum)-1, expressions)
+		}
+		if errors.Is(err, syscall.ENOENT) && i != 0 {
+			familyFields.WithField("warn", err).Debug("the chain not found, so the jump rules not set")
+			err = nil
+			continue
+		} else if err != nil {
+			familyFields.WithField("error", err).Debug("failed to set the jump rules")
+			return
+		}
+		_, err = conn.ExecuteBatch(nftablesBatch(messages))
+		if err != nil {
+			familyFields.WithField("error", err).Debug("failed to set the jump rules")
+			return
+		}
+	}
+	argFields.Debug("the jump rules set")
+	return
+}
+
+// containerChainMessages returns the messages creating ContainerChainName if not existed, and flushing it.
+// The dedicated table is also created if not existed.
+func (b *NFTables) containerChainMessages(family uint8) (messages []netlink.Message) {
+	if b.dedicated {
+		messages = append(messages, newNFTablesRequest(nftMsgNewTable, family, unix.NLM_F_CREATE|unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaTableName, b.table),
+		))
+	}
+	return append(messages,
+		newNFTablesRequest(nftMsgNewChain, family, unix.NLM_F_CREATE|unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaChainTable, b.table),
+			netlink.NewStringAttribute(nftaChainName, ContainerChainName),
+		),
+		newNFTablesRequest(nftMsgDelRule, family, unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaRuleTable, b.table),
+			netlink.NewStringAttribute(nftaRuleChain, ContainerChainName),
+		),
+	)
+}
+
+// dedicatedChainMessages returns the messages creating the table and chain if not existed, and flushing the chain.
+func (b *NFTables) dedicatedChainMessages(family uint8, chainName string) (messages []netlink.Message, err error) {
+	hook, exist := nftablesHooks[strings.ToUpper(chainName)]
+	if !exist {
+		err = errors.New("the chain not mapped to the hook of nftables")
+		return
+	}
+	priority := nftablesPriority
+	messages = []netlink.Message{
+		newNFTablesRequest(nftMsgNewTable, family, unix.NLM_F_CREATE|unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaTableName, b.table),
+		),
+		newNFTablesRequest(nftMsgNewChain, family, unix.NLM_F_CREATE|unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaChainTable, b.table),
+			netlink.NewStringAttribute(nftaChainName, hook.name),
+			netlink.NewNestedAttribute(nftaChainHook,
+				netlink.NewUint32Attribute(nftaHookHooknum, hook.hooknum),
+				netlink.NewUint32Attribute(nftaHookPriority, uint32(priority)),
+			),
+			netlink.NewUint32Attribute(nftaChainPolicy, nfAccept),
+			netlink.NewStringAttribute(nftaChainType, nftablesChainType),
+		),
+		newNFTablesRequest(nftMsgDelRule, family, unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaRuleTable, b.table),
+			netlink.NewStringAttribute(nftaRuleChain, hook.name),
+		),
+	}
+	return
+}
+
+// replacingMessages returns the messages removing the rules tagged by cnet and inserting the rules of the expressions at the position of the chain.
+func (b *NFTables) replacingMessages(conn *netlink.Conn, family uint8, chainName string, position int, expressions [][]netlink.Attribute) (messages []netlink.Message, err error) {
+	var listed []nftablesRule
+	listed, err = b.listRules(conn, family, chainName)
+	if err != nil {
+		return
+	}
+	var (
+		others         []nftablesRule
+		positionHandle uint64
+	)
+	for _, rule := range listed {
+		if rule.owned {
+			messages = append(messages, b.deleteRuleMessage(family, chainName, rule.handle))
+			continue
+		}
+		others = append(others, rule)
+	}
+	// NOTE: The rule is inserted before the rule of the position handle, or appended if the handle is zero.
+	if position < 0 {
+		position = 0
+	}
+	if position < len(others) {
+		positionHandle = others[position].handle
+	}
+	for _, ruleExpressions := range expressions {
+		messages = append(messages, b.newRuleMessage(family, chainName, ruleExpressions, positionHandle))
+	}
+	return
+}
+
+// DeleteNFQueueRule delete NFQueue rule in the specified chain, which is every rule tagged by cnet.
+func (b *NFTables) DeleteNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) (err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chainName":  chainName,
+		"protocol":   protocol,
+		"queueNum":   queueNum,
+		"queueCount": queueCount,
+		"bypass":     bypass,
+		"backend":    b.name,
+	})
+	_, err = b.RemoveOwnedRules(chainName)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to delete the nfqueue rule")
+		return
+	}
+	argFields.Debug("the nfqueue rule deleted")
+	return
+}
+
+// ExistsNFQueueRule reports whether the rules tagged by cnet in ContainerChainName are the same number as NFQueue rule.
+func (b *NFTables) ExistsNFQueueRule(chainName, protocol string, queueNum, queueCount uint16, bypass bool) (exist bool) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chain_name":  chainName,
+		"protocol":    protocol,
+		"queue_num":   queueNum,
+		"queue_count": queueCount,
+		"bypass":      bypass,
+		"backend":     b.name,
+	})
+	defer func() {
+		argFields.WithField("exist", exist).Debug("nfqueue rule exist checked")
+	}()
+
+	name, err := b.chainName(chainName)
+	if err != nil {
+		return
+	}
+	rules, err := nfqueueRules(protocol, queueNum, queueCount, bypass)
+	if err != nil {
+		return
+	}
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for i, family := range b.families {
+		if !b.dedicated {
+			if _, getErr := conn.Execute(b.getChainMessage(family, name)); getErr != nil {
+				if i == 0 {
+					return
+				}
+				continue
+			}
+		}
+		listed, listErr := b.listRules(conn, family, ContainerChainName)
+		if listErr != nil {
+			exist = false
+			return
+		}
+		owned := 0
+		for _, rule := range listed {
+			if rule.owned {
+				owned++
+			}
+		}
+		exist = owned == len(rules)
+		if !exist {
+			return
+		}
+	}
+	return
+}
+
+// RemoveOwnedRules removes the rules tagged by cnet in the chain and ContainerChainName, and returns the number of the removed rules.
+// The dedicated chain is deleted with the rules, ContainerChainName is deleted if no chain jumps to it,
+// and the dedicated table is deleted if no chain is left.
+func (b *NFTables) RemoveOwnedRules(chainName string) (removed int, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"chain_name": chainName,
+		"backend":    b.name,
+	})
+	argFields.Debug("trying to remove the rules tagged by cnet")
+
+	var (
+		name string
+		conn *netlink.Conn
+	)
+	name, err = b.chainName(chainName)
+	if err == nil {
+		conn, err = netlink.Dial(unix.NETLINK_NETFILTER)
+	}
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to remove the rules tagged by cnet")
+		return
+	}
+	defer conn.Close()
+
+	for _, family := range b.families {
+		var count int
+		count, err = b.removeOwnedRules(conn, family, name)
+		removed += count
+		if err == nil {
+			count, err = b.removeContainerChain(conn, family)
+			removed += count
+		}
+		if err == nil && b.dedicated {
+			err = b.deleteEmptyTable(conn, family)
+		}
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to remove the rules tagged by cnet")
+			return
+		}
+	}
+	argFields.WithField("removed", removed).Debug("the rules tagged by cnet removed")
+	return
+}
+
+// removeOwnedRules removes the rules tagged by cnet in the chain, or the dedicated chain with the rules.
+func (b *NFTables) removeOwnedRules(conn *netlink.Conn, family uint8, chainName string) (removed int, err error) {
+	var listed []nftablesRule
+	listed, err = b.listRules(conn, family, chainName)
+	if errors.Is(err, syscall.ENOENT) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	var messages []netlink.Message
+	for _, rule := range listed {
+		if !rule.owned {
+			continue
+		}
+		removed++
+		if !b.dedicated {
+			messages = append(messages, b.deleteRuleMessage(family, chainName, rule.handle))
+		}
+	}
+	if b.dedicated {
+		messages = append(messages, b.deleteChainMessage(family, chainName))
+	}
+	if len(messages) == 0 {
+		return
+	}
+	_, err = conn.ExecuteBatch(nftablesBatch(messages))
+	if err != nil {
+		removed = 0
+	}
+	return
+}
+
+// removeContainerChain deletes ContainerChainName with the rules if no chain jumps to it.
+func (b *NFTables) removeContainerChain(conn *netlink.Conn, family uint8) (removed int, err error) {
+	var listed []nftablesRule
+	listed, err = b.listRules(conn, family, ContainerChainName)
+	if errors.Is(err, syscall.ENOENT) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	_, err = conn.ExecuteBatch(nftablesBatch([]netlink.Message{
+		newNFTablesRequest(nftMsgDelRule, family, unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaRuleTable, b.table),
+			netlink.NewStringAttribute(nftaRuleChain, ContainerChainName),
+		),
+		b.deleteChainMessage(family, ContainerChainName),
+	}))
+	// NOTE: The chain still jumped to from the other chains is kept.
+	if errors.Is(err, syscall.EBUSY) {
+		err = nil
+		return
+	} else if err != nil {
+		return
+	}
+	for _, rule := range listed {
+		if rule.owned {
+			removed++
+		}
+	}
+	return
+}
+
+// deleteEmptyTable deletes the dedicated table if no chain is left.
+func (b *NFTables) deleteEmptyTable(conn *netlink.Conn, family uint8) (err error) {
+	var replies []netlink.Message
+	replies, err = conn.Execute(newNFTablesRequest(nftMsgGetChain, family, unix.NLM_F_DUMP))
+	if err != nil {
+		return
+	}
+	for _, reply := range replies {
+		attributes, parseErr := nftablesAttributes(reply)
+		if parseErr != nil {
+			continue
+		}
+		for _, attribute := range attributes {
+			if attribute.Kind() == nftaChainTable && attribute.String() == b.table {
+				return
+			}
+		}
+	}
+	_, err = conn.ExecuteBatch(nftablesBatch([]netlink.Message{
+		newNFTablesRequest(nftMsgDelTable, family, unix.NLM_F_ACK,
+			netlink.NewStringAttribute(nftaTableName, b.table),
+		),
+	}))
+	if errors.Is(err, syscall.ENOENT) {
+		err = nil
+	}
+	return
+}
+
+// listRules returns the rules of the chain in order.
+func (b *NFTables) listRules(conn *netlink.Conn, family uint8, chainName string) (rules []nftablesRule, err error) {
+	// NOTE: The dump of the rules is filtered by the table and chain, and it is empty if they do not exist.
+	_, err = conn.Execute(newNFTablesRequest(nftMsgGetChain, family, 0,
+		netlink.NewStringAttribute(nftaChainTable, b.table),
+		netlink.NewStringAttribute(nftaChainName, chainName),
+	))
+	if err != nil {
+		return
+	}
+	var replies []netlink.Message
+	replies, err = conn.Execute(newNFTablesRequest(nftMsgGetRule, family, unix.NLM_F_DUMP,
+		netlink.NewStringAttribute(nftaRuleTable, b.table),
+		netlink.NewStringAttribute(nftaRuleChain, chainName),
+	))
+	if err != nil {
+		return
+	}
+	for _, reply := range replies {
+		attributes, parseErr := nftablesAttributes(reply)
+		if parseErr != nil {
+			continue
+		}
+		var rule nftablesRule
+		for _, attribute := range attributes {
+			switch attribute.Kind() {
+			case nftaRuleHandle:
+				rule.handle = attribute.Uint64()
+			case nftaRuleUserdata:
+				rule.owned = nftablesComment(attribute.Data) == OwnerComment
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return
+}
+
+func (b *NFTables) newRuleMessage(family uint8, chainName string, expressions []netlink.Attribute, positionHandle uint64) netlink.Message {
+	flags := uint16(unix.NLM_F_CREATE | unix.NLM_F_ACK)
+	attributes := []netlink.Attribute{
+		netlink.NewStringAttribute(nftaRuleTable, b.table),
+		netlink.NewStringAttribute(nftaRuleChain, chainName),
+		netlink.NewNestedAttribute(nftaRuleExpressions, expressions...),
+		netlink.NewAttribute(nftaRuleUserdata, nftablesUserdataComment(OwnerComment)),
+	}
+	if positionHandle != 0 {
+		attributes = append(attributes, netlink.NewUint64Attribute(nftaRulePosition, positionHandle))
+	} else {
+		flags |= unix.NLM_F_APPEND
+	}
+	return newNFTablesRequest(nftMsgNewRule, family, flags, attributes...)
+}
+
+func (b *NFTables) deleteRuleMessage(family uint8, chainName string, handle uint64) netlink.Message {
+	return newNFTablesRequest(nftMsgDelRule, family, unix.NLM_F_ACK,
+		netlink.NewStringAttribute(nftaRuleTable, b.table),
+		netlink.NewStringAttribute(nftaRuleChain, chainName),
+		netlink.NewUint64Attribute(nftaRuleHandle, handle),
+	)
+}
+
+func (b *NFTables) getChainMessage(family uint8, chainName string) netlink.Message {
+	return newNFTablesRequest(nftMsgGetChain, family, 0,
+		netlink.NewStringAttribute(nftaChainTable, b.table),
+		netlink.NewStringAttribute(nftaChainName, chainName),
+	)
+}
+
+func (b *NFTables) deleteChainMessage(family uint8, chainName string) netlink.Message {
+	return newNFTablesRequest(nftMsgDelChain, family, unix.NLM_F_ACK,
+		netlink.NewStringAttribute(nftaChainTable, b.table),
+		netlink.NewStringAttribute(nftaChainName, chainName),
+	)
+}
+
+// jumpExpressions returns the expressions of the jump rule.
+// NOTE: The family of the packet is matched in the table of inet, which has both IPv4 and IPv6.
+func (b *NFTables) jumpExpressions(rule *jumpRule) (expressions []netlink.Attribute, err error) {
+	if rule.port != 0 {
+		// NOTE: The port is matched by the payload, which iptables-nft also uses for the ports instead of xt_tcpudp.
+		portData := make([]byte, 2)
+		binary.BigEndian.PutUint16(portData, rule.port)
+		expressions = []netlink.Attribute{
+			nftablesExpression("meta",
+				netlink.NewUint32Attribute(nftaMetaKey, nftMetaL4Proto),
+				netlink.NewUint32Attribute(nftaMetaDreg, nftReg1),
+			),
+			nftablesCmpExpression(nftCmpEq, []byte{rule.protocol}),
+			nftablesExpression("payload",
+				netlink.NewUint32Attribute(nftaPayloadDreg, nftReg1),
+				netlink.NewUint32Attribute(nftaPayloadBase, nftPayloadTransportHeader),
+				netlink.NewUint32Attribute(nftaPayloadOffset, destinationPortOffset),
+				netlink.NewUint32Attribute(nftaPayloadLen, uint32(len(portData))),
+			),
+			nftablesCmpExpression(nftCmpEq, portData),
+			nftablesJumpExpression(ContainerChainName),
+		}
+		return
+	}
+	if rule.cgroupPath != "" {
+		expressions, err = b.cgroupExpressions(rule.cgroupPath)
+		if err != nil {
+			return
+		}
+		expressions = append(expressions, nftablesJumpExpression(ContainerChainName))
+		return
+	}
+	address := rule.address()
+	offset, family := ipv4SourceOffset, nfprotoIPv4
+	if !rule.ipv4() {
+		offset, family = ipv6SourceOffset, nfprotoIPv6
+	}
+	if !rule.source {
+		offset += uint32(len(address))
+	}
+	if b.families[0] == nfprotoInet {
+		expressions = append(expressions,
+			nftablesExpression("meta",
+				netlink.NewUint32Attribute(nftaMetaKey, nftMetaNFProto),
+				netlink.NewUint32Attribute(nftaMetaDreg, nftReg1),
+			),
+			nftablesCmpExpression(nftCmpEq, []byte{family}),
+		)
+	}
+	expressions = append(expressions,
+		nftablesExpression("payload",
+			netlink.NewUint32Attribute(nftaPayloadDreg, nftReg1),
+			netlink.NewUint32Attribute(nftaPayloadBase, nftPayloadNetworkHeader),
+			netlink.NewUint32Attribute(nftaPayloadOffset, offset),
+			netlink.NewUint32Attribute(nftaPayloadLen, uint32(len(address))),
+		),
+		nftablesCmpExpression(nftCmpEq, address),
+		nftablesJumpExpression(ContainerChainName),
+	)
+	return
+}
+
+// cgroupExpressions returns the expressions matching the packet of the socket in the cgroup of the unified hierarchy or its descendants.
+// The socket expression compares the ID of the ancestor of the cgroup of the socket at the level of the cgroup,
+// and looks up the socket of the packet not attached yet, such as the packet of the new connection in INPUT.
+func (b *NFTables) cgroupExpressions(cgroupPath string) (expressions []netlink.Attribute, err error) {
+	if b.compat {
+		match := newCgroupMatch(cgroupPath)
+		expressions = []netlink.Attribute{nftablesCompatExpression("match", &match)}
+		return
+	}
+	var id uint64
+	id, err = proc.RetrieveUnifiedCgroupID(cgroupPath)
+	if err != nil {
+		return
+	}
+	idData := make([]byte, 8)
+	netlink.NativeEndian.PutUint64(idData, id)
+	level := uint32(len(strings.FieldsFunc(cgroupPath, func(r rune) bool { return r == '/' })))
+	expressions = []netlink.Attribute{
+		nftablesExpression("socket",
+			netlink.NewUint32Attribute(nftaSocketKey, nftSocketCgroupv2),
+			netlink.NewUint32Attribute(nftaSocketDreg, nftReg1),
+			netlink.NewUint32Attribute(nftaSocketLevel, level),
+		),
+		nftablesCmpExpression(nftCmpEq, idData),
+	}
+	return
+}
+
+// expressions returns the expressions of the rule.
+func (b *NFTables) expressions(rule *nfqueueRule) (expressions []netlink.Attribute) {
+	if rule.protocol != 0 {
+		expressions = append(expressions,
+			nftablesExpression("meta",
+				netlink.NewUint32Attribute(nftaMetaKey, nftMetaL4Proto),
+				netlink.NewUint32Attribute(nftaMetaDreg, nftReg1),
+			),
+			nftablesCmpExpression(nftCmpEq, []byte{rule.protocol}),
+		)
+	}
+	if b.compat {
+		for _, match := range rule.xtMatches() {
+			expressions = append(expressions, nftablesCompatExpression("match", &match))
+		}
+	} else {
+		if rule.connmark != 0 {
+			expressions = append(expressions, nftablesCTExpressions(nftCTMark, conntrack.MarkMask, nftCmpEq, rule.connmark)...)
+		}
+		if rule.ctState != 0 {
+			expressions = append(expressions, nftablesCTExpressions(nftCTState, rule.ctState, nftCmpNeq, 0)...)
+		}
+	}
+	switch rule.verdict {
+	case verdictDrop:
+		expressions = append(expressions, nftablesVerdictExpression(nfDrop))
+	case verdictAccept:
+		expressions = append(expressions, nftablesVerdictExpression(nfAccept))
+	case verdictQueue:
+		if b.compat {
+			target := newNFQueueTarget(rule.queueNum, rule.queueCount, rule.bypass)
+			expressions = append(expressions, nftablesCompatExpression("target", &target))
+			break
+		}
+		var queueFlags uint16
+		if rule.bypass {
+			queueFlags |= nftQueueFlagBypass
+		}
+		expressions = append(expressions, nftablesExpression("queue",
+			netlink.NewUint16Attribute(nftaQueueNum, rule.queueNum),
+			netlink.NewUint16Attribute(nftaQueueTotal, rule.queueCount),
+			netlink.NewUint16Attribute(nftaQueueFlags, queueFlags),
+		))
+	}
+	return
+}
+
+func nftablesExpression(name string, attributes ...netlink.Attribute) netlink.Attribute {
+	return netlink.NewNestedAttribute(nftaListElem,
+		netlink.NewStringAttribute(nftaExprName, name),
+		netlink.NewNestedAttribute(nftaExprData, attributes...),
+	)
+}
+
+// nftablesCompatExpression returns the expression of the match or target of x_tables.
+func nftablesCompatExpression(kind string, extension *xtExtension) netlink.Attribute {
+	return nftablesExpression(kind,
+		netlink.NewStringAttribute(nftaCompatName, extension.name),
+		netlink.NewUint32Attribute(nftaCompatRev, uint32(extension.revision)),
+		netlink.NewAttribute(nftaCompatInfo, extension.alignedInfo()),
+	)
+}
+
+func nftablesCmpExpression(op uint32, data []byte) netlink.Attribute {
+	return nftablesExpression("cmp",
+		netlink.NewUint32Attribute(nftaCmpSreg, nftReg1),
+		netlink.NewUint32Attribute(nftaCmpOp, op),
+		netlink.NewNestedAttribute(nftaCmpData, netlink.NewAttribute(nftaDataValue, data)),
+	)
+}
+
+// nftablesCTExpressions returns the expressions comparing the masked value of the key of conntrack.
+// NOTE: The values of ct state and mark are in host byte order.
+func nftablesCTExpressions(key, mask, op, value uint32) []netlink.Attribute {
+	maskData, xorData, valueData := make([]byte, 4), make([]byte, 4), make([]byte, 4)
+	netlink.NativeEndian.PutUint32(maskData, mask)
+	netlink.NativeEndian.PutUint32(valueData, value)
+	return []netlink.Attribute{
+		nftablesExpression("ct",
+			netlink.NewUint32Attribute(nftaCTKey, key),
+			netlink.NewUint32Attribute(nftaCTDreg, nftReg1),
+		),
+		nftablesExpression("bitwise",
+			netlink.NewUint32Attribute(nftaBitwiseSreg, nftReg1),
+			netlink.NewUint32Attribute(nftaBitwiseDreg, nftReg1),
+			netlink.NewUint32Attribute(nftaBitwiseLen, 4),
+			netlink.NewNestedAttribute(nftaBitwiseMask, netlink.NewAttribute(nftaDataValue, maskData)),
+			netlink.NewNestedAttribute(nftaBitwiseXor, netlink.NewAttribute(nftaDataValue, xorData)),
+		),
+		nftablesCmpExpression(op, valueData),
+	}
+}
+
+func nftablesVerdictExpression(code uint32) netlink.Attribute {
+	return nftablesExpression("immediate",
+		netlink.NewUint32Attribute(nftaImmediateDreg, nftRegVerdict),
+		netlink.NewNestedAttribute(nftaImmediateData,
+			netlink.NewNestedAttribute(nftaDataVerdict, netlink.NewUint32Attribute(nftaVerdictCode, code)),
+		),
+	)
+}
+
+func nftablesJumpExpression(chainName string) netlink.Attribute {
+	return nftablesExpression("immediate",
+		netlink.NewUint32Attribute(nftaImmediateDreg, nftRegVerdict),
+		netlink.NewNestedAttribute(nftaImmediateData,
+			netlink.NewNestedAttribute(nftaDataVerdict,
+				netlink.NewUint32Attribute(nftaVerdictCode, nftJump),
+				netlink.NewStringAttribute(nftaVerdictChain, chainName),
+			),
+		),
+	)
+}
+
+// nftablesUserdataComment returns the userdata of the rule having the comment, which is the type, length and value.
+func nftablesUserdataComment(comment string) []byte {
+	value := append([]byte(comment), 0)
+	return append([]byte{nftUdataRuleComment, uint8(len(value))}, value...)
+}
+
+// nftablesComment returns the comment in the userdata of the rule.
+func nftablesComment(userdata []byte) string {
+	for len(userdata) >= 2 {
+		kind, length := userdata[0], int(userdata[1])
+		if 2+length > len(userdata) {
+			break
+		}
+		if kind == nftUdataRuleComment {
+			return cString(userdata[2 : 2+length])
+		}
+		userdata = userdata[2+length:]
+	}
+	return ""
+}
+
+func nftablesAttributes(message netlink.Message) ([]netlink.Attribute, error) {
+	if len(message.Data) < 4 {
+		return nil, errors.New("nf_tables message truncated")
+	}
+	return netlink.UnmarshalAttributes(message.Data[4:])
+}
+
+func newNFTablesRequest(messageType uint16, family uint8, flags uint16, attributes ...netlink.Attribute) netlink.Message {
+	// NOTE: The nfgenmsg header, which is family, version and res_id, precedes the attributes.
+	header := []byte{family, 0, 0, 0}
+	return netlink.Message{
+		Header: unix.NlMsghdr{Type: nfnlSubsysNFTables<<8 | messageType, Flags: flags},
+		Data:   append(header, netlink.MarshalAttributes(attributes)...),
+	}
+}
+
+// nftablesBatch encloses the messages by the begin and end messages, so that they are applied in one transaction.
+func nftablesBatch(messages []netlink.Message) []netlink.Message {
+	// NOTE: The res_id of the batch messages is the subsystem in network byte order.
+	header := []byte{unix.AF_UNSPEC, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[2:4], nfnlSubsysNFTables)
+	batch := []netlink.Message{{Header: unix.NlMsghdr{Type: nfnlMsgBatchBegin}, Data: header}}
+	batch = append(batch, messages...)
+	return append(batch, netlink.Message{Header: unix.NlMsghdr{Type: nfnlMsgBatchEnd}, Data: header})
+}
@@ -0,0 +1,164 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ProtectedContainers is the jump rules for the IP addresses of the containers protected by the policy.
+	ProtectedContainers *JumpRules = &JumpRules{}
+)
+
+// hookedChain is the chain jumping to ContainerChainName at the rule number.
+type hookedChain struct {
+	name    string
+	ruleNum uint16
+	// local is true for the chain of the packets from or to the host, which the containers of the host network send and receive.
+	local bool
+}
+
+// JumpRules keeps the rules jumping to ContainerChainName in the hooked chains consistent with the IP addresses,
+// so that only the packets of the protected containers are queued.
+type JumpRules struct {
+	chains      []hookedChain
+	ipAddresses []net.IP
+	// hostCgroups are the cgroups of the protected containers using the host network, and hostPorts are the ports they listen on.
+	hostCgroups []string
+	hostPorts   []HostPort
+	mutex       sync.Mutex
+}
+
+func (j *JumpRules) String() string {
+	return fmt.Sprintf("{IPAddresses:%v HostCgroups:%v HostPorts:%v}", j.ipAddresses, j.hostCgroups, j.hostPorts)
+}
+
+// Hook sets the jump rules for the current IP addresses in the chain at the rule number by the current backend.
+// The local chain, such as INPUT and OUTPUT, also jumps for the packets of the cgroups or to the ports while the host network is protected.
+func (j *JumpRules) Hook(chainName string, ruleNum uint16, local bool) (err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	chain := hookedChain{name: chainName, ruleNum: ruleNum, local: local}
+	err = chain.setJumpRules(j.ipAddresses, j.hostCgroups, j.hostPorts)
+	if err != nil {
+		return
+	}
+	j.chains = append(j.chains, chain)
+	return
+}
+
+func (c *hookedChain) setJumpRules(ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) error {
+	if !c.local {
+		hostCgroups, hostPorts = nil, nil
+	}
+	return SetJumpRules(c.name, c.ruleNum, ipAddresses, hostCgroups, hostPorts)
+}
+
+// Update replaces the jump rules in the hooked chains if the IP addresses, or the cgroups or the ports of the host network are changed,
+// and reports whether they are replaced. The cgroups are the paths in the unified hierarchy.
+func (j *JumpRules) Update(ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort) (updated bool, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"jump_rules":   j,
+		"ip_addresses": ipAddresses,
+		"host_cgroups": hostCgroups,
+		"host_ports":   hostPorts,
+	})
+	argFields.Debug("trying to update the jump rules")
+
+	sorted := make([]net.IP, 0, len(ipAddresses))
+	for _, ipAddress := range ipAddresses {
+		if ipAddress.To16() != nil {
+			sorted = append(sorted, ipAddress.To16())
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool { return bytes.Compare(sorted[a], sorted[b]) < 0 })
+	unique := sorted[:0]
+	for _, ipAddress := range sorted {
+		if len(unique) == 0 || !ipAddress.Equal(unique[len(unique)-1]) {
+			unique = append(unique, ipAddress)
+		}
+	}
+
+	sortedCgroups := append([]string{}, hostCgroups...)
+	sort.Strings(sortedCgroups)
+	uniqueCgroups := sortedCgroups[:0]
+	for _, hostCgroup := range sortedCgroups {
+		if len(uniqueCgroups) == 0 || hostCgroup != uniqueCgroups[len(uniqueCgroups)-1] {
+			uniqueCgroups = append(uniqueCgroups, hostCgroup)
+		}
+	}
+
+	sortedPorts := append([]HostPort{}, hostPorts...)
+	sort.Slice(sortedPorts, func(a, b int) bool {
+		if sortedPorts[a].Protocol != sortedPorts[b].Protocol {
+			return sortedPorts[a].Protocol < sortedPorts[b].Protocol
+		}
+		return sortedPorts[a].Port < sortedPorts[b].Port
+	})
+	uniquePorts := sortedPorts[:0]
+	for _, hostPort := range sortedPorts {
+		if len(uniquePorts) == 0 || hostPort != uniquePorts[len(uniquePorts)-1] {
+			uniquePorts = append(uniquePorts, hostPort)
+		}
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if equalIPAddresses(j.ipAddresses, unique) && equalCgroups(j.hostCgroups, uniqueCgroups) && equalPorts(j.hostPorts, uniquePorts) {
+		argFields.Debug("the jump rules not changed")
+		return
+	}
+	for i := range j.chains {
+		err = j.chains[i].setJumpRules(unique, uniqueCgroups, uniquePorts)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to update the jump rules")
+			return
+		}
+	}
+	j.ipAddresses, j.hostCgroups, j.hostPorts = unique, uniqueCgroups, uniquePorts
+	updated = true
+	argFields.Debug("the jump rules updated")
+	return
+}
+
+func equalIPAddresses(x, y []net.IP) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if !x[i].Equal(y[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalCgroups(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPorts(x, y []HostPort) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
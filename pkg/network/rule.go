@@ -0,0 +1,157 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/tomo-9925/cnet/pkg/conntrack"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// OwnerComment is the comment tagging the rules installed by cnet, so that the leftovers of crashed cnet are found.
+	OwnerComment string = "cnet"
+	// ContainerChainName is the chain of cnet having NFQueue rule, which the packets of the protected containers jump to.
+	ContainerChainName string = "CNET"
+)
+
+// The bits of ct state, which are 1 << (enum ip_conntrack_info + 1) or the invalid bit, shared by xt_conntrack and nft_ct.
+const (
+	ctStateInvalid uint32 = 1
+	ctStateNew     uint32 = 1 << 3
+)
+
+type ruleVerdict int
+
+const (
+	verdictDrop ruleVerdict = iota
+	verdictAccept
+	verdictQueue
+)
+
+var (
+	// protocolNumbers are the protocols which can be specified in the rule.
+	protocolNumbers map[string]uint8 = map[string]uint8{
+		"all":      0,
+		"icmp":     unix.IPPROTO_ICMP,
+		"tcp":      unix.IPPROTO_TCP,
+		"udp":      unix.IPPROTO_UDP,
+		"dccp":     unix.IPPROTO_DCCP,
+		"sctp":     unix.IPPROTO_SCTP,
+		"icmpv6":   unix.IPPROTO_ICMPV6,
+		"udplite":  unix.IPPROTO_UDPLITE,
+		"udp-lite": unix.IPPROTO_UDPLITE,
+	}
+)
+
+// nfqueueRule is the rule queueing the packets independent of the backend.
+type nfqueueRule struct {
+	// protocol is zero for all protocols.
+	protocol uint8
+	// connmark is the verdict bits of connmark matched, or zero.
+	connmark uint32
+	// ctState is the bits of ct state matched, or zero.
+	ctState    uint32
+	verdict    ruleVerdict
+	queueNum   uint16
+	queueCount uint16
+	bypass     bool
+}
+
+// nfqueueRules returns the rules in order, which queue only the packets of the flows not decided yet.
+// The packets of the flows decided once are accepted or dropped in the kernel by the verdict recorded as connmark.
+func nfqueueRules(protocol string, queueNum, queueCount uint16, bypass bool) (rules []nfqueueRule, err error) {
+	protocolNumber, exist := protocolNumbers[strings.ToLower(protocol)]
+	if !exist {
+		err = errors.New("the protocol not supported")
+		return
+	}
+	if queueCount == 0 {
+		queueCount = 1
+	}
+	rules = []nfqueueRule{
+		{protocol: protocolNumber, connmark: conntrack.MarkDenied, verdict: verdictDrop},
+		{protocol: protocolNumber, connmark: conntrack.MarkAccepted, verdict: verdictAccept},
+		// NOTE: Only the first packets of the new flows and the next packets of the flows whose verdicts are reset are queued.
+		// The queue is selected by the hash of the flow, so that the packets of one flow keep the order.
+		{protocol: protocolNumber, connmark: conntrack.MarkPending, verdict: verdictQueue,
+			queueNum: queueNum, queueCount: queueCount, bypass: bypass},
+		{protocol: protocolNumber, ctState: ctStateNew, verdict: verdictQueue,
+			queueNum: queueNum, queueCount: queueCount, bypass: bypass},
+		{protocol: protocolNumber, ctState: ctStateInvalid, verdict: verdictDrop},
+	}
+	return
+}
+
+// HostPort is the port which the protected container of the host network listens on.
+// The port is matched instead of the cgroup in the chain where the socket is not attached to the packet of the new connection.
+type HostPort struct {
+	// Protocol is the name of the protocol, which is tcp, udp or udplite.
+	Protocol string
+	Port     uint16
+}
+
+// portProtocols are the protocols whose destination ports are matched by the jump rules.
+var portProtocols map[string]struct{} = map[string]struct{}{
+	"tcp":     {},
+	"udp":     {},
+	"udplite": {},
+}
+
+// jumpRule is the rule jumping to ContainerChainName for the packets from or to the IP address of the container,
+// or for the packets of the sockets in the cgroup of the container of the host network, or to the port it listens on.
+type jumpRule struct {
+	// ipAddress is nil for the rule jumping for the packets of the cgroup or to the port.
+	ipAddress net.IP
+	// source is true if the source address is matched, otherwise the destination address is matched.
+	source bool
+	// cgroupPath is the path of the cgroup in the unified hierarchy, such as /system.slice/docker-<id>.scope.
+	cgroupPath string
+	// protocol and port are the destination port matched, or zero.
+	protocol uint8
+	port     uint16
+}
+
+// anyFamily reports whether the rule matches the packets of both IPv4 and IPv6.
+func (r *jumpRule) anyFamily() bool {
+	return r.ipAddress == nil
+}
+
+func (r *jumpRule) ipv4() bool {
+	return r.ipAddress.To4() != nil
+}
+
+// address returns the IP address of the rule in 4 bytes for IPv4 or 16 bytes for IPv6.
+func (r *jumpRule) address() net.IP {
+	if ipAddress := r.ipAddress.To4(); ipAddress != nil {
+		return ipAddress
+	}
+	return r.ipAddress.To16()
+}
+
+// jumpRules returns the rules jumping for the packets from and to the IP addresses, and for the packets of the containers of the host network,
+// which have the IP addresses of the host.
+// The packets of the containers of the host network are matched by the sockets in their cgroups if the socket of the packet is attached in the chain,
+// otherwise by the ports they listen on, such as the packet of the new connection in INPUT.
+func jumpRules(ipAddresses []net.IP, hostCgroups []string, hostPorts []HostPort, socketAttached bool) (rules []jumpRule) {
+	for _, ipAddress := range ipAddresses {
+		if ipAddress.To16() == nil {
+			continue
+		}
+		rules = append(rules, jumpRule{ipAddress: ipAddress, source: true}, jumpRule{ipAddress: ipAddress})
+	}
+	if socketAttached {
+		for _, hostCgroup := range hostCgroups {
+			rules = append(rules, jumpRule{cgroupPath: hostCgroup})
+		}
+		return
+	}
+	for _, hostPort := range hostPorts {
+		if _, supported := portProtocols[hostPort.Protocol]; !supported || hostPort.Port == 0 {
+			continue
+		}
+		rules = append(rules, jumpRule{protocol: protocolNumbers[hostPort.Protocol], port: hostPort.Port})
+	}
+	return
+}
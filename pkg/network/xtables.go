@@ -0,0 +1,145 @@
+package network
+
+import (
+	"math"
+
+	"github.com/tomo-9925/cnet/pkg/conntrack"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// The extensions of x_tables defined in linux/netfilter/x_tables.h, xt_comment.h, xt_connmark.h, xt_conntrack.h, xt_cgroup.h, xt_tcpudp.h and xt_NFQUEUE.h.
+const (
+	xtExtensionMaxNameLen int = 29
+	xtExtensionHeaderSize int = 32
+	xtFunctionMaxNameLen  int = 30
+
+	xtStandardTarget string = ""
+	xtErrorTarget    string = "ERROR"
+
+	xtCommentMaxLen int = 256
+
+	xtConntrackInfoSize     int    = 164
+	xtConntrackMatchFlags   int    = 146
+	xtConntrackStateMask    int    = 150
+	xtConntrackState        uint16 = 1 << 0
+	xtCgroupInfoSize        int    = 528
+	xtCgroupPathOffset      int    = 4
+	xtCgroupPathMaxLen      int    = 512
+	xtTCPInfoSize           int    = 12
+	xtUDPInfoSize           int    = 10
+	xtNFQueueFlagBypass     uint16 = 0x01
+	xtStandardVerdictDrop   int32  = -1 // -NF_DROP - 1
+	xtStandardVerdictAccept int32  = -2 // -NF_ACCEPT - 1
+	xtStandardVerdictReturn int32  = -5 // -NF_REPEAT - 1
+)
+
+// xtExtension is the match or target of x_tables, whose info is in host byte order.
+type xtExtension struct {
+	name     string
+	revision uint8
+	info     []byte
+}
+
+func xtAlign(length int) int {
+	return (length + 7) &^ 7
+}
+
+// marshal returns xt_entry_match or xt_entry_target with the info.
+func (e *xtExtension) marshal() (buf []byte) {
+	buf = make([]byte, xtExtensionHeaderSize+xtAlign(len(e.info)))
+	netlink.NativeEndian.PutUint16(buf[0:2], uint16(len(buf)))
+	copy(buf[2:2+xtExtensionMaxNameLen-1], e.name)
+	buf[xtExtensionHeaderSize-1] = e.revision
+	copy(buf[xtExtensionHeaderSize:], e.info)
+	return
+}
+
+// alignedInfo returns the info padded as the kernel expects.
+func (e *xtExtension) alignedInfo() (info []byte) {
+	info = make([]byte, xtAlign(len(e.info)))
+	copy(info, e.info)
+	return
+}
+
+func newCommentMatch(comment string) xtExtension {
+	info := make([]byte, xtCommentMaxLen)
+	copy(info[:xtCommentMaxLen-1], comment)
+	return xtExtension{name: "comment", info: info}
+}
+
+func newConnmarkMatch(mark uint32) xtExtension {
+	// NOTE: xt_connmark_mtinfo1 is mark, mask and invert.
+	info := make([]byte, 9)
+	netlink.NativeEndian.PutUint32(info[0:4], mark)
+	netlink.NativeEndian.PutUint32(info[4:8], conntrack.MarkMask)
+	return xtExtension{name: "connmark", revision: 1, info: info}
+}
+
+func newConntrackStateMatch(stateMask uint32) xtExtension {
+	// NOTE: Only the state of xt_conntrack_mtinfo3 is used.
+	info := make([]byte, xtConntrackInfoSize)
+	netlink.NativeEndian.PutUint16(info[xtConntrackMatchFlags:], xtConntrackState)
+	netlink.NativeEndian.PutUint16(info[xtConntrackStateMask:], uint16(stateMask))
+	return xtExtension{name: "conntrack", revision: 3, info: info}
+}
+
+func newCgroupMatch(cgroupPath string) xtExtension {
+	// NOTE: xt_cgroup_info_v2 is has_path, has_classid, invert_path, invert_classid, the union of path and classid, and the pointer of the kernel.
+	info := make([]byte, xtCgroupInfoSize)
+	info[0] = 1
+	copy(info[xtCgroupPathOffset:xtCgroupPathOffset+xtCgroupPathMaxLen-1], cgroupPath)
+	return xtExtension{name: "cgroup", revision: 2, info: info}
+}
+
+// newPortMatch returns the match of xt_tcpudp for the destination port, whose name is the protocol.
+func newPortMatch(protocol uint8, port uint16) xtExtension {
+	// NOTE: xt_tcp and xt_udp begin with the ranges of the source ports and the destination ports, and the flags of xt_tcp are zero.
+	info := make([]byte, xtTCPInfoSize)
+	netlink.NativeEndian.PutUint16(info[2:4], math.MaxUint16)
+	netlink.NativeEndian.PutUint16(info[4:6], port)
+	netlink.NativeEndian.PutUint16(info[6:8], port)
+	name := "tcp"
+	switch protocol {
+	case unix.IPPROTO_UDP:
+		name, info = "udp", info[:xtUDPInfoSize]
+	case unix.IPPROTO_UDPLITE:
+		name, info = "udplite", info[:xtUDPInfoSize]
+	}
+	return xtExtension{name: name, info: info}
+}
+
+func newNFQueueTarget(queueNum, queueCount uint16, bypass bool) xtExtension {
+	// NOTE: xt_NFQ_info_v3 is queuenum, queues_total and flags.
+	info := make([]byte, 6)
+	netlink.NativeEndian.PutUint16(info[0:2], queueNum)
+	netlink.NativeEndian.PutUint16(info[2:4], queueCount)
+	if bypass {
+		netlink.NativeEndian.PutUint16(info[4:6], xtNFQueueFlagBypass)
+	}
+	return xtExtension{name: "NFQUEUE", revision: 3, info: info}
+}
+
+// newErrorTarget returns the target of the head of the user-defined chain, which names the chain.
+func newErrorTarget(chainName string) xtExtension {
+	info := make([]byte, xtFunctionMaxNameLen)
+	copy(info[:xtFunctionMaxNameLen-1], chainName)
+	return xtExtension{name: xtErrorTarget, info: info}
+}
+
+func newStandardTarget(verdict int32) xtExtension {
+	info := make([]byte, 4)
+	netlink.NativeEndian.PutUint32(info, uint32(verdict))
+	return xtExtension{name: xtStandardTarget, info: info}
+}
+
+// xtMatches returns the matches of the rule except the protocol, which is matched by the entry.
+func (r *nfqueueRule) xtMatches() (matches []xtExtension) {
+	if r.connmark != 0 {
+		matches = append(matches, newConnmarkMatch(r.connmark))
+	}
+	if r.ctState != 0 {
+		matches = append(matches, newConntrackStateMatch(r.ctState))
+	}
+	return
+}
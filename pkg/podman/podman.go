@@ -0,0 +1,56 @@
+package podman
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/docker"
+)
+
+const (
+	// RuntimeName is the name of podman as the container runtime.
+	RuntimeName string = "podman"
+	// DefaultAddress is the unix socket of the API of rootful podman.
+	DefaultAddress string = "/run/podman/podman.sock"
+
+	// forwardChain is the chain of iptables from which the chains of netavark and CNI bridges are jumped.
+	forwardChain string = "FORWARD"
+)
+
+// Runtime is the container runtime of podman through its API compatible with Docker Engine API.
+// The containers, their events and inspections are the same as docker, while the networks and the processes are not.
+type Runtime struct {
+	*docker.Runtime
+}
+
+var _ container.Runtime = (*Runtime)(nil)
+
+// NewRuntime returns the Runtime connecting to the API of podman on the unix socket.
+func NewRuntime(address string) (runtime *Runtime, err error) {
+	addressField := logrus.WithField("address", address)
+	addressField.Debug("trying to connect to podman")
+
+	runtime = &Runtime{}
+	runtime.Runtime, err = docker.NewCompatibleRuntime("unix://" + address)
+	if err != nil {
+		addressField.WithField("error", err).Debug("failed to connect to podman")
+		return
+	}
+	addressField.Debug("podman connected")
+	return
+}
+
+// Name returns RuntimeName.
+func (r *Runtime) Name() string {
+	return RuntimeName
+}
+
+// DNSHelperPID returns 0, because aardvark-dns of podman listens on the gateway of the network in the host,
+// so the DNS requests in the containers are sent by their own processes.
+func (r *Runtime) DNSHelperPID() int {
+	return 0
+}
+
+// ForwardChain returns FORWARD, because podman does not prepare DOCKER-USER.
+func (r *Runtime) ForwardChain() string {
+	return forwardChain
+}
@@ -18,3 +18,12 @@ func GenerateHash(container *container.Container, proc *proc.Process, socket *pr
 }
 
 
+
+// GenerateOwnersHash returns the hash of the communication through the socket shared by the owners.
+func GenerateOwnersHash(container *container.Container, owners []*proc.Process, socket *proc.Socket) string {
+	hash := container.Hash()
+	for _, owner := range owners {
+		hash += owner.Hash() + "/"
+	}
+	return hash + socket.Hash()
+}
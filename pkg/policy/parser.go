@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net"
 	"strings"
@@ -14,10 +15,17 @@ import (
 
 type yamlPolicies struct {
 	Policies []struct {
+		Pod *struct {
+			Name string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+			Labels map[string]string `yaml:"labels"`
+		}
 		Container struct {
 			Name string `yaml:"name"`
 			ID string `yaml:"id"`
+			Labels map[string]string `yaml:"labels"`
 		}
+		SharedSocket string `yaml:"shared_socket"`
 		Communications []struct {
 			Processes []struct {
 				Executable string `yaml:"executable"`
@@ -57,8 +65,26 @@ func parseYAMLPolicyList(path string) (parsedPolicyList []*Policy, err error) {
 		parsedPolicy := &Policy{Container: &container.Container{
 			Name: yamlPolicy.Container.Name,
 			ID: yamlPolicy.Container.ID,
+			Labels: yamlPolicy.Container.Labels,
 		}}
+		if yamlPolicy.Pod != nil {
+			parsedPolicy.Pod = &container.Pod{
+				Name: yamlPolicy.Pod.Name,
+				Namespace: yamlPolicy.Pod.Namespace,
+				Labels: yamlPolicy.Pod.Labels,
+			}
+		}
 		parsedPolicyList[i] = parsedPolicy
+		switch strings.ToLower(yamlPolicy.SharedSocket) {
+		case "", "any":
+			parsedPolicy.SharedSocket = MatchAnyOwner
+		case "all":
+			parsedPolicy.SharedSocket = MatchAllOwners
+		default:
+			err = fmt.Errorf("shared_socket %q not supported", yamlPolicy.SharedSocket)
+			pathField.WithField("error", err).Debug("failed to parse yaml policy list")
+			return
+		}
 		parsedPolicy.Communications = make([]*Communication, len(yamlPolicy.Communications))
 		for j, yamlCommunication := range yamlPolicy.Communications {
 			parsedCommunication := &Communication{}
@@ -83,17 +109,24 @@ func parseYAMLPolicyList(path string) (parsedPolicyList []*Policy, err error) {
 					parsedSocket.Protocol = layers.LayerTypeTCP
 				case "udp":
 					parsedSocket.Protocol = layers.LayerTypeUDP
+				case "udplite", "udp-lite":
+					parsedSocket.Protocol = layers.LayerTypeUDPLite
+				case "sctp":
+					parsedSocket.Protocol = layers.LayerTypeSCTP
+				case "dccp":
+					parsedSocket.Protocol = proc.LayerTypeDCCP
 				case "icmpv4":
 					parsedSocket.Protocol = layers.LayerTypeICMPv4
 				case "icmpv6":
 					parsedSocket.Protocol = layers.LayerTypeICMPv6
 				}
-				if !strings.Contains(yamlSocket.RemoteIP, "/") {
-					var appendString string = "/32"
-					if strings.Contains(yamlSocket.RemoteIP, ":") {
-						appendString = "/128"
+				if remoteIP := net.ParseIP(yamlSocket.RemoteIP); remoteIP != nil {
+					// NOTE: IPv4-mapped IPv6 address is treated as IPv4 address like net.IP.
+					if remoteIPv4 := remoteIP.To4(); remoteIPv4 != nil {
+						yamlSocket.RemoteIP = strings.Join([]string{remoteIPv4.String(), "/32"}, "")
+					} else {
+						yamlSocket.RemoteIP = strings.Join([]string{remoteIP.String(), "/128"}, "")
 					}
-					yamlSocket.RemoteIP = strings.Join([]string{yamlSocket.RemoteIP, appendString}, "")
 				}
 				_, parsedSocket.RemoteIP, _ = net.ParseCIDR(yamlSocket.RemoteIP)
 			}
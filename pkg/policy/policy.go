@@ -9,18 +9,108 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/sirupsen/logrus"
 	"github.com/tomo-9925/cnet/pkg/container"
-	"github.com/tomo-9925/cnet/pkg/docker"
 	"github.com/tomo-9925/cnet/pkg/proc"
 )
 
+// SharedSocketMatch is how the owners of the socket shared by multiple processes are matched with the policy.
+type SharedSocketMatch int
+
+const (
+	// MatchAnyOwner defines the communication if any owner of the socket matches the policy.
+	MatchAnyOwner SharedSocketMatch = iota
+	// MatchAllOwners defines the communication only if all owners of the socket match the policy.
+	MatchAllOwners
+)
+
+func (m SharedSocketMatch)String() string {
+	switch m {
+	case MatchAnyOwner:
+		return "any"
+	case MatchAllOwners:
+		return "all"
+	}
+	return fmt.Sprintf("SharedSocketMatch(%d)", int(m))
+}
+
 // Policy is information about the communication of container needed to analyze communications of container.
 type Policy struct {
+	// Pod selects the pods of Kubernetes by the namespace, the name and the labels, or is nil for the containers not in pods.
+	Pod            *container.Pod
 	Container      *container.Container
 	Communications []*Communication
+	SharedSocket   SharedSocketMatch
 }
 
 func (p *Policy)String() string {
-	return fmt.Sprintf("{Container:%s Communications:%v}", p.Container, p.Communications)
+	if p.Pod != nil {
+		return fmt.Sprintf("{Pod:%s Container:%s Communications:%v SharedSocket:%s}", p.Pod, p.Container, p.Communications, p.SharedSocket)
+	}
+	return fmt.Sprintf("{Container:%s Communications:%v SharedSocket:%s}", p.Container, p.Communications, p.SharedSocket)
+}
+
+// appliesTo reports whether the policy is for the container matched by the name or ID, and by the labels and the pod if the policy has them.
+// The policy having only the labels or the pod applies to every container matched by them.
+func (p *Policy)appliesTo(targetContainer *container.Container) bool {
+	if p.Pod != nil && !selectsPod(p.Pod, targetContainer.Pod) {
+		return false
+	}
+	if len(p.Container.Labels) != 0 && !targetContainer.HasLabels(p.Container.Labels) {
+		return false
+	}
+	if p.Container.ID == "" && p.Container.Name == "" {
+		return p.Pod != nil || len(p.Container.Labels) != 0
+	}
+	return p.Container.Equal(targetContainer)
+}
+
+// selectsPod reports whether the pod is matched by the namespace, the name and the labels of the selector, which are ignored if empty.
+func selectsPod(selector, targetPod *container.Pod) bool {
+	if targetPod == nil {
+		return false
+	} else if selector.Namespace != "" && selector.Namespace != targetPod.Namespace {
+		return false
+	} else if selector.Name != "" && selector.Name != targetPod.Name {
+		return false
+	}
+	return targetPod.HasLabels(selector.Labels)
+}
+
+// defines reports whether the communication of the process through the socket is defined in the policy.
+func (p *Policy)defines(communicatedProcess *proc.Process, targetSocket *proc.Socket) bool {
+	for _, communication := range p.Communications {
+		for _, policyProcess := range communication.Processes {
+			if !policyProcess.Equal(communicatedProcess) {
+				continue
+			}
+			logrus.WithFields(logrus.Fields{
+				"policy_process": policyProcess,
+				"communicated_process": communicatedProcess,
+			}).Trace("the relevant process found")
+			for _, policySocket := range communication.Sockets {
+				if policySocket.IsMatched(targetSocket) {
+					logrus.WithFields(logrus.Fields{
+						"policy_socket": policySocket,
+						"targetSocket": targetSocket,
+					}).Trace("the relevant socket found")
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// definesOwners reports whether the communication through the socket shared by the owners is defined in the policy.
+func (p *Policy)definesOwners(owners []*proc.Process, targetSocket *proc.Socket) bool {
+	for _, owner := range owners {
+		defined := p.defines(owner, targetSocket)
+		if p.SharedSocket == MatchAnyOwner && defined {
+			return true
+		} else if p.SharedSocket == MatchAllOwners && !defined {
+			return false
+		}
+	}
+	return p.SharedSocket == MatchAllOwners && len(owners) != 0
 }
 
 // Communication is information about process and socket needed to analyze communications of container.
@@ -92,66 +182,67 @@ func (p *Policies)Reload() (err error) {
 }
 
 // IsDefined reports whether the policy is defined.
-func (p *Policies) IsDefined(communicatedContainer *container.Container, communicatedProcess *proc.Process, targetSocket *proc.Socket) (judgement bool) { ////nolint:gocognit
+func (p *Policies) IsDefined(communicatedContainer *container.Container, communicatedProcess *proc.Process, targetSocket *proc.Socket) (judgement bool) {
+	return p.IsDefinedForOwners(communicatedContainer, []*proc.Process{communicatedProcess}, targetSocket)
+}
+
+// IsDefinedForOwners reports whether the policy is defined for the socket shared by the owners.
+// The owners are matched according to SharedSocket of the policy.
+func (p *Policies) IsDefinedForOwners(communicatedContainer *container.Container, owners []*proc.Process, targetSocket *proc.Socket) (judgement bool) {
 	relevantFields := logrus.WithFields(logrus.Fields{
 		"policies": p,
 		"communicated_container": communicatedContainer,
-		"communicated_process": communicatedProcess,
+		"communicated_processes": owners,
 		"target_socket": targetSocket,
 	})
 	relevantFields.Debug("checking whether define the communication in this policies")
 
-	if cacheRawData, exist := PolicyCache.Get(GenerateHash(communicatedContainer,communicatedProcess,targetSocket)); exist {
+	hash := GenerateOwnersHash(communicatedContainer, owners, targetSocket)
+	if cacheRawData, exist := PolicyCache.Get(hash); exist {
 		judgement = cacheRawData.(bool)
 		relevantFields.WithField("judgement", judgement).Debug("checked whether define the communication in this policies")
 		return
 	}
 
-	if targetSocket.Protocol == layers.LayerTypeUDP && targetSocket.RemotePort == 53 {
-		dockerdPath, err := proc.RetrieveProcessPath(docker.PID)
-		if err == nil && communicatedProcess.Path == dockerdPath {
+	if targetSocket.Protocol == layers.LayerTypeUDP && targetSocket.RemotePort == 53 && len(owners) == 1 {
+		dnsHelperPath, err := proc.RetrieveProcessPath(container.DNSHelperPID())
+		if err == nil && owners[0].Path == dnsHelperPath {
 			relevantFields.Debug("the dns request is assumed to be defined")
 			return true
 		}
 	}
 
-	// HACK: So many indents that it's hard to understand. The structure of Policies may need to be rethought.
 	p.RWMutex.RLock()
-	comparePolicy:
 	for _, policy := range p.List {
-		if !policy.Container.Equal(communicatedContainer) {
+		if !policy.appliesTo(communicatedContainer) {
 			continue
 		}
 		logrus.WithFields(logrus.Fields{
 			"policy_container": policy.Container,
 			"communicated_container": communicatedContainer,
 		}).Trace("the relevant container found")
-		for _, communication := range policy.Communications {
-			for _, policyProcess := range communication.Processes {
-				if !policyProcess.Equal(communicatedProcess) {
-					continue
-				}
-				logrus.WithFields(logrus.Fields{
-					"policy_process": policyProcess,
-					"communicated_process": communicatedProcess,
-				}).Trace("the relevant process found")
-				for _, policySocket := range communication.Sockets {
-					if policySocket.IsMatched(targetSocket) {
-						logrus.WithFields(logrus.Fields{
-							"policy_socket": policySocket,
-							"targetSocket": targetSocket,
-						}).Trace("the relevant socket found")
-						relevantFields.Debug("the communication defined")
-						judgement = true
-						break comparePolicy
-					}
-				}
-			}
+		if policy.definesOwners(owners, targetSocket) {
+			relevantFields.WithField("shared_socket", policy.SharedSocket).Debug("the communication defined")
+			judgement = true
+			break
 		}
 	}
 	p.RWMutex.RUnlock()
 
-	PolicyCache.Set(GenerateHash(communicatedContainer,communicatedProcess,targetSocket), judgement,0)
+	PolicyCache.Set(hash, judgement, 0)
 	relevantFields.WithField("judgement", judgement).Debug("checked whether define the communication in this policies")
 	return
 }
+
+// Protects reports whether the communications of the container are controlled by the policy.
+func (p *Policies) Protects(targetContainer *container.Container) (protected bool) {
+	p.RWMutex.RLock()
+	defer p.RWMutex.RUnlock()
+	for _, policy := range p.List {
+		if policy.appliesTo(targetContainer) {
+			protected = true
+			return
+		}
+	}
+	return
+}
@@ -9,4 +9,8 @@ import (
 var (
 	// SocketCache stores the Process identified by the Socket
 	SocketCache *cache.Cache = cache.New(time.Hour, 2*time.Hour)
+	// OwnerContainerCache stores the Container owning the Socket in the network namespace shared by multiple containers
+	OwnerContainerCache *cache.Cache = cache.New(time.Hour, 2*time.Hour)
+	// NetworkNamespaceCache stores the network namespace of the Container by its ID with the pid, which is deleted when the Container is removed
+	NetworkNamespaceCache *cache.Cache = cache.New(cache.NoExpiration, 0)
 )
@@ -0,0 +1,294 @@
+package proc
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"golang.org/x/sys/unix"
+)
+
+// cgroupHierarchy is a cgroup hierarchy mounted under cgroupPath.
+type cgroupHierarchy struct {
+	mountPath  string // directory of the hierarchy in the host filesystem
+	controller string // controller of /proc/<pid>/cgroup entry ("" is cgroup v2)
+}
+
+// detectCgroupHierarchy returns the cgroup hierarchy used to enumerate processes of containers.
+// The unified hierarchy (cgroup v2) has priority, and the pids, memory, systemd hierarchy of cgroup v1 are used otherwise.
+func detectCgroupHierarchy() (hierarchy cgroupHierarchy, err error) {
+	logrus.Debug("trying to detect cgroup hierarchy")
+
+	if _, err = os.Stat(filepath.Join(cgroupPath(), "cgroup.controllers")); err == nil {
+		hierarchy = cgroupHierarchy{mountPath: cgroupPath()}
+		logrus.WithField("cgroup_hierarchy", hierarchy).Debug("cgroup hierarchy detected")
+		return
+	}
+	for _, controller := range []string{"pids", "memory", "name=systemd"} {
+		mountPath := filepath.Join(cgroupPath(), strings.TrimPrefix(controller, "name="))
+		if _, err = os.Stat(mountPath); err == nil {
+			hierarchy = cgroupHierarchy{mountPath: mountPath, controller: controller}
+			logrus.WithField("cgroup_hierarchy", hierarchy).Debug("cgroup hierarchy detected")
+			return
+		}
+	}
+	err = errors.New("cgroup hierarchy not found")
+	logrus.WithField("error", err).Debug("failed to detect cgroup hierarchy")
+	return
+}
+
+// RetrieveCgroupPath gets the cgroup path of the process from cgroup of proc filesystem.
+func RetrieveCgroupPath(pid int) (cgroup string, err error) {
+	argFields := logrus.WithField("pid", pid)
+	argFields.Debug("trying to retrieve cgroup path")
+
+	var hierarchy cgroupHierarchy
+	hierarchy, err = detectCgroupHierarchy()
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve cgroup path")
+		return
+	}
+
+	var file []byte
+	file, err = ioutil.ReadFile(filepath.Join(procPath(), strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve cgroup path")
+		return
+	}
+
+	// The entry format is "hierarchy-ID:controller-list:cgroup-path".
+	rowScanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&file))))
+	for rowScanner.Scan() {
+		columns := strings.SplitN(rowScanner.Text(), ":", 3)
+		if len(columns) != 3 {
+			continue
+		}
+		if hierarchy.controller == "" {
+			if columns[0] != "0" || columns[1] != "" {
+				continue
+			}
+		} else if !containsController(columns[1], hierarchy.controller) {
+			continue
+		}
+		cgroup = columns[2]
+		argFields.WithField("cgroup_path", cgroup).Debug("the cgroup path retrieved")
+		return
+	}
+	err = errors.New("cgroup entry not found")
+	argFields.WithField("error", err).Debug("failed to retrieve cgroup path")
+	return
+}
+
+// RetrieveUnifiedCgroupPath gets the path of the process in the unified hierarchy (cgroup v2), which the sockets of the process are tagged with.
+// The unified hierarchy is used even if the other hierarchies of cgroup v1 are mounted together, such as the hybrid hierarchy of systemd.
+func RetrieveUnifiedCgroupPath(pid int) (cgroup string, err error) {
+	argFields := logrus.WithField("pid", pid)
+	argFields.Debug("trying to retrieve unified cgroup path")
+
+	var file []byte
+	file, err = ioutil.ReadFile(filepath.Join(procPath(), strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve unified cgroup path")
+		return
+	}
+	rowScanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&file))))
+	for rowScanner.Scan() {
+		if columns := strings.SplitN(rowScanner.Text(), ":", 3); len(columns) == 3 && columns[0] == "0" && columns[1] == "" {
+			cgroup = columns[2]
+			argFields.WithField("cgroup_path", cgroup).Debug("the unified cgroup path retrieved")
+			return
+		}
+	}
+	err = errors.New("unified cgroup entry not found")
+	argFields.WithField("error", err).Debug("failed to retrieve unified cgroup path")
+	return
+}
+
+// RetrieveUnifiedCgroupID gets the ID of the cgroup in the unified hierarchy, which is the inode number of its directory.
+// The unified hierarchy is mounted at the cgroup filesystem, or at its unified directory for the hybrid hierarchy of systemd.
+func RetrieveUnifiedCgroupID(cgroup string) (id uint64, err error) {
+	argFields := logrus.WithField("cgroup_path", cgroup)
+	argFields.Debug("trying to retrieve unified cgroup id")
+
+	mountPath := cgroupPath()
+	if _, err = os.Stat(filepath.Join(mountPath, "cgroup.controllers")); err != nil {
+		mountPath = filepath.Join(cgroupPath(), "unified")
+	}
+	var stat unix.Stat_t
+	if err = unix.Stat(filepath.Join(mountPath, cgroup), &stat); err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve unified cgroup id")
+		return
+	}
+	id = stat.Ino
+	argFields.WithField("cgroup_id", id).Debug("the unified cgroup id retrieved")
+	return
+}
+
+func containsController(controllerList, controller string) bool {
+	for _, listedController := range strings.Split(controllerList, ",") {
+		if listedController == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// RetrievePIDsOfCgroup gets recursively pids from cgroup.procs of the cgroup and its descendant cgroups.
+func RetrievePIDsOfCgroup(cgroup string) (pids []int, err error) {
+	argFields := logrus.WithField("cgroup_path", cgroup)
+	argFields.Debug("trying to retrieve pids of cgroup")
+
+	var hierarchy cgroupHierarchy
+	hierarchy, err = detectCgroupHierarchy()
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve pids of cgroup")
+		return
+	}
+
+	err = filepath.Walk(filepath.Join(hierarchy.mountPath, cgroup), func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		file, readErr := ioutil.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if readErr != nil {
+			// NOTE: The cgroup may be removed while walking.
+			if os.IsNotExist(readErr) {
+				return nil
+			}
+			return readErr
+		}
+		scanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&file))))
+		scanner.Split(bufio.ScanWords)
+		for scanner.Scan() {
+			pid, atoiErr := strconv.Atoi(scanner.Text())
+			if atoiErr != nil {
+				return atoiErr
+			}
+			pids = append(pids, pid)
+		}
+		return nil
+	})
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve pids of cgroup")
+		return
+	}
+	if len(pids) == 0 {
+		err = errors.New("process of cgroup not found")
+		argFields.WithField("error", err).Debug("failed to retrieve pids of cgroup")
+		return
+	}
+
+	argFields.WithField("retrieved_pids", pids).Debug("the pids of cgroup retrieved")
+	return
+}
+
+// RetrievePIDsOfContainer gets all pids of the container.
+// The processes are enumerated from the cgroup of the container, and from the process tree of the supervisor if the cgroup is not available.
+// The supervisor is the parent of the main process, such as containerd-shim of docker and conmon of podman.
+func RetrievePIDsOfContainer(targetContainer *container.Container) (pids []int, err error) {
+	argFields := logrus.WithField("target_container", targetContainer)
+	argFields.Debug("trying to retrieve pids of container")
+
+	var cgroup string
+	cgroup, err = RetrieveCgroupPath(targetContainer.Pid)
+	if err == nil {
+		pids, err = RetrievePIDsOfCgroup(cgroup)
+		if err == nil {
+			argFields.WithField("retrieved_pids", pids).Debug("the pids of container retrieved")
+			return
+		}
+	}
+	argFields.WithField("warn", err).Debug("could not retrieve the pids from cgroup")
+
+	var supervisorPid int
+	supervisorPid, err = RetrievePPID(targetContainer.Pid)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve pids of container")
+		return
+	}
+	argFields.WithField("pid_of_supervisor", supervisorPid).Trace("pid of the supervisor of container retrieved")
+	pids, err = RetrieveChildPIDs(supervisorPid)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve pids of container")
+		return
+	}
+	// NOTE: The processes executed by podman exec hang off the other conmon per exec session.
+	if executable, nameErr := RetrieveProcessName(supervisorPid); nameErr == nil && executable == conmonName {
+		var execPids []int
+		execPids, err = retrieveExecPIDsOfConmon(targetContainer.ID, supervisorPid)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to retrieve pids of container")
+			return
+		}
+		pids = append(pids, execPids...)
+	}
+	argFields.WithField("retrieved_pids", pids).Debug("the pids of container retrieved")
+	return
+}
+
+// conmonName is the monitor of the container of podman, which is the parent of the processes of the container like containerd-shim.
+const conmonName string = "conmon"
+
+// retrieveExecPIDsOfConmon gets the child pids of conmon monitoring the exec sessions of the container, other than the supervisor.
+func retrieveExecPIDsOfConmon(cid string, supervisorPid int) (pids []int, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"container_id":      cid,
+		"pid_of_supervisor": supervisorPid,
+	})
+	argFields.Debug("trying to retrieve pids of exec sessions")
+
+	var files []os.FileInfo
+	files, err = ioutil.ReadDir(procPath())
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve pids of exec sessions")
+		return
+	}
+	for _, file := range files {
+		pid, atoiErr := strconv.Atoi(file.Name())
+		if atoiErr != nil || pid == supervisorPid {
+			continue
+		}
+		if executable, nameErr := RetrieveProcessName(pid); nameErr != nil || executable != conmonName {
+			continue
+		}
+		if !conmonMonitors(pid, cid) {
+			continue
+		}
+		// NOTE: The exec session may end while enumerating.
+		childPids, childErr := RetrieveChildPIDs(pid)
+		if childErr != nil {
+			continue
+		}
+		pids = append(pids, childPids...)
+	}
+	argFields.WithField("retrieved_pids", pids).Debug("the pids of exec sessions retrieved")
+	return
+}
+
+// conmonMonitors reports whether conmon of the pid monitors the container, which is given by -c or --cid option.
+func conmonMonitors(pid int, cid string) bool {
+	cmdline, err := ioutil.ReadFile(filepath.Join(procPath(), strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(strings.TrimSuffix(string(cmdline), "\x00"), "\x00")
+	for i, arg := range args {
+		if arg == "--cid="+cid {
+			return true
+		}
+		if (arg == "-c" || arg == "--cid") && i+1 < len(args) && args[i+1] == cid {
+			return true
+		}
+	}
+	return false
+}
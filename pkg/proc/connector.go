@@ -0,0 +1,112 @@
+package proc
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// The constants of the kernel proc connector (linux/connector.h and linux/cn_proc.h).
+const (
+	cnIdxProc uint32 = 0x1
+	cnValProc uint32 = 0x1
+
+	procCnMcastListen uint32 = 1
+
+	procEventFork uint32 = 0x00000001
+	procEventExec uint32 = 0x00000002
+	procEventComm uint32 = 0x00000200
+	procEventExit uint32 = 0x80000000
+
+	cnMsgSize         int = 20
+	procEventDataHead int = 16
+	taskCommLen       int = 16
+
+	connectorReadBufferSize int = 4 << 20
+)
+
+// procEvent is the event of process received from the proc connector.
+type procEvent struct {
+	what       uint32
+	pid, tgid  int
+	parentTgid int
+	comm       string
+}
+
+func dialProcConnector() (conn *netlink.Conn, err error) {
+	logrus.Debug("trying to dial proc connector")
+
+	conn, err = netlink.Dial(unix.NETLINK_CONNECTOR, cnIdxProc)
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to dial proc connector")
+		return
+	}
+	err = conn.SetReadBuffer(connectorReadBufferSize)
+	if err != nil {
+		logrus.WithField("warn", err).Debug("could not set read buffer of proc connector")
+	}
+
+	// Subscribe the process events.
+	data := make([]byte, cnMsgSize+4)
+	netlink.NativeEndian.PutUint32(data[0:4], cnIdxProc)
+	netlink.NativeEndian.PutUint32(data[4:8], cnValProc)
+	netlink.NativeEndian.PutUint16(data[16:18], 4)
+	netlink.NativeEndian.PutUint32(data[cnMsgSize:], procCnMcastListen)
+	message := netlink.Message{Data: data}
+	message.Header.Type = unix.NLMSG_DONE
+	_, err = conn.Send(message)
+	if err != nil {
+		conn.Close()
+		conn = nil
+		logrus.WithField("error", err).Debug("failed to dial proc connector")
+		return
+	}
+
+	logrus.Debug("proc connector dialed")
+	return
+}
+
+func parseProcEvent(message netlink.Message) (event procEvent, err error) {
+	if len(message.Data) < cnMsgSize+procEventDataHead+8 {
+		err = errors.New("proc connector message truncated")
+		return
+	}
+	if netlink.NativeEndian.Uint32(message.Data[0:4]) != cnIdxProc || netlink.NativeEndian.Uint32(message.Data[4:8]) != cnValProc {
+		err = errors.New("the message is not proc connector message")
+		return
+	}
+	data := message.Data[cnMsgSize:]
+	event.what = netlink.NativeEndian.Uint32(data[0:4])
+	data = data[procEventDataHead:]
+	switch event.what {
+	case procEventFork:
+		if len(data) < 16 {
+			err = errors.New("fork event truncated")
+			return
+		}
+		event.parentTgid = int(netlink.NativeEndian.Uint32(data[4:8]))
+		event.pid = int(netlink.NativeEndian.Uint32(data[8:12]))
+		event.tgid = int(netlink.NativeEndian.Uint32(data[12:16]))
+	case procEventComm:
+		if len(data) < 8+taskCommLen {
+			err = errors.New("comm event truncated")
+			return
+		}
+		event.pid = int(netlink.NativeEndian.Uint32(data[0:4]))
+		event.tgid = int(netlink.NativeEndian.Uint32(data[4:8]))
+		comm := data[8 : 8+taskCommLen]
+		for i, b := range comm {
+			if b == 0 {
+				comm = comm[:i]
+				break
+			}
+		}
+		event.comm = string(comm)
+	default:
+		event.pid = int(netlink.NativeEndian.Uint32(data[0:4]))
+		event.tgid = int(netlink.NativeEndian.Uint32(data[4:8]))
+	}
+	return
+}
@@ -0,0 +1,141 @@
+package proc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// IPProtocolDCCP is the protocol number of DCCP not defined in gopacket.
+	IPProtocolDCCP layers.IPProtocol = 33
+
+	dccpMinimumHeaderLength int = 12
+)
+
+var (
+	// LayerTypeDCCP is the layer type of DCCP not defined in gopacket.
+	LayerTypeDCCP gopacket.LayerType = gopacket.RegisterLayerType(1033, gopacket.LayerTypeMetadata{Name: "DCCP", Decoder: gopacket.DecodeFunc(decodeDCCP)})
+)
+
+func init() {
+	layers.IPProtocolMetadata[IPProtocolDCCP] = layers.EnumMetadata{DecodeWith: gopacket.DecodeFunc(decodeDCCP), Name: "DCCP", LayerType: LayerTypeDCCP}
+}
+
+// DCCP is the generic header of DCCP (RFC 4340) needed to check ports of socket.
+type DCCP struct {
+	layers.BaseLayer
+	SrcPort, DstPort uint16
+}
+
+// LayerType returns LayerTypeDCCP.
+func (d *DCCP) LayerType() gopacket.LayerType {
+	return LayerTypeDCCP
+}
+
+func decodeDCCP(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < dccpMinimumHeaderLength {
+		return errors.New("dccp header truncated")
+	}
+	// NOTE: Data Offset is the length of the header in 32-bit words.
+	headerLength := int(data[4]) * 4
+	if headerLength < dccpMinimumHeaderLength || headerLength > len(data) {
+		return errors.New("dccp data offset invalid")
+	}
+	dccp := &DCCP{
+		BaseLayer: layers.BaseLayer{Contents: data[:headerLength], Payload: data[headerLength:]},
+		SrcPort:   binary.BigEndian.Uint16(data[0:2]),
+		DstPort:   binary.BigEndian.Uint16(data[2:4]),
+	}
+	p.AddLayer(dccp)
+	return p.NextDecoder(gopacket.LayerTypePayload)
+}
+
+// The request and the reply of sock_diag for inet sockets, inet_diag_req_v2 and inet_diag_msg in linux/inet_diag.h.
+const (
+	sockDiagByFamily      uint16 = 20
+	inetDiagRequestLength int    = 56
+	inetDiagMessageLength int    = 72
+	inetDiagAllStates     uint32 = 0xFFFFFFFF
+	inetDiagSrcPortOffset int    = 4
+	inetDiagDstPortOffset int    = 6
+	inetDiagDstOffset     int    = 24
+	inetDiagInodeOffset   int    = 68
+)
+
+// SearchInodeFromDCCPOfPid returns inode from dccp sockets in the network namespace of a specific pid.
+// DCCP sockets are not listed in net of proc filesystem, so that they are dumped by sock_diag.
+func SearchInodeFromDCCPOfPid(targetSocket *Socket, pid int) (inode uint64, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"target_socket": targetSocket,
+		"pid":           pid,
+	})
+	argFields.Debug("trying to search inode from dccp of pid")
+
+	var conn *netlink.Conn
+	conn, err = netlink.DialNetworkNamespace(unix.NETLINK_SOCK_DIAG, filepath.Join(procPath(), strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to search inode from dccp of pid")
+		return
+	}
+	defer conn.Close()
+
+	// NOTE: The ipv4 socket may be a ipv4-mapped ipv6 socket, so that both families are dumped.
+	families := []uint8{unix.AF_INET6}
+	if targetSocket.RemoteIP.To4() != nil {
+		families = append([]uint8{unix.AF_INET}, families...)
+	}
+	var listeningInode uint64
+	for _, family := range families {
+		request := make([]byte, inetDiagRequestLength)
+		request[0] = family
+		request[1] = uint8(IPProtocolDCCP)
+		netlink.NativeEndian.PutUint32(request[4:8], inetDiagAllStates)
+		var replies []netlink.Message
+		replies, err = conn.Execute(netlink.Message{
+			Header: unix.NlMsghdr{Type: sockDiagByFamily, Flags: unix.NLM_F_DUMP},
+			Data:   request,
+		})
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to search inode from dccp of pid")
+			return
+		}
+		for _, reply := range replies {
+			if len(reply.Data) < inetDiagMessageLength || binary.BigEndian.Uint16(reply.Data[inetDiagSrcPortOffset:]) != targetSocket.LocalPort {
+				continue
+			}
+			dstPort := binary.BigEndian.Uint16(reply.Data[inetDiagDstPortOffset:])
+			socketInode := uint64(netlink.NativeEndian.Uint32(reply.Data[inetDiagInodeOffset:]))
+			if dstPort == 0 {
+				listeningInode = socketInode
+				continue
+			}
+			dstIP := net.IP(reply.Data[inetDiagDstOffset : inetDiagDstOffset+net.IPv6len])
+			if family == unix.AF_INET {
+				dstIP = dstIP[:net.IPv4len]
+			}
+			if dstPort == targetSocket.RemotePort && dstIP.Equal(targetSocket.RemoteIP) {
+				inode = socketInode
+				argFields.WithField("socket_inode", inode).Debug("inode found")
+				return
+			}
+		}
+	}
+	// NOTE: The request of the connection is received by the listening socket.
+	if listeningInode != 0 {
+		inode = listeningInode
+		argFields.WithField("socket_inode", inode).Debug("inode found")
+		return
+	}
+	err = errors.New("applicable dccp socket not found")
+	argFields.WithField("error", err).Debug("failed to search inode from dccp of pid")
+	return
+}
@@ -0,0 +1,198 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+)
+
+const (
+	historyGracePeriod time.Duration = 30 * time.Second
+	wildcardRemote     string        = "*"
+	// DefaultSnapshotInterval is the interval to record the sockets of the containers whose processes changed.
+	DefaultSnapshotInterval time.Duration = time.Second
+)
+
+// portNetFiles are the net files of the protocols with ports, whose entries are recorded by the snapshot, and their protocols.
+var portNetFiles map[string]gopacket.LayerType = map[string]gopacket.LayerType{
+	"tcp":      layers.LayerTypeTCP,
+	"tcp6":     layers.LayerTypeTCP,
+	"udp":      layers.LayerTypeUDP,
+	"udp6":     layers.LayerTypeUDP,
+	"udplite":  layers.LayerTypeUDPLite,
+	"udplite6": layers.LayerTypeUDPLite,
+}
+
+var (
+	// InodeHistory stores the Process that held the socket inode recently and the ID of its container.
+	InodeHistory *cache.Cache = cache.New(historyGracePeriod, 2*historyGracePeriod)
+	// EntryHistory stores the socket inode of the communication entry seen recently in net of proc filesystem.
+	EntryHistory *cache.Cache = cache.New(historyGracePeriod, 2*historyGracePeriod)
+)
+
+func entryHistoryKey(pid int, protocol, localPort, remote string) string {
+	return fmt.Sprintf("%d/%s/%s/%s", pid, protocol, localPort, remote)
+}
+
+// normalizeRemoteAddress converts rem_address of net entry into the format of IPtoa:port.
+func normalizeRemoteAddress(remote string) string {
+	separator := strings.LastIndex(remote, ":")
+	if separator < 0 {
+		return remote
+	}
+	address, port := remote[:separator], remote[separator+1:]
+	if port == "0000" {
+		return wildcardRemote
+	}
+	if len(address) == 32 && strings.HasPrefix(address, ipv4MappedPrefix()) {
+		address = address[24:]
+	}
+	return address + ":" + port
+}
+
+// ipv4MappedPrefix returns the first 96 bits of IPv4-mapped IPv6 address in the format of net entry.
+func ipv4MappedPrefix() string {
+	word := make([]byte, 4)
+	HostByteOrder.PutUint32(word, 0x0000FFFF)
+	return fmt.Sprintf("0000000000000000%X", word)
+}
+
+// recordSocketEntry records the socket inode of the net entry of the pid.
+func recordSocketEntry(pid int, targetSocket *Socket, entry [3]string) {
+	inode, err := strconv.ParseUint(entry[2], 10, 64)
+	if err != nil || inode == 0 {
+		return
+	}
+	localSeparator := strings.LastIndex(entry[0], ":")
+	if localSeparator < 0 {
+		return
+	}
+	key := entryHistoryKey(pid, targetSocket.Protocol.String(), entry[0][localSeparator+1:], normalizeRemoteAddress(entry[1]))
+	EntryHistory.SetDefault(key, inode)
+}
+
+// inodeOwner is the process holding the socket inode, and the container of the process.
+// The container is recorded because the containers of the host network share the entries of the network namespace.
+type inodeOwner struct {
+	process     *Process
+	containerID string
+}
+
+// recordSocketInodes records the process of the container holding the socket inodes.
+func recordSocketInodes(containerID string, process *Process, inodes []uint64) {
+	for _, inode := range inodes {
+		InodeHistory.SetDefault(strconv.FormatUint(inode, 10), &inodeOwner{process: process, containerID: containerID})
+	}
+}
+
+// SnapshotSocketsOfContainer records the socket inodes of the processes of the container and the entries of its network namespace,
+// so that the sockets of the processes exiting before their packets are identified are recalled from the history.
+func SnapshotSocketsOfContainer(targetContainer *container.Container) (err error) {
+	argFields := logrus.WithField("target_container", targetContainer)
+	argFields.Trace("trying to snapshot sockets of container")
+
+	var processes []*Process
+	processes, err = ContainerProcesses.ProcessesOfContainer(targetContainer, false)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to snapshot sockets of container")
+		return
+	}
+	for _, process := range processes {
+		if socketInodes, retrieveErr := RetrieveSocketInodes(process.ID); retrieveErr == nil {
+			recordSocketInodes(targetContainer.ID, process, socketInodes)
+		}
+	}
+	netDirPath := filepath.Join(procPath(), strconv.Itoa(targetContainer.Pid), "net")
+	for netFileName, protocol := range portNetFiles {
+		entries, readErr := ioutil.ReadFile(filepath.Join(netDirPath, netFileName))
+		if readErr != nil {
+			argFields.WithFields(logrus.Fields{"warn": readErr, "net_file_name": netFileName}).Trace("the net file skipped")
+			continue
+		}
+		targetSocket := &Socket{Protocol: protocol}
+		// NOTE: The first line is the header of the columns.
+		for _, line := range strings.Split(string(entries), "\n")[1:] {
+			columns := strings.Fields(line)
+			if len(columns) <= inodeColumn {
+				continue
+			}
+			recordSocketEntry(targetContainer.Pid, targetSocket, [3]string{columns[localAddressColumn], columns[remoteAddressColumn], columns[inodeColumn]})
+		}
+	}
+	argFields.Trace("sockets of container snapshotted")
+	return
+}
+
+// RecallProcessOfContainer returns Process that held the socket within the grace period.
+// It is used when the socket or the process have already gone from proc filesystem.
+func RecallProcessOfContainer(socket *Socket, communicatedContainer *container.Container) (process *Process, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"target_socket":          socket,
+		"communicated_container": communicatedContainer,
+	})
+	argFields.Debug("trying to recall process of container from history")
+
+	inode, searchErr := searchInodeOfSocket(socket, communicatedContainer.Pid)
+	if searchErr != nil {
+		localPort := fmt.Sprintf("%04X", socket.LocalPort)
+		remote := fmt.Sprintf("%s:%04X", IPtoa(socket.RemoteIP), socket.RemotePort)
+		found := false
+		for _, candidate := range []string{remote, wildcardRemote} {
+			var rawData interface{}
+			rawData, found = EntryHistory.Get(entryHistoryKey(communicatedContainer.Pid, socket.Protocol.String(), localPort, candidate))
+			if found {
+				inode = rawData.(uint64)
+				break
+			}
+		}
+		if !found {
+			// NOTE: The sockets of the container are recorded by the next snapshot, for the following packets of the short-lived sockets.
+			ContainerProcesses.requestSnapshot(communicatedContainer.ID)
+			err = errors.New("communication entry not found in history")
+			argFields.WithField("error", err).Debug("failed to recall process of container from history")
+			return
+		}
+	}
+	argFields.WithField("socket_inode", inode).Trace("inode found")
+
+	rawData, found := InodeHistory.Get(strconv.FormatUint(inode, 10))
+	if !found {
+		ContainerProcesses.requestSnapshot(communicatedContainer.ID)
+		err = errors.New("process holding the inode not found in history")
+		argFields.WithField("error", err).Debug("failed to recall process of container from history")
+		return
+	}
+	owner := rawData.(*inodeOwner)
+	if owner.containerID != communicatedContainer.ID {
+		err = errors.New("the inode held by the process of the other container")
+		argFields.WithField("error", err).Debug("failed to recall process of container from history")
+		return
+	}
+	copied := *owner.process
+	process = &copied
+	argFields.WithField("recalled_process", process).Debug("the process recalled from history")
+	return
+}
+
+// recallOwnerContainer returns the container whose process held the socket within the grace period among the containers sharing the network namespace.
+// ErrContainerNotFound is returned if none of them held it, such as the socket of the host.
+func recallOwnerContainer(socket *Socket, sharingContainers []*container.Container) (communicatedContainer *container.Container, err error) {
+	for _, sharingContainer := range sharingContainers {
+		if _, recallErr := RecallProcessOfContainer(socket, sharingContainer); recallErr == nil {
+			communicatedContainer = sharingContainer
+			return
+		}
+	}
+	err = ErrContainerNotFound
+	return
+}
@@ -0,0 +1,123 @@
+package proc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+)
+
+// RetrieveNetworkNamespace gets the network namespace of the process from ns of proc filesystem, such as "net:[4026531992]".
+func RetrieveNetworkNamespace(pid int) (networkNamespace string, err error) {
+	argFields := logrus.WithField("pid", pid)
+	argFields.Debug("trying to retrieve network namespace")
+
+	networkNamespace, err = os.Readlink(filepath.Join(procPath(), strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve network namespace")
+		return
+	}
+	argFields.WithField("network_namespace", networkNamespace).Debug("the network namespace retrieved")
+	return
+}
+
+// cachedNetworkNamespace is the network namespace of the process of the container, which is retrieved again after the container restarts.
+type cachedNetworkNamespace struct {
+	pid              int
+	networkNamespace string
+}
+
+// NetworkNamespaceOfContainer returns the network namespace of the container, which is shared by the containers started with --network container:<id>.
+func NetworkNamespaceOfContainer(targetContainer *container.Container) (networkNamespace string, err error) {
+	if cacheRawData, exist := NetworkNamespaceCache.Get(targetContainer.ID); exist {
+		if cached := cacheRawData.(cachedNetworkNamespace); cached.pid == targetContainer.Pid {
+			networkNamespace = cached.networkNamespace
+			return
+		}
+	}
+	networkNamespace, err = RetrieveNetworkNamespace(targetContainer.Pid)
+	if err != nil {
+		return
+	}
+	NetworkNamespaceCache.Set(targetContainer.ID, cachedNetworkNamespace{pid: targetContainer.Pid, networkNamespace: networkNamespace}, 0)
+	return
+}
+
+// The scope of the global address in if_inet6 of proc filesystem.
+const inet6ScopeGlobal string = "00"
+
+// RetrieveIPAddresses gets the IP addresses assigned in the network namespace of the process,
+// from fib_trie and if_inet6 of proc filesystem. The loopback and link-local addresses are excluded.
+func RetrieveIPAddresses(pid int) (ipAddresses []net.IP, err error) {
+	argFields := logrus.WithField("pid", pid)
+	argFields.Debug("trying to retrieve ip addresses")
+
+	netPath := filepath.Join(procPath(), strconv.Itoa(pid), "net")
+	var fibTrie *os.File
+	fibTrie, err = os.Open(filepath.Join(netPath, "fib_trie"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve ip addresses")
+		return
+	}
+	defer fibTrie.Close()
+	// NOTE: The address is followed by the line of its prefix, such as "/32 host LOCAL" for the local address.
+	found := map[string]struct{}{}
+	var lastAddress net.IP
+	scanner := bufio.NewScanner(fibTrie)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 2 && fields[0] == "|--":
+			lastAddress = net.ParseIP(fields[1])
+		case len(fields) == 3 && fields[0] == "/32" && fields[1] == "host" && fields[2] == "LOCAL":
+			if lastAddress == nil || lastAddress.IsLoopback() {
+				continue
+			}
+			if _, exist := found[lastAddress.String()]; !exist {
+				found[lastAddress.String()] = struct{}{}
+				ipAddresses = append(ipAddresses, lastAddress)
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve ip addresses")
+		return
+	}
+
+	// NOTE: if_inet6 does not exist if IPv6 is disabled.
+	var ifInet6 *os.File
+	ifInet6, err = os.Open(filepath.Join(netPath, "if_inet6"))
+	if os.IsNotExist(err) {
+		err = nil
+		argFields.WithField("ip_addresses", ipAddresses).Debug("the ip addresses retrieved")
+		return
+	} else if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve ip addresses")
+		return
+	}
+	defer ifInet6.Close()
+	scanner = bufio.NewScanner(ifInet6)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[3] != inet6ScopeGlobal {
+			continue
+		}
+		address, decodeErr := hex.DecodeString(fields[0])
+		if decodeErr != nil || len(address) != net.IPv6len {
+			continue
+		}
+		ipAddresses = append(ipAddresses, net.IP(address))
+	}
+	if err = scanner.Err(); err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve ip addresses")
+		return
+	}
+	argFields.WithField("ip_addresses", ipAddresses).Debug("the ip addresses retrieved")
+	return
+}
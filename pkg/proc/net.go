@@ -36,8 +36,12 @@ func IPtoa(ip net.IP) (ipStr string) {
 
 	ipv4 := ip.To4()
 	if ipv4 == nil {
-		ipBytes := ([]byte)(ip)
-		var ipStrs []string = make([]string, 4)
+		ipBytes := ip.To16()
+		if ipBytes == nil {
+			argFields.Debug("the ip address is invalid")
+			return
+		}
+		var ipStrs []string = make([]string, 0, 4)
 		for i := 0; i < 16 ; i += 4 {
 			tmpInt := big.NewInt(0)
 			tmpInt.SetBytes(ipBytes[i:i+4])
@@ -52,8 +56,9 @@ func IPtoa(ip net.IP) (ipStr string) {
 			}
 			ipStrs = append(ipStrs, fmt.Sprintf("%X", buf.Bytes()))
 		}
+		ipStr = strings.Join(ipStrs, "")
 		argFields.WithField("ip_address_string", ipStr).Debug("the ip address converted")
-		return strings.Join(ipStrs, "")
+		return
 	}
 
 	ipv4Int := big.NewInt(0)
@@ -71,3 +76,19 @@ func IPtoa(ip net.IP) (ipStr string) {
 	argFields.WithField("ip_address_string", ipStr).Debug("the ip address converted")
 	return
 }
+
+// isLocalAddress reports whether the ip address is assigned to the interfaces of the host.
+// NOTE: cnet runs in the network namespace of the host, which the containers of the host network share.
+func isLocalAddress(ip net.IP) bool {
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to retrieve the addresses of the interfaces")
+		return false
+	}
+	for _, interfaceAddr := range interfaceAddrs {
+		if ipNet, ok := interfaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
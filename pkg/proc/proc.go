@@ -1,8 +1,56 @@
 package proc
 
+import (
+	"path/filepath"
+	"sync"
+)
+
 const (
-	procPath            string = "/proc"
 	localAddressColumn  int = 1
 	remoteAddressColumn int = 2
+	stateColumn         int = 3
 	inodeColumn         int = 9
 )
+
+// The states of the sockets receiving the packets of the new connections, which are TCP_LISTEN and TCP_CLOSE in the net files.
+const (
+	tcpListenState      string = "0A"
+	udpUnconnectedState string = "07"
+)
+
+// roots are the directories where proc filesystem and cgroup filesystem are read from.
+type roots struct {
+	root   string
+	proc   string
+	cgroup string
+}
+
+var (
+	currentRoots roots = roots{root: "/", proc: "/proc", cgroup: "/sys/fs/cgroup"}
+	rootsMutex   sync.RWMutex
+)
+
+// SetRoot changes the root directory where proc filesystem and cgroup filesystem are read from, and returns the previous root.
+// The synthetic trees of proc filesystem can be used by changing the root, and the previous root is restored by SetRoot again,
+// such as by t.Cleanup of the test. The tests changing the root must not run in parallel with the tests reading the root.
+func SetRoot(root string) (previous string) {
+	rootsMutex.Lock()
+	defer rootsMutex.Unlock()
+	previous = currentRoots.root
+	currentRoots = roots{root: root, proc: filepath.Join(root, "proc"), cgroup: filepath.Join(root, "sys", "fs", "cgroup")}
+	return
+}
+
+// procPath returns the directory of proc filesystem.
+func procPath() string {
+	rootsMutex.RLock()
+	defer rootsMutex.RUnlock()
+	return currentRoots.proc
+}
+
+// cgroupPath returns the directory where cgroup filesystem is mounted.
+func cgroupPath() string {
+	rootsMutex.RLock()
+	defer rootsMutex.RUnlock()
+	return currentRoots.cgroup
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -16,13 +17,13 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/sirupsen/logrus"
 	"github.com/tomo-9925/cnet/pkg/container"
-	"github.com/tomo-9925/cnet/pkg/docker"
 )
 
 // Process is information about process needed to analyze communications of container.
 type Process struct {
 	ID               int
 	Executable, Path string
+	ParentID         int
 }
 
 func (p *Process)String() string {
@@ -38,38 +39,56 @@ func (p *Process)Equal(x *Process) bool {
 }
 
 // IdentifyProcessOfContainer returns Process of container from Socket and Container and Packet.
+// If the socket is shared by multiple processes, the representative of the owners is returned.
 func IdentifyProcessOfContainer(socket *Socket, container *container.Container, packet *gopacket.Packet) (process *Process, err error) {
+	var processes []*Process
+	processes, err = IdentifyProcessesOfContainer(socket, container, packet)
+	if err != nil {
+		return
+	}
+	process = processes[0]
+	return
+}
+
+// IdentifyProcessesOfContainer returns all Process of container owning the socket from Socket and Container and Packet.
+// The representative of the owners is placed first, and the others are sorted by the process id.
+func IdentifyProcessesOfContainer(socket *Socket, container *container.Container, packet *gopacket.Packet) (processes []*Process, err error) {
 	argFields := logrus.WithFields(logrus.Fields{
 		"target_socket": socket,
 		"communicated_container": container,
 	})
-	argFields.Debug("trying to identify process of container")
+	argFields.Debug("trying to identify processes of container")
 
 	if cacheRawData, exist := SocketCache.Get(socket.Hash()); exist {
-		var ok bool
-		process, ok = cacheRawData.(*Process)
-		if ok {
-			argFields.WithField("identified_process", process).Debug("the process identified")
+		if cachedProcesses, ok := cacheRawData.([]*Process); ok {
+			processes = make([]*Process, len(cachedProcesses))
+			for i, process := range cachedProcesses {
+				// NOTE: The attributes of the process may be changed by exec.
+				if trackedProcess, _, tracked := ContainerProcesses.Lookup(process.ID); tracked {
+					process = trackedProcess
+				}
+				processes[i] = process
+			}
+			argFields.WithField("identified_processes", processes).Debug("the processes identified")
 			return
 		}
-		process = nil
 	}
 
 	switch socket.Protocol {
-	case layers.LayerTypeTCP, layers.LayerTypeUDP:
+	case layers.LayerTypeTCP, layers.LayerTypeUDP, layers.LayerTypeUDPLite, layers.LayerTypeSCTP, LayerTypeDCCP:
 		var inode uint64
-		inode, err = SearchInodeFromNetOfPid(socket, container.Pid)
+		inode, err = searchInodeOfSocket(socket, container.Pid)
 		if err != nil {
 			argFields.WithField("error", err).Debug("failed to indentify process of container")
 			return
 		}
-		process, err = SearchProcessOfContainerFromInode(container, socket, inode)
+		processes, err = SearchProcessesOfContainerFromInode(container, socket, inode)
 		if err != nil {
 			argFields.WithField("warn", err).Debug("could not identify the process by tcp or udp")
 			break
 		}
-		argFields.WithField("identified_process", process).Debug("the process identified")
-		SocketCache.Set(socket.Hash(), process, 0)
+		argFields.WithField("identified_processes", processes).Debug("the processes identified")
+		SocketCache.Set(socket.Hash(), processes, 0)
 		return
 	}
 
@@ -82,18 +101,21 @@ func IdentifyProcessOfContainer(socket *Socket, container *container.Container,
 	}
 	suspiciousProcesses := map[Process]struct{}{}
 	for _, inode := range inodes {
-		var suspiciousProcess *Process
-		suspiciousProcess, err = SearchProcessOfContainerFromInode(container, socket, inode)
+		var owners []*Process
+		owners, err = SearchProcessesOfContainerFromInode(container, socket, inode)
 		if err != nil {
 			argFields.WithField("error", err).Trace("process not found")
 			continue
 		}
-		suspiciousProcesses[*suspiciousProcess] = struct{}{}
+		for _, owner := range owners {
+			suspiciousProcesses[*owner] = struct{}{}
+		}
 	}
 	if len(suspiciousProcesses) == 1 {
 		for suspiciousProcess := range suspiciousProcesses {
-			process = &suspiciousProcess
-			argFields.WithField("identified_process", process).Debug("the process identified")
+			process := suspiciousProcess
+			processes = []*Process{&process}
+			argFields.WithField("identified_processes", processes).Debug("the processes identified")
 			return
 		}
 	}
@@ -103,8 +125,9 @@ func IdentifyProcessOfContainer(socket *Socket, container *container.Container,
 		identifierStr := strconv.FormatUint(uint64(identifier), 10)
 		for suspiciousProcess := range suspiciousProcesses {
 			if NSpidExists(suspiciousProcess.ID, identifierStr) {
-				process = &suspiciousProcess
-				argFields.WithField("identified_process", process).Debug("the process identified")
+				process := suspiciousProcess
+				processes = []*Process{&process}
+				argFields.WithField("identified_processes", processes).Debug("the processes identified")
 				return
 			}
 		}
@@ -112,7 +135,8 @@ func IdentifyProcessOfContainer(socket *Socket, container *container.Container,
 
 	result := make([]*Process, 0, len(suspiciousProcesses))
 	for suspiciousProcess := range suspiciousProcesses {
-		result = append(result, &suspiciousProcess)
+		process := suspiciousProcess
+		result = append(result, &process)
 	}
 	argFields.WithField("suspicious_processes", result).Warn("multiple processes detected")
 
@@ -121,6 +145,17 @@ func IdentifyProcessOfContainer(socket *Socket, container *container.Container,
 	return
 }
 
+// searchInodeOfSocket returns inode of the socket having ports from net of a specific pid in proc filesystem.
+func searchInodeOfSocket(targetSocket *Socket, pid int) (uint64, error) {
+	switch targetSocket.Protocol {
+	case layers.LayerTypeSCTP:
+		return SearchInodeFromSCTPOfPid(targetSocket, pid)
+	case LayerTypeDCCP:
+		return SearchInodeFromDCCPOfPid(targetSocket, pid)
+	}
+	return SearchInodeFromNetOfPid(targetSocket, pid)
+}
+
 // MakeRetrieveSocketEntryFunction return the function that retrieve socket entry of specific process id and protocol.
 func MakeRetrieveSocketEntryFunction(targetSocket *Socket, pid int) (retrieveFunction func() ([3]string, bool), err error) {
 	argFields := logrus.WithFields(logrus.Fields{
@@ -130,33 +165,45 @@ func MakeRetrieveSocketEntryFunction(targetSocket *Socket, pid int) (retrieveFun
 	argFields.Debug("trying to make the function that retrieve socket entry")
 
 	// dealing with ipv4-mapped ipv6 address
-	var netFilePath string
+	var netFileNames, optionalNetFileNames []string
 	switch targetSocket.Protocol {
 	case layers.LayerTypeTCP:
-		netFilePath = filepath.Join(procPath, strconv.Itoa(pid), "net", "tcp6")
+		netFileNames = []string{"tcp6"}
 	case layers.LayerTypeUDP:
-		netFilePath = filepath.Join(procPath, strconv.Itoa(pid), "net", "udp6")
+		netFileNames = []string{"udp6"}
+	case layers.LayerTypeUDPLite:
+		netFileNames = []string{"udplite6"}
+	case layers.LayerTypeICMPv4, layers.LayerTypeICMPv6:
+		// NOTE: ping sockets (SOCK_DGRAM of ICMP) are listed in icmp and icmp6, which may not exist depending on the kernel.
+		netFileNames = []string{"raw6"}
+		optionalNetFileNames = []string{"icmp6"}
 	default:
-		netFilePath = filepath.Join(procPath, strconv.Itoa(pid), "net", "raw6")
+		netFileNames = []string{"raw6"}
+	}
+	if targetSocket.LocalIP.To4() != nil {
+		for _, fileNames := range []*[]string{&netFileNames, &optionalNetFileNames} {
+			for _, fileName := range *fileNames {
+				*fileNames = append(*fileNames, strings.TrimSuffix(fileName, "6"))
+			}
+		}
 	}
+	netFilePath := filepath.Join(procPath(), strconv.Itoa(pid), "net")
 
-	// dealing with ipv4-mapped ipv6 address
 	var communicationEntries []byte
-	communicationEntries, err = ioutil.ReadFile(netFilePath)
-	if err != nil {
-		argFields.WithField("error", err).Debug("failed to search inode from net of pid")
-		return
-	}
-	communicationEntries = communicationEntries[bytes.Index(communicationEntries, []byte("\n"))+1:]
-	if targetSocket.LocalIP.To4() != nil {
-		var v4Entries []byte
-		v4Entries, err = ioutil.ReadFile(netFilePath[:len(netFilePath)-1])
+	for i, netFileName := range append(netFileNames, optionalNetFileNames...) {
+		var entries []byte
+		entries, err = ioutil.ReadFile(filepath.Join(netFilePath, netFileName))
 		if err != nil {
+			if i >= len(netFileNames) {
+				argFields.WithFields(logrus.Fields{"warn": err, "net_file_name": netFileName}).Trace("the optional net file skipped")
+				err = nil
+				continue
+			}
 			argFields.WithField("error", err).Debug("failed to search inode from net of pid")
 			return
 		}
-		v4Entries = v4Entries[bytes.Index(v4Entries, []byte("\n"))+1:]
-		communicationEntries = bytes.Join([][]byte{communicationEntries, v4Entries}, []byte{})
+		entries = entries[bytes.Index(entries, []byte("\n"))+1:]
+		communicationEntries = append(communicationEntries, entries...)
 	}
 
 	entryScanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&communicationEntries))))
@@ -222,18 +269,24 @@ func SearchInodeFromNetOfPid(targetSocket *Socket, pid int) (inode uint64, err e
 	if err != nil {
 		return
 	}
+	found := false
 	for entry, exist := retrieveSocketEntry(); exist; entry, exist = retrieveSocketEntry() {
-		if !strings.HasSuffix(entry[0], socketLocalPort) {
+		// NOTE: All entries are recorded for the sockets closed before being identified.
+		recordSocketEntry(pid, targetSocket, entry)
+		if found || !strings.HasSuffix(entry[0], socketLocalPort) {
 			argFields.WithField("net_local_port", entry[0]).Trace("the entry skipped")
 			continue
 		}
 		// server process makes 00000000:0000 rem_address entry. remote address is a possible IPv4-mapped IPv6 address.
 		if strings.HasSuffix(entry[1], "0000") || strings.HasSuffix(entry[1], socketRemoteAddr) {
 			inode, err = strconv.ParseUint(entry[2], 10, 64)
+			found = err == nil
 			argFields.WithField("socket_inode", inode).Debug("inode found")
-			return
 		}
 	}
+	if found {
+		return
+	}
 	err = errors.New("applicable communication entry not found")
 	argFields.WithField("error", err).Debug("failed to search inode from net of pid")
 	return
@@ -260,60 +313,96 @@ func RetrieveAllInodeFromRawOfPid(pid int, targetSocket *Socket) (allInode []uin
 }
 
 // SearchProcessOfContainerFromInode return Process struct of the process that have specific socket inode.
+// If the socket inode is shared by multiple processes, the representative of the owners is returned.
 func SearchProcessOfContainerFromInode(communicatedContainer *container.Container, targetSocket *Socket, inode uint64) (process *Process, err error) {
+	var processes []*Process
+	processes, err = SearchProcessesOfContainerFromInode(communicatedContainer, targetSocket, inode)
+	if err != nil {
+		return
+	}
+	process = processes[0]
+	return
+}
+
+// SearchProcessesOfContainerFromInode return Process structs of all processes that have specific socket inode.
+// The socket inode is shared by the processes after fork, such as the master and workers of nginx.
+// The representative, which is the owner whose parent does not own the socket inode, is placed first, and the others are sorted by the process id.
+func SearchProcessesOfContainerFromInode(communicatedContainer *container.Container, targetSocket *Socket, inode uint64) (processes []*Process, err error) {
 	argFields := logrus.WithFields(logrus.Fields{
 		"communicated_container": communicatedContainer,
 		"inode": inode,
 	})
-	argFields.Debug("trying to search process of container from inode")
+	argFields.Debug("trying to search processes of container from inode")
 
-	if targetSocket.Protocol == layers.LayerTypeUDP && targetSocket.RemotePort == 53 && SocketInodeExists(docker.PID, inode) {
-		process, err = MakeProcessStruct(docker.PID)
+	if targetSocket.Protocol == layers.LayerTypeUDP && targetSocket.RemotePort == 53 && SocketInodeExists(container.DNSHelperPID(), inode) {
+		var process *Process
+		process, err = MakeProcessStruct(container.DNSHelperPID())
 		if err != nil {
-			argFields.WithField("error", err).Debug("failed to search process of container from inode")
+			argFields.WithField("error", err).Debug("failed to search processes of container from inode")
 			return
 		}
-		argFields.WithField("process", process).Debug("process exists")
+		processes = []*Process{process}
+		argFields.WithField("processes", processes).Debug("processes exist")
 		return
 	}
 
-	var containerdShimPid int
-	containerdShimPid, err = RetrievePPID(communicatedContainer.Pid)
-	if err != nil {
-		argFields.WithField("error", err).Debug("failed to search process of container from inode")
-		return
-	}
-	argFields.WithField("pid_of_containerd_shim", containerdShimPid).Trace("pid of containered-shim retrieved")
-	var childPIDs []int
-	childPIDs, err = RetrieveChildPIDs(containerdShimPid)
-	if err != nil {
-		argFields.WithField("error", err).Debug("failed to search process of container from inode")
-		return
-	}
-
-	// Check inode of pids
-	for _, pid := range childPIDs {
-		if SocketInodeExists(pid, inode) {
-			process, err = MakeProcessStruct(pid)
-			if err != nil {
-				argFields.WithField("error", err).Debug("failed to search process of container from inode")
-				return
+	// Check inode of the known processes, and enumerate the processes again if not found
+	for _, refresh := range []bool{false, true} {
+		var containerProcesses []*Process
+		containerProcesses, err = ContainerProcesses.ProcessesOfContainer(communicatedContainer, refresh)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to search processes of container from inode")
+			return
+		}
+		for _, containerProcess := range containerProcesses {
+			socketInodes, retrieveErr := RetrieveSocketInodes(containerProcess.ID)
+			if retrieveErr != nil {
+				continue
+			}
+			// NOTE: All inodes are recorded for the processes exiting before the sockets are identified.
+			recordSocketInodes(communicatedContainer.ID, containerProcess, socketInodes)
+			for _, socketInode := range socketInodes {
+				if socketInode == inode {
+					processes = append(processes, containerProcess)
+					break
+				}
 			}
-			argFields.WithField("process", process).Debug("process exists")
+		}
+		if len(processes) != 0 {
+			sortOwners(processes)
+			argFields.WithField("processes", processes).Debug("processes exist")
 			return
 		}
 	}
 	err = errors.New("process not found")
-	argFields.WithField("error", err).Debug("failed to search process of container from inode")
+	argFields.WithField("error", err).Debug("failed to search processes of container from inode")
 	return
 }
 
+// sortOwners sorts the owners of the socket inode by the process id, and moves the representative to the first.
+func sortOwners(owners []*Process) {
+	sort.Slice(owners, func(i, j int) bool { return owners[i].ID < owners[j].ID })
+	ownerIDs := make(map[int]struct{}, len(owners))
+	for _, owner := range owners {
+		ownerIDs[owner.ID] = struct{}{}
+	}
+	for i, owner := range owners {
+		if _, inherited := ownerIDs[owner.ParentID]; inherited {
+			continue
+		}
+		copy(owners[1:i+1], owners[:i])
+		owners[0] = owner
+		return
+	}
+}
+
 // MakeProcessStruct return Process struct of specified pid.
 func MakeProcessStruct(pid int) (process *Process, err error) {
 	argFields := logrus.WithField("pid", pid)
 	argFields.Debug("trying to make process struct")
 
 	var executable, path string
+	var ppid int
 	executable, err = RetrieveProcessName(pid)
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to make process struct")
@@ -326,31 +415,48 @@ func MakeProcessStruct(pid int) (process *Process, err error) {
 		return
 	}
 	argFields.WithField("retrieved_path", path).Trace("path retrieved")
+	ppid, err = RetrievePPID(pid)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to make process struct")
+		return
+	}
+	argFields.WithField("retrieved_ppid", ppid).Trace("ppid retrieved")
 
-	process = &Process{pid, executable, path}
+	process = &Process{pid, executable, path, ppid}
 	argFields.WithField("process", process).Debug("the process struct made")
 	return
 }
 
 // RetrievePPID gets the PPID from stat of proc filesystem.
+// The fields are parsed after the last parenthesis, because the process name in parentheses may have the spaces and the parentheses.
 func RetrievePPID(pid int) (ppid int, err error) {
 	argFields := logrus.WithField("pid", pid)
 	argFields.Debug("trying to retrieve ppid")
 
 	var file []byte
-	file, err = ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	file, err = ioutil.ReadFile(filepath.Join(procPath(), strconv.Itoa(pid), "stat"))
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to retrieve ppid")
 		return
 	}
 
-	scanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&file))))
-	scanner.Split(bufio.ScanWords)
-	for i := 0; scanner.Scan(); i++ {
-		if i == 3 {
-			ppid, err = strconv.Atoi(scanner.Text())
-			break
-		}
+	// NOTE: The fields after the process name are the state and the ppid.
+	nameEnd := bytes.LastIndexByte(file, ')')
+	if nameEnd < 0 {
+		err = errors.New("the process name not found in stat")
+		argFields.WithField("error", err).Debug("failed to retrieve ppid")
+		return
+	}
+	fields := strings.Fields(string(file[nameEnd+1:]))
+	if len(fields) < 2 {
+		err = errors.New("the ppid not found in stat")
+		argFields.WithField("error", err).Debug("failed to retrieve ppid")
+		return
+	}
+	ppid, err = strconv.Atoi(fields[1])
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve ppid")
+		return
 	}
 	argFields.WithField("retrieved_ppid", ppid).Debug("the ppid retrieved")
 	return
@@ -394,7 +500,7 @@ func retrieveChildren(pid int) (result []int, err error) {
 
 	pidStr := strconv.Itoa(pid)
 	var file []byte
-	file, err = ioutil.ReadFile(filepath.Join(procPath, pidStr, "task", pidStr, "children"))
+	file, err = ioutil.ReadFile(filepath.Join(procPath(), pidStr, "task", pidStr, "children"))
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to retrieve the children")
 		return
@@ -460,19 +566,13 @@ func SocketInodeExists(pid int, inode uint64) (exist bool) {
 	})
 	argFields.Debug("trying to check whether the process has socket inode")
 
-	fdDirPath := filepath.Join(procPath, strconv.Itoa(pid), "fd")
-	fdFiles, err := ioutil.ReadDir(fdDirPath)
+	socketInodes, err := RetrieveSocketInodes(pid)
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to check whether the process has socket inode")
 		return
 	}
-	for _, fdFile := range fdFiles {
-		linkContent, err := os.Readlink(filepath.Join(fdDirPath, fdFile.Name()))
-		if err != nil {
-			argFields.WithField("error", err).Debug("failed to check whether the process has socket inode")
-			return
-		}
-		if strings.HasPrefix(linkContent, "socket") && linkContent[8:len(linkContent)-1] == strconv.FormatUint(inode, 10) {
+	for _, socketInode := range socketInodes {
+		if socketInode == inode {
 			exist = true
 			argFields.Debug("socket inode exists")
 			return
@@ -482,6 +582,40 @@ func SocketInodeExists(pid int, inode uint64) (exist bool) {
 	return
 }
 
+// RetrieveSocketInodes gets all socket inodes from file descriptors of the process.
+func RetrieveSocketInodes(pid int) (socketInodes []uint64, err error) {
+	argFields := logrus.WithField("pid", pid)
+	argFields.Debug("trying to retrieve socket inodes")
+
+	fdDirPath := filepath.Join(procPath(), strconv.Itoa(pid), "fd")
+	var fdFiles []os.FileInfo
+	fdFiles, err = ioutil.ReadDir(fdDirPath)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to retrieve socket inodes")
+		return
+	}
+	for _, fdFile := range fdFiles {
+		linkContent, readlinkErr := os.Readlink(filepath.Join(fdDirPath, fdFile.Name()))
+		if readlinkErr != nil {
+			// NOTE: The file descriptor may be closed while retrieving.
+			argFields.WithField("warn", readlinkErr).Trace("the file descriptor skipped")
+			continue
+		}
+		if !strings.HasPrefix(linkContent, "socket:[") {
+			continue
+		}
+		var socketInode uint64
+		socketInode, err = strconv.ParseUint(linkContent[8:len(linkContent)-1], 10, 64)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to retrieve socket inodes")
+			return
+		}
+		socketInodes = append(socketInodes, socketInode)
+	}
+	argFields.WithField("socket_inodes", socketInodes).Debug("the socket inodes retrieved")
+	return
+}
+
 // NSpidExists reports whether the process has namespace process id.
 func NSpidExists(pid int, nspidStr string) (result bool) {
 	argFields := logrus.WithFields(logrus.Fields{
@@ -491,7 +625,7 @@ func NSpidExists(pid int, nspidStr string) (result bool) {
 	argFields.Debug("trying to check whether the process has namespace process id")
 
 	var file []byte
-	file, err := ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "status"))
+	file, err := ioutil.ReadFile(filepath.Join(procPath(), strconv.Itoa(pid), "status"))
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to check whether the process has nspid")
 		return
@@ -515,7 +649,7 @@ func RetrieveProcessName(pid int) (executable string, err error) {
 	argFields.Debug("trying to retrieve process name")
 
 	var commFile []byte
-	commFile, err = ioutil.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "comm"))
+	commFile, err = ioutil.ReadFile(filepath.Join(procPath(), strconv.Itoa(pid), "comm"))
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to retrieve process name")
 		return
@@ -529,7 +663,7 @@ func RetrieveProcessName(pid int) (executable string, err error) {
 func RetrieveProcessPath(pid int) (path string, err error) {
 	argFields := logrus.WithField("pid", pid)
 	argFields.Debug("trying to retrieve process path")
-	path, err = os.Readlink(filepath.Join(procPath, strconv.Itoa(pid), "exe"))
+	path, err = os.Readlink(filepath.Join(procPath(), strconv.Itoa(pid), "exe"))
 	if err != nil {
 		argFields.WithField("error", err).Debug("failed to retrieve process path")
 		return
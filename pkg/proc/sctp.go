@@ -0,0 +1,84 @@
+package proc
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The columns of sctp/assocs and sctp/eps in proc filesystem.
+const (
+	sctpAssocInodeColumn        int = 10
+	sctpAssocLocalPortColumn    int = 11
+	sctpAssocRemotePortColumn   int = 12
+	sctpAssocAddressesColumn    int = 13
+	sctpEndpointLocalPortColumn int = 5
+	sctpEndpointInodeColumn     int = 7
+)
+
+// SearchInodeFromSCTPOfPid returns inode from sctp associations and endpoints of a specific pid in proc filesystem.
+func SearchInodeFromSCTPOfPid(targetSocket *Socket, pid int) (inode uint64, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"target_socket": targetSocket,
+		"pid":           pid,
+	})
+	argFields.Debug("trying to search inode from sctp of pid")
+
+	sctpPath := filepath.Join(procPath(), strconv.Itoa(pid), "net", "sctp")
+	localPort, remotePort := strconv.Itoa(int(targetSocket.LocalPort)), strconv.Itoa(int(targetSocket.RemotePort))
+
+	// The association is searched first, because the endpoint of one-to-many style socket has the same inode.
+	var assocs []byte
+	assocs, err = ioutil.ReadFile(filepath.Join(sctpPath, "assocs"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to search inode from sctp of pid")
+		return
+	}
+	entryScanner := bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&assocs))))
+	for entryScanner.Scan() {
+		columns := strings.Fields(entryScanner.Text())
+		if len(columns) <= sctpAssocAddressesColumn || columns[sctpAssocLocalPortColumn] != localPort || columns[sctpAssocRemotePortColumn] != remotePort {
+			continue
+		}
+		// NOTE: The addresses are listed as "LADDRS <-> RADDRS", and the primary address has an asterisk prefix.
+		remoteAddresses := false
+		for _, address := range columns[sctpAssocAddressesColumn:] {
+			if address == "<->" {
+				remoteAddresses = true
+				continue
+			}
+			if remoteAddresses && targetSocket.RemoteIP.Equal(net.ParseIP(strings.TrimPrefix(address, "*"))) {
+				inode, err = strconv.ParseUint(columns[sctpAssocInodeColumn], 10, 64)
+				argFields.WithField("socket_inode", inode).Debug("inode found")
+				return
+			}
+		}
+	}
+
+	var eps []byte
+	eps, err = ioutil.ReadFile(filepath.Join(sctpPath, "eps"))
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to search inode from sctp of pid")
+		return
+	}
+	entryScanner = bufio.NewScanner(strings.NewReader(*(*string)(unsafe.Pointer(&eps))))
+	for entryScanner.Scan() {
+		columns := strings.Fields(entryScanner.Text())
+		if len(columns) <= sctpEndpointInodeColumn || columns[sctpEndpointLocalPortColumn] != localPort {
+			continue
+		}
+		inode, err = strconv.ParseUint(columns[sctpEndpointInodeColumn], 10, 64)
+		argFields.WithField("socket_inode", inode).Debug("inode found")
+		return
+	}
+	err = errors.New("applicable sctp entry not found")
+	argFields.WithField("error", err).Debug("failed to search inode from sctp of pid")
+	return
+}
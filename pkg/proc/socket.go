@@ -1,15 +1,20 @@
 package proc
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/sirupsen/logrus"
 	"github.com/tomo-9925/cnet/pkg/container"
-	"github.com/tomo-9925/cnet/pkg/docker"
 )
 
 // Socket is information needed to control network.
@@ -17,6 +22,8 @@ type Socket struct {
 	Protocol              gopacket.LayerType
 	LocalIP, RemoteIP     net.IP
 	LocalPort, RemotePort uint16
+	// Inbound reports whether the packet was sent to the container.
+	Inbound bool
 }
 
 func (s *Socket)String() string {
@@ -28,6 +35,86 @@ func (s *Socket)Hash() string {
 	return fmt.Sprintf("%X%X%X%X%X", s.Protocol, s.LocalIP, s.RemoteIP, s.LocalPort, s.RemotePort)
 }
 
+// ListeningPort is the port which the socket of the container receives the packets of the new connections on.
+type ListeningPort struct {
+	Protocol gopacket.LayerType
+	Port     uint16
+}
+
+// ListeningPortsOfContainer returns the ports of the listening sockets of TCP and the unconnected sockets of UDP and UDP-Lite
+// held by the processes of the container.
+func ListeningPortsOfContainer(targetContainer *container.Container) (ports []ListeningPort, err error) {
+	argFields := logrus.WithField("target_container", targetContainer)
+	argFields.Debug("trying to list the listening ports of container")
+
+	var processes []*Process
+	processes, err = ContainerProcesses.ProcessesOfContainer(targetContainer, false)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to list the listening ports of container")
+		return
+	}
+	heldInodes := map[string]struct{}{}
+	for _, process := range processes {
+		socketInodes, retrieveErr := RetrieveSocketInodes(process.ID)
+		if retrieveErr != nil {
+			continue
+		}
+		for _, socketInode := range socketInodes {
+			heldInodes[strconv.FormatUint(socketInode, 10)] = struct{}{}
+		}
+	}
+	found := map[ListeningPort]struct{}{}
+	netDirPath := filepath.Join(procPath(), strconv.Itoa(targetContainer.Pid), "net")
+	for netFileName, protocol := range portNetFiles {
+		entries, readErr := ioutil.ReadFile(filepath.Join(netDirPath, netFileName))
+		if readErr != nil {
+			argFields.WithFields(logrus.Fields{"warn": readErr, "net_file_name": netFileName}).Trace("the net file skipped")
+			continue
+		}
+		waitingState := udpUnconnectedState
+		if protocol == layers.LayerTypeTCP {
+			waitingState = tcpListenState
+		}
+		// NOTE: The first line is the header of the columns.
+		for _, line := range strings.Split(string(entries), "\n")[1:] {
+			columns := strings.Fields(line)
+			if len(columns) <= inodeColumn || columns[stateColumn] != waitingState {
+				continue
+			}
+			if _, held := heldInodes[columns[inodeColumn]]; !held {
+				continue
+			}
+			localSeparator := strings.LastIndex(columns[localAddressColumn], ":")
+			if localSeparator < 0 {
+				continue
+			}
+			port, parseErr := strconv.ParseUint(columns[localAddressColumn][localSeparator+1:], 16, 16)
+			if parseErr != nil || port == 0 {
+				continue
+			}
+			found[ListeningPort{Protocol: protocol, Port: uint16(port)}] = struct{}{}
+		}
+	}
+	for port := range found {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(a, b int) bool {
+		if ports[a].Protocol != ports[b].Protocol {
+			return ports[a].Protocol < ports[b].Protocol
+		}
+		return ports[a].Port < ports[b].Port
+	})
+	argFields.WithField("ports", ports).Debug("the listening ports of container listed")
+	return
+}
+
+// ErrContainerNotFound is returned if the packet is not sent or received by any container, such as the packet of the host.
+var ErrContainerNotFound error = errors.New("communicated container not found")
+
+// ErrOwnerNotFound is returned with the endpoints of the candidates if the packet of the host network is attributed to more than one container,
+// such as the packet of the raw sockets held by the processes of several containers.
+var ErrOwnerNotFound error = errors.New("the owner of the socket in the host network ambiguous")
+
 type direction bool
 
 const (
@@ -39,88 +126,415 @@ type packetIPAddr struct {
 	src, dst net.IP
 }
 
-// CheckSocketAndCommunicatedDockerContainer returns socket and communicated docker container from packet and containers.
-func CheckSocketAndCommunicatedDockerContainer(packet *gopacket.Packet, containers *docker.Containers) (socket *Socket, communicatedContainer *container.Container, err error) {
+// originalPacket is the header of the original packet embedded in ICMP error message.
+type originalPacket struct {
+	protocol         gopacket.LayerType
+	ip               packetIPAddr
+	srcPort, dstPort uint16
+}
+
+// Endpoint is the socket and the container at either end of the packet.
+type Endpoint struct {
+	Socket    *Socket
+	Container *container.Container
+}
+
+func (e *Endpoint)String() string {
+	return fmt.Sprintf("{Socket:%s Container:%s}", e.Socket, e.Container)
+}
+
+// CheckSocketAndCommunicatedDockerContainer returns socket and communicated container from packet and containers.
+// If the packet is sent between the containers, the sender is returned.
+func CheckSocketAndCommunicatedDockerContainer(packet *gopacket.Packet, containers *container.Containers) (socket *Socket, communicatedContainer *container.Container, err error) {
+	var endpoints []*Endpoint
+	endpoints, err = CheckSocketsAndCommunicatedDockerContainers(packet, containers)
+	if err != nil {
+		return
+	}
+	socket, communicatedContainer = endpoints[0].Socket, endpoints[0].Container
+	return
+}
+
+// CheckSocketsAndCommunicatedDockerContainers returns the endpoints of the containers sending and receiving the packet, in that order.
+// The packet between the containers has both the endpoint of the sender and the endpoint of the receiver.
+// The endpoints of the candidates are returned with ErrOwnerNotFound if the owner in the host network is ambiguous.
+func CheckSocketsAndCommunicatedDockerContainers(packet *gopacket.Packet, containers *container.Containers) (endpoints []*Endpoint, err error) {
 	argFields := logrus.WithFields(logrus.Fields{
 		"target_packet": packet,
 		"containers": containers,
 	})
-	argFields.Debug("trying to check socket and communicated container")
-
-	socket = &Socket{}
+	argFields.Debug("trying to check sockets and communicated containers")
 
 	// Check the protocol of network layer
-	var ip packetIPAddr
+	var (
+		ip       packetIPAddr
+		protocol gopacket.LayerType
+	)
 	switch (*packet).NetworkLayer().LayerType() {
 	case layers.LayerTypeIPv4:
 		networkLayer := (*packet).Layer(layers.LayerTypeIPv4).(*layers.IPv4)
 		ip.src = networkLayer.SrcIP
 		ip.dst = networkLayer.DstIP
-		socket.Protocol = networkLayer.NextLayerType()
+		protocol = networkLayer.NextLayerType()
 	case layers.LayerTypeIPv6:
 		networkLayer := (*packet).Layer(layers.LayerTypeIPv6).(*layers.IPv6)
 		ip.src = networkLayer.SrcIP
 		ip.dst = networkLayer.DstIP
-		socket.Protocol = networkLayer.NextLayerType()
+		protocol = skipIPv6ExtensionHeaders(packet, networkLayer.NextLayerType())
 	default:
 		err = errors.New("the network layer protocol not supported")
-		argFields.WithField("error", err).Debug("failed to check socket and communicated container")
+		argFields.WithField("error", err).Debug("failed to check sockets and communicated containers")
 		return
 	}
 
-	// Check container and direction, local IP, remote IP
-	var packetDirection direction
-	containers.RWMutex.RLock()
-	setIPOfSocket:
-	for _, container := range containers.List {
-		for _, ipAddr := range container.IPAddresses {
-			if ip.src.Equal(ipAddr) {
-				packetDirection = out
-				communicatedContainer = container
-				socket.LocalIP, socket.RemoteIP = ip.src, ip.dst
-				break setIPOfSocket
-			} else if ip.dst.Equal(ipAddr) {
-				packetDirection = in
-				communicatedContainer = container
-				socket.LocalIP, socket.RemoteIP = ip.dst, ip.src
-				break setIPOfSocket
-			}
-		}
-	}
-	containers.RWMutex.RUnlock()
-	if communicatedContainer == nil {
-		err = errors.New("communicated container not found")
-		argFields.WithField("error", err).Debug("failed to check socket and communicated container")
-		return
+	// NOTE: ICMP error message is attributed to the flow of the original packet, which was sent in the opposite direction.
+	original := decodeOriginalPacketOfICMPError(packet, protocol)
+	if original != nil {
+		protocol = original.protocol
+		ip.src, ip.dst = original.ip.dst, original.ip.src
 	}
 
 	// Check the protocol inside network layer
-	switch socket.Protocol {
+	var srcPort, dstPort uint16
+	switch protocol {
 	case layers.LayerTypeTCP:
 		tcp, _ := (*packet).Layer(layers.LayerTypeTCP).(*layers.TCP)
-		switch packetDirection {
-		case out:
-			socket.LocalPort, socket.RemotePort = uint16(tcp.SrcPort), uint16(tcp.DstPort)
-		case in:
-			socket.LocalPort, socket.RemotePort = uint16(tcp.DstPort), uint16(tcp.SrcPort)
+		if tcp == nil {
+			break
 		}
+		srcPort, dstPort = uint16(tcp.SrcPort), uint16(tcp.DstPort)
 	case layers.LayerTypeUDP:
 		udp, _ := (*packet).Layer(layers.LayerTypeUDP).(*layers.UDP)
-		switch packetDirection {
-		case out:
-			socket.LocalPort, socket.RemotePort = uint16(udp.SrcPort), uint16(udp.DstPort)
-		case in:
-			socket.LocalPort, socket.RemotePort = uint16(udp.DstPort), uint16(udp.SrcPort)
+		if udp == nil {
+			break
+		}
+		srcPort, dstPort = uint16(udp.SrcPort), uint16(udp.DstPort)
+	case layers.LayerTypeUDPLite:
+		udpLite, _ := (*packet).Layer(layers.LayerTypeUDPLite).(*layers.UDPLite)
+		if udpLite == nil {
+			break
+		}
+		srcPort, dstPort = uint16(udpLite.SrcPort), uint16(udpLite.DstPort)
+	case layers.LayerTypeSCTP:
+		sctp, _ := (*packet).Layer(layers.LayerTypeSCTP).(*layers.SCTP)
+		if sctp == nil {
+			break
+		}
+		srcPort, dstPort = uint16(sctp.SrcPort), uint16(sctp.DstPort)
+	case LayerTypeDCCP:
+		dccp, _ := (*packet).Layer(LayerTypeDCCP).(*DCCP)
+		if dccp == nil {
+			break
+		}
+		srcPort, dstPort = dccp.SrcPort, dccp.DstPort
+	}
+	if original != nil {
+		srcPort, dstPort = original.dstPort, original.srcPort
+	}
+	newSocket := func(packetDirection direction) *Socket {
+		if packetDirection == in {
+			return &Socket{Protocol: protocol, LocalIP: ip.dst, RemoteIP: ip.src, LocalPort: dstPort, RemotePort: srcPort, Inbound: true}
+		}
+		return &Socket{Protocol: protocol, LocalIP: ip.src, RemoteIP: ip.dst, LocalPort: srcPort, RemotePort: dstPort}
+	}
+
+	// Check the containers of the sender and the receiver
+	var (
+		sender, receiver *container.Container
+		// sharingContainers share the network namespace, and one of them owns the socket.
+		senderSharingContainers, receiverSharingContainers, hostNetworkContainers []*container.Container
+	)
+	sender, _ = containers.LookupByIPAddress(ip.src)
+	receiver, _ = containers.LookupByIPAddress(ip.dst)
+	if sender != nil || receiver != nil {
+		containers.IndexNetworkNamespaces(NetworkNamespaceOfContainer)
+	}
+	if sender != nil {
+		senderSharingContainers = containers.LookupSharingNetworkNamespace(sender)
+	}
+	if receiver != nil {
+		receiverSharingContainers = containers.LookupSharingNetworkNamespace(receiver)
+	}
+	if sender == nil && receiver == nil {
+		containers.RWMutex.RLock()
+		for _, container := range containers.List {
+			if container.HostNetwork {
+				hostNetworkContainers = append(hostNetworkContainers, container)
+			}
+		}
+		containers.RWMutex.RUnlock()
+	}
+
+	if sender == nil && receiver == nil {
+		// NOTE: The containers of the host network send and receive the packets by the IP addresses of the host.
+		var packetDirection direction
+		switch {
+		case len(hostNetworkContainers) == 0:
+			err = ErrContainerNotFound
+		case isLocalAddress(ip.src):
+			packetDirection = out
+		case isLocalAddress(ip.dst):
+			packetDirection = in
+		default:
+			err = ErrContainerNotFound
+		}
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to check sockets and communicated containers")
+			return
+		}
+		socket := newSocket(packetDirection)
+		var (
+			communicatedContainer *container.Container
+			candidates            []*container.Container
+		)
+		communicatedContainer, candidates, err = searchOwnerContainer(socket, hostNetworkContainers)
+		for _, candidate := range candidates {
+			endpoints = append(endpoints, &Endpoint{Socket: socket, Container: candidate})
+		}
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to check sockets and communicated containers")
+			return
+		}
+		endpoints = []*Endpoint{{Socket: socket, Container: communicatedContainer}}
+		argFields.WithField("endpoints", endpoints).Debug("sockets and communicated containers checked")
+		return
+	}
+
+	for _, candidate := range []struct {
+		packetDirection   direction
+		container         *container.Container
+		sharingContainers []*container.Container
+	}{
+		{out, sender, senderSharingContainers},
+		{in, receiver, receiverSharingContainers},
+	} {
+		if candidate.container == nil {
+			continue
+		}
+		endpoint := &Endpoint{Socket: newSocket(candidate.packetDirection), Container: candidate.container}
+		if len(candidate.sharingContainers) > 1 {
+			// NOTE: The containers started with --network container:<id> have no IP address, and use the IP addresses of the container.
+			owner, _, searchErr := searchOwnerContainer(endpoint.Socket, candidate.sharingContainers)
+			if searchErr == nil {
+				endpoint.Container = owner
+			} else {
+				argFields.WithField("warn", searchErr).Debug("the owner not found in the containers sharing the network namespace, so the container of the ip address is used")
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	argFields.WithField("endpoints", endpoints).Debug("sockets and communicated containers checked")
+	return
+}
+
+// searchOwnerContainer returns the container owning the socket among the containers sharing the network namespace, such as the containers of the host network.
+// The container is attributed by the processes in its cgroup, because the containers sharing the network namespace share the IP addresses.
+// The socket already closed is attributed by the history, and ErrContainerNotFound is returned for the socket owned by none of them.
+// ErrOwnerNotFound is returned with the candidates if the owner is ambiguous.
+func searchOwnerContainer(socket *Socket, sharingContainers []*container.Container) (communicatedContainer *container.Container, candidates []*container.Container, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"target_socket": socket,
+		"sharing_containers": sharingContainers,
+	})
+	argFields.Debug("trying to search the container owning the socket")
+
+	if cacheRawData, exist := OwnerContainerCache.Get(socket.Hash()); exist {
+		communicatedContainer = cacheRawData.(*container.Container)
+		argFields.WithField("communicated_container", communicatedContainer).Debug("the container owning the socket found")
+		return
+	}
+
+	switch socket.Protocol {
+	case layers.LayerTypeTCP, layers.LayerTypeUDP, layers.LayerTypeUDPLite, layers.LayerTypeSCTP, LayerTypeDCCP:
+	default:
+		communicatedContainer, candidates, err = searchOwnerContainerOfRawSocket(socket, sharingContainers)
+		return
+	}
+	// NOTE: The inode is searched by any living process of the containers, because they share the network namespace.
+	var inode uint64
+	for _, sharingContainer := range sharingContainers {
+		inode, err = searchInodeOfSocket(socket, sharingContainer.Pid)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		// NOTE: The socket never held by the processes of the containers is the socket of the host.
+		communicatedContainer, err = recallOwnerContainer(socket, sharingContainers)
+		if err != nil {
+			argFields.WithField("error", err).Debug("failed to search the container owning the socket")
+			return
 		}
+		argFields.WithField("communicated_container", communicatedContainer).Debug("the container owning the socket recalled")
+		return
+	}
+	if SocketInodeExists(container.DNSHelperPID(), inode) {
+		err = ErrContainerNotFound
+		argFields.WithField("error", err).Debug("failed to search the container owning the socket")
+		return
+	}
+	for _, sharingContainer := range sharingContainers {
+		var processes []*Process
+		processes, err = SearchProcessesOfContainerFromInode(sharingContainer, socket, inode)
+		if err != nil {
+			continue
+		}
+		communicatedContainer = sharingContainer
+		SocketCache.Set(socket.Hash(), processes, 0)
+		OwnerContainerCache.Set(socket.Hash(), communicatedContainer, 0)
+		argFields.WithField("communicated_container", communicatedContainer).Debug("the container owning the socket found")
+		return
 	}
+	err = ErrContainerNotFound
+	argFields.WithField("error", err).Debug("failed to search the container owning the socket")
+	return
+}
 
-	argFields.WithFields(logrus.Fields{
+// searchOwnerContainerOfRawSocket returns the only container owning the raw sockets of the protocol without ports, such as ICMP.
+// The packet is attributed to none of the containers if none of them has the raw socket, such as the echo reply of the kernel,
+// and the containers having the raw sockets are returned as the candidates with ErrOwnerNotFound if several of them have.
+func searchOwnerContainerOfRawSocket(socket *Socket, sharingContainers []*container.Container) (communicatedContainer *container.Container, candidates []*container.Container, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
 		"target_socket": socket,
-		"communicated_container": communicatedContainer,
-		}).Debug("socket and communicated container checked")
+		"sharing_containers": sharingContainers,
+	})
+	argFields.Debug("trying to search the container owning the raw socket")
+
+	var inodes []uint64
+	for _, sharingContainer := range sharingContainers {
+		inodes, err = RetrieveAllInodeFromRawOfPid(sharingContainer.Pid, socket)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		// NOTE: The protocol without the raw socket table, such as GRE, is not sent by the raw socket of the containers.
+		err = ErrContainerNotFound
+		argFields.WithField("error", err).Debug("failed to search the container owning the raw socket")
+		return
+	}
+	var owners []*container.Container
+	for _, sharingContainer := range sharingContainers {
+		for _, inode := range inodes {
+			if _, searchErr := SearchProcessesOfContainerFromInode(sharingContainer, socket, inode); searchErr == nil {
+				owners = append(owners, sharingContainer)
+				break
+			}
+		}
+	}
+	switch len(owners) {
+	case 0:
+		err = ErrContainerNotFound
+	case 1:
+		communicatedContainer = owners[0]
+		argFields.WithField("communicated_container", communicatedContainer).Debug("the container owning the raw socket found")
+		return
+	default:
+		candidates, err = owners, ErrOwnerNotFound
+	}
+	argFields.WithField("error", err).Debug("failed to search the container owning the raw socket")
 	return
 }
 
+// decodeOriginalPacketOfICMPError returns the original packet embedded in ICMP error message, or nil if the packet is not ICMP error message of the protocol with ports.
+func decodeOriginalPacketOfICMPError(packet *gopacket.Packet, protocol gopacket.LayerType) (original *originalPacket) {
+	var (
+		payload        []byte
+		firstLayerType gopacket.LayerType
+	)
+	switch protocol {
+	case layers.LayerTypeICMPv4:
+		icmpv4, _ := (*packet).Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+		if icmpv4 == nil {
+			return
+		}
+		switch icmpv4.TypeCode.Type() {
+		case layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4TypeSourceQuench, layers.ICMPv4TypeTimeExceeded, layers.ICMPv4TypeParameterProblem:
+			payload, firstLayerType = icmpv4.LayerPayload(), layers.LayerTypeIPv4
+		default:
+			return
+		}
+	case layers.LayerTypeICMPv6:
+		icmpv6, _ := (*packet).Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6)
+		if icmpv6 == nil {
+			return
+		}
+		switch icmpv6.TypeCode.Type() {
+		case layers.ICMPv6TypeDestinationUnreachable, layers.ICMPv6TypePacketTooBig, layers.ICMPv6TypeTimeExceeded, layers.ICMPv6TypeParameterProblem:
+			// NOTE: The original packet follows the 4 bytes of unused, MTU or pointer field.
+			if len(icmpv6.LayerPayload()) < 4 {
+				return
+			}
+			payload, firstLayerType = icmpv6.LayerPayload()[4:], layers.LayerTypeIPv6
+		default:
+			return
+		}
+	default:
+		return
+	}
+
+	// NOTE: The transport layer of the original packet is usually truncated to 8 bytes, so only the ports are read.
+	embeddedPacket := gopacket.NewPacket(payload, firstLayerType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	var (
+		ip            packetIPAddr
+		nextLayerType gopacket.LayerType
+	)
+	switch networkLayer := embeddedPacket.NetworkLayer().(type) {
+	case *layers.IPv4:
+		ip.src, ip.dst = networkLayer.SrcIP, networkLayer.DstIP
+		nextLayerType = networkLayer.NextLayerType()
+	case *layers.IPv6:
+		ip.src, ip.dst = networkLayer.SrcIP, networkLayer.DstIP
+		nextLayerType = skipIPv6ExtensionHeaders(&embeddedPacket, networkLayer.NextLayerType())
+	default:
+		return
+	}
+	switch nextLayerType {
+	case layers.LayerTypeTCP, layers.LayerTypeUDP, layers.LayerTypeUDPLite, layers.LayerTypeSCTP, LayerTypeDCCP:
+	default:
+		return
+	}
+	var transportData []byte
+	for _, layer := range embeddedPacket.Layers() {
+		if layer.LayerType() == nextLayerType || layer.LayerType() == gopacket.LayerTypeDecodeFailure {
+			break
+		}
+		transportData = layer.LayerPayload()
+	}
+	if len(transportData) < 4 {
+		return
+	}
+	original = &originalPacket{
+		protocol: nextLayerType,
+		ip:       ip,
+		srcPort:  binary.BigEndian.Uint16(transportData[0:2]),
+		dstPort:  binary.BigEndian.Uint16(transportData[2:4]),
+	}
+	logrus.WithField("original_packet", original).Debug("original packet of icmp error message decoded")
+	return
+}
+
+// skipIPv6ExtensionHeaders returns the layer type following the IPv6 extension headers.
+func skipIPv6ExtensionHeaders(packet *gopacket.Packet, nextLayerType gopacket.LayerType) gopacket.LayerType {
+	for _, layer := range (*packet).Layers() {
+		if layer.LayerType() != nextLayerType {
+			continue
+		}
+		switch extensionHeader := layer.(type) {
+		case *layers.IPv6HopByHop:
+			nextLayerType = extensionHeader.NextHeader.LayerType()
+		case *layers.IPv6Routing:
+			nextLayerType = extensionHeader.NextHeader.LayerType()
+		case *layers.IPv6Fragment:
+			nextLayerType = extensionHeader.NextHeader.LayerType()
+		case *layers.IPv6Destination:
+			nextLayerType = extensionHeader.NextHeader.LayerType()
+		}
+	}
+	return nextLayerType
+}
+
 // CheckIdentifierOfICMP returns identifier from icmp packet.
 func CheckIdentifierOfICMP(socket *Socket, packet *gopacket.Packet) (identifier uint16, err error) {
 	argFields := logrus.WithField("packet", packet)
@@ -0,0 +1,312 @@
+package proc
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+)
+
+var (
+	// ContainerProcesses stores the processes of containers maintained by the proc connector.
+	ContainerProcesses *ProcessTable = NewProcessTable()
+)
+
+// ProcessTable is the table of processes per container updated by fork, exec and exit events of the kernel proc connector.
+type ProcessTable struct {
+	containers map[string]map[int]*Process     // the key is container id, and the key of value is pid
+	owners     map[int]string                  // the key is pid, and the value is container id
+	tracked    map[string]*container.Container // the key is container id, and the value is the container whose sockets are recorded
+	pending    map[string]struct{}             // the key is container id whose sockets are recorded by the next snapshot
+	watching   bool
+	RWMutex    sync.RWMutex
+	// SnapshotInterval is the interval to record the sockets of the pending containers, which is set before the watching starts.
+	// The snapshots are disabled if it is 0.
+	SnapshotInterval time.Duration
+}
+
+// NewProcessTable returns the empty ProcessTable.
+func NewProcessTable() *ProcessTable {
+	return &ProcessTable{
+		containers:       map[string]map[int]*Process{},
+		owners:           map[int]string{},
+		tracked:          map[string]*container.Container{},
+		pending:          map[string]struct{}{},
+		SnapshotInterval: DefaultSnapshotInterval,
+	}
+}
+
+// StartWatching subscribes the process events of the proc connector and starts updating the table.
+// Until the watching starts, the table does not hold processes and they are enumerated from proc filesystem each time.
+func (t *ProcessTable) StartWatching() (err error) {
+	logrus.Debug("trying to start watching process events")
+
+	var conn *netlink.Conn
+	conn, err = dialProcConnector()
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to start watching process events")
+		return
+	}
+	t.RWMutex.Lock()
+	t.watching = true
+	t.RWMutex.Unlock()
+	go t.watch(conn)
+	if t.SnapshotInterval > 0 {
+		go t.snapshot()
+	}
+
+	logrus.Debug("watching process events started")
+	return
+}
+
+func (t *ProcessTable) watch(conn *netlink.Conn) {
+	defer conn.Close()
+	for {
+		messages, err := conn.Receive()
+		if err == syscall.ENOBUFS {
+			// NOTE: The events were lost, so the processes are enumerated again from proc filesystem.
+			logrus.WithField("error", err).Warn("process events lost")
+			t.Flush()
+			continue
+		} else if err != nil {
+			logrus.WithField("error", err).Error("failed to receive process events")
+			t.RWMutex.Lock()
+			t.watching = false
+			t.RWMutex.Unlock()
+			t.Flush()
+			return
+		}
+		for _, message := range messages {
+			event, err := parseProcEvent(message)
+			if err != nil {
+				logrus.WithField("error", err).Trace("the message of proc connector skipped")
+				continue
+			}
+			t.handleEvent(event)
+		}
+	}
+}
+
+func (t *ProcessTable) handleEvent(event procEvent) {
+	// NOTE: The events of threads are ignored because the table holds processes.
+	if event.pid != event.tgid {
+		return
+	}
+	eventFields := logrus.WithField("process_event", event)
+
+	switch event.what {
+	case procEventFork:
+		t.RWMutex.Lock()
+		defer t.RWMutex.Unlock()
+		cid, exist := t.owners[event.parentTgid]
+		if !exist {
+			return
+		}
+		parent := t.containers[cid][event.parentTgid]
+		child := &Process{ID: event.tgid, Executable: parent.Executable, Path: parent.Path, ParentID: parent.ID}
+		t.containers[cid][child.ID] = child
+		t.owners[child.ID] = cid
+		t.pending[cid] = struct{}{}
+		eventFields.WithFields(logrus.Fields{"container_id": cid, "process": child}).Trace("the forked process added")
+	case procEventExec:
+		t.RWMutex.RLock()
+		_, exist := t.owners[event.tgid]
+		t.RWMutex.RUnlock()
+		if !exist {
+			return
+		}
+		executable, nameErr := RetrieveProcessName(event.tgid)
+		path, pathErr := RetrieveProcessPath(event.tgid)
+		t.RWMutex.Lock()
+		cid, exist := t.owners[event.tgid]
+		if !exist {
+			t.RWMutex.Unlock()
+			return
+		}
+		process := t.containers[cid][event.tgid]
+		if nameErr == nil {
+			process.Executable = executable
+		}
+		if pathErr == nil {
+			process.Path = path
+		}
+		t.pending[cid] = struct{}{}
+		copied := *process
+		t.RWMutex.Unlock()
+		eventFields.WithFields(logrus.Fields{"container_id": cid, "process": process}).Trace("the executed process updated")
+		// NOTE: The sockets inherited through exec are recorded, because they may be closed before the packets are identified.
+		if socketInodes, err := RetrieveSocketInodes(copied.ID); err == nil {
+			recordSocketInodes(cid, &copied, socketInodes)
+		}
+	case procEventComm:
+		t.RWMutex.Lock()
+		defer t.RWMutex.Unlock()
+		cid, exist := t.owners[event.tgid]
+		if !exist {
+			return
+		}
+		t.containers[cid][event.tgid].Executable = event.comm
+	case procEventExit:
+		t.RWMutex.Lock()
+		defer t.RWMutex.Unlock()
+		cid, exist := t.owners[event.tgid]
+		if !exist {
+			return
+		}
+		delete(t.containers[cid], event.tgid)
+		delete(t.owners, event.tgid)
+		eventFields.WithField("container_id", cid).Trace("the exited process removed")
+	}
+}
+
+// ProcessesOfContainer returns the processes of the container.
+// If the container is not in the table or refresh is true, the processes are enumerated from proc filesystem.
+func (t *ProcessTable) ProcessesOfContainer(targetContainer *container.Container, refresh bool) (processes []*Process, err error) {
+	argFields := logrus.WithFields(logrus.Fields{
+		"target_container": targetContainer,
+		"refresh":          refresh,
+	})
+	argFields.Debug("trying to get processes of container")
+
+	t.RWMutex.RLock()
+	tracked, exist := t.containers[targetContainer.ID]
+	if exist && !refresh {
+		processes = make([]*Process, 0, len(tracked))
+		for _, process := range tracked {
+			copied := *process
+			processes = append(processes, &copied)
+		}
+		t.RWMutex.RUnlock()
+		argFields.WithField("processes", processes).Debug("the processes of container got from the table")
+		return
+	}
+	watching := t.watching
+	t.RWMutex.RUnlock()
+
+	var pids []int
+	pids, err = RetrievePIDsOfContainer(targetContainer)
+	if err != nil {
+		argFields.WithField("error", err).Debug("failed to get processes of container")
+		return
+	}
+	enumerated := make(map[int]*Process, len(pids))
+	processes = make([]*Process, 0, len(pids))
+	for _, pid := range pids {
+		process, makeErr := MakeProcessStruct(pid)
+		if makeErr != nil {
+			pidFields := argFields.WithFields(logrus.Fields{
+				"pid":   pid,
+				"error": makeErr,
+			})
+			// NOTE: The process may exit while enumerating, and the other failures leave the process unattributed.
+			if os.IsNotExist(makeErr) {
+				pidFields.Debug("the process of container exited while enumerating")
+			} else {
+				pidFields.Warn("failed to make the process of container, so it is not attributed")
+			}
+			continue
+		}
+		enumerated[pid] = process
+		copied := *process
+		processes = append(processes, &copied)
+	}
+	if watching {
+		t.RWMutex.Lock()
+		for pid := range t.containers[targetContainer.ID] {
+			delete(t.owners, pid)
+		}
+		t.containers[targetContainer.ID] = enumerated
+		t.tracked[targetContainer.ID] = targetContainer
+		t.pending[targetContainer.ID] = struct{}{}
+		for pid := range enumerated {
+			t.owners[pid] = targetContainer.ID
+		}
+		t.RWMutex.Unlock()
+	}
+
+	argFields.WithField("processes", processes).Debug("the processes of container enumerated")
+	return
+}
+
+// Lookup returns the process and the id of container having the pid.
+func (t *ProcessTable) Lookup(pid int) (process *Process, cid string, exist bool) {
+	t.RWMutex.RLock()
+	defer t.RWMutex.RUnlock()
+	cid, exist = t.owners[pid]
+	if !exist {
+		return
+	}
+	copied := *t.containers[cid][pid]
+	process = &copied
+	return
+}
+
+// RemoveContainer removes the processes of the container from the table.
+func (t *ProcessTable) RemoveContainer(cid string) {
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+	for pid := range t.containers[cid] {
+		delete(t.owners, pid)
+	}
+	delete(t.containers, cid)
+	delete(t.tracked, cid)
+	delete(t.pending, cid)
+	logrus.WithField("container_id", cid).Debug("the processes of container removed from the table")
+}
+
+// Flush removes all processes from the table.
+func (t *ProcessTable) Flush() {
+	t.RWMutex.Lock()
+	t.containers = map[string]map[int]*Process{}
+	t.owners = map[int]string{}
+	t.tracked = map[string]*container.Container{}
+	t.pending = map[string]struct{}{}
+	t.RWMutex.Unlock()
+	logrus.Debug("the process table flushed")
+}
+
+// requestSnapshot makes the sockets of the tracked container recorded by the next snapshot.
+func (t *ProcessTable) requestSnapshot(cid string) {
+	t.RWMutex.Lock()
+	defer t.RWMutex.Unlock()
+	if _, exist := t.tracked[cid]; exist {
+		t.pending[cid] = struct{}{}
+	}
+}
+
+// snapshot records the sockets of the pending containers into the history every SnapshotInterval while watching.
+// The containers are pending after their processes fork or execute, or after their sockets are not found in the history,
+// so that the containers without the new processes or the missed sockets are not walked.
+// NOTE: The exit event of the proc connector is notified after the file descriptors are closed,
+// so the sockets are recorded while the processes are alive, for the processes exiting before their packets are identified.
+func (t *ProcessTable) snapshot() {
+	ticker := time.NewTicker(t.SnapshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		t.RWMutex.Lock()
+		watching := t.watching
+		tracked := make([]*container.Container, 0, len(t.pending))
+		for cid := range t.pending {
+			if trackedContainer, exist := t.tracked[cid]; exist {
+				tracked = append(tracked, trackedContainer)
+			}
+		}
+		t.pending = map[string]struct{}{}
+		t.RWMutex.Unlock()
+		if !watching {
+			return
+		}
+		for _, trackedContainer := range tracked {
+			if err := SnapshotSocketsOfContainer(trackedContainer); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"container": trackedContainer,
+					"error":     err,
+				}).Debug("failed to snapshot sockets of container")
+			}
+		}
+	}
+}
@@ -1,72 +1,223 @@
 package runnotify
 
 import (
-	"path/filepath"
+	"errors"
+	"net"
+	"time"
 
-	"github.com/docker/docker/api/types/events"
 	"github.com/sirupsen/logrus"
-	"github.com/tomo-9925/cnet/pkg/docker"
+	"github.com/tomo-9925/cnet/pkg/container"
 )
 
-// API is the collection of channels that receive container events from Docker Engine API
+const (
+	// DefaultInitialBackoff is the first interval to subscribe the events again after they are interrupted.
+	DefaultInitialBackoff time.Duration = time.Second
+	// DefaultMaxBackoff is the limit of the interval doubled while the runtime is unavailable.
+	DefaultMaxBackoff time.Duration = 30 * time.Second
+	// DefaultHealthyPeriod is the period for which the events stay subscribed before the backoff is reset.
+	DefaultHealthyPeriod time.Duration = time.Minute
+)
+
+// errEventsClosed is sent when the events of the runtime are closed without any error.
+var errEventsClosed error = errors.New("the container events closed")
+
+// API is the collection of channels that receive container events from the container runtime
 type API struct {
-	Messages <-chan events.Message
+	Messages <-chan container.Event
 	Err      <-chan error
-	runCh    chan string
-	killCh   chan string
-	errCh    chan error
+	// InitialBackoff and MaxBackoff are the intervals to subscribe the events again,
+	// and HealthyPeriod is the period after which the interrupted events are subscribed again with InitialBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	HealthyPeriod  time.Duration
+	runtime        container.Runtime
+	containers     *container.Containers
+	runCh          chan string
+	killCh         chan string
+	updateCh       chan string
+	errCh          chan error
+	// done is closed when the events are subscribed again, so that the previous events end.
+	done chan struct{}
 }
 
 // NewAPI return the RunNotify.API
-func NewAPI(runCh chan string, killCh chan string, errCh chan error) *API {
+// The containers are compared with the containers listed by the runtime, so that the events missed while the events are not received are notified.
+// The containers whose networks or names change are notified by the update channel.
+func NewAPI(runtime container.Runtime, containers *container.Containers, runCh chan string, killCh chan string, updateCh chan string, errCh chan error) *API {
 	argFields := logrus.WithFields(logrus.Fields{
+		"runtime": runtime.Name(),
 		"run_channel": runCh,
 		"kill_channel": killCh,
+		"update_channel": updateCh,
 		"error_channel": errCh,
 	})
 	argFields.Debug("trying to make runnotify api")
 
-	runNotifyAPI := API{Messages: nil, runCh: runCh, killCh: killCh, errCh: errCh}
-	runNotifyAPI.Messages, runNotifyAPI.Err = docker.NewEventWatcher()
+	runNotifyAPI := API{
+		Messages: nil,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		HealthyPeriod: DefaultHealthyPeriod,
+		runtime: runtime,
+		containers: containers,
+		runCh: runCh,
+		killCh: killCh,
+		updateCh: updateCh,
+		errCh: errCh,
+	}
+	runNotifyAPI.subscribe()
 
 	argFields.WithField("run_notify_api", runNotifyAPI).Debug("runnotify api made")
 	return &runNotifyAPI
 }
 
 // Start starts monitoring
+// When the events are interrupted, such as by the restart of the runtime, the error is sent to the error channel,
+// and the events are subscribed again with the backoff. The containers are resynchronized after every subscription.
+// The backoff is reset only after an event is received or the events stay subscribed for HealthyPeriod,
+// so that the events of the runtime failing right after the subscription, such as CRI without the container events, are not subscribed every InitialBackoff.
 func (runNotifyAPI *API) Start() {
 	apiField := logrus.WithField("run_notify_api", runNotifyAPI)
-	apiField.Debug("trying to start docker event monitoring")
+	apiField.Debug("trying to start container event monitoring")
 
 	defer close(runNotifyAPI.runCh)
 	defer close(runNotifyAPI.killCh)
+	defer close(runNotifyAPI.updateCh)
 	defer close(runNotifyAPI.errCh)
 
-	lastRun := ""
-	lastKill := ""
+	backoff := runNotifyAPI.InitialBackoff
+	subscribed := time.Now()
+	// NOTE: The containers changed before the first subscription are also notified.
+	if err := runNotifyAPI.resync(); err != nil {
+		apiField.WithField("error", err).Debug("container events error received")
+		runNotifyAPI.errCh <- err
+		backoff = runNotifyAPI.reconnect(backoff)
+		subscribed = time.Now()
+	}
+	// NOTE: The events are not deduplicated, because the container may stop and start again,
+	// and the handlers of the events, including the events duplicated by the resync, are idempotent.
 	for {
 		select {
 		case msg := <-runNotifyAPI.Messages:
-			apiField.WithField("message", msg).Debug("docker event received")
+			apiField.WithField("message", msg).Debug("container event received")
+			backoff = runNotifyAPI.InitialBackoff
 			switch msg.Action{
-			case "start", "unpause":
-				cid := filepath.Base(msg.ID)
-				if lastRun == cid {
-					continue
-				}
-				runNotifyAPI.runCh <- cid
-				lastRun = cid
-			case "pause", "die":
-				cid := filepath.Base(msg.ID)
-				if cid == lastKill {
-					continue
-				}
-				runNotifyAPI.killCh <- cid
-				lastKill = cid
+			case container.ActionRun:
+				runNotifyAPI.runCh <- msg.ID
+			case container.ActionKill:
+				runNotifyAPI.killCh <- msg.ID
+			case container.ActionUpdate:
+				runNotifyAPI.updateCh <- msg.ID
 			}
-		case err := <-runNotifyAPI.Err:
-			apiField.WithField("error", err).Debug("docker events error received")
+		case err, ok := <-runNotifyAPI.Err:
+			if !ok || err == nil {
+				err = errEventsClosed
+			}
+			apiField.WithField("error", err).Debug("container events error received")
 			runNotifyAPI.errCh <- err
+			if time.Since(subscribed) >= runNotifyAPI.HealthyPeriod {
+				backoff = runNotifyAPI.InitialBackoff
+			}
+			backoff = runNotifyAPI.reconnect(backoff)
+			subscribed = time.Now()
+		}
+	}
+}
+
+// reconnect subscribes the events again and resynchronizes the containers after the backoff, until both succeed.
+// The backoff is doubled up to MaxBackoff after every attempt, and the next backoff is returned.
+func (runNotifyAPI *API) reconnect(backoff time.Duration) (next time.Duration) {
+	for {
+		backoffField := logrus.WithFields(logrus.Fields{
+			"runtime": runNotifyAPI.runtime.Name(),
+			"backoff": backoff,
+		})
+		backoffField.Debug("trying to subscribe container events again")
+		time.Sleep(backoff)
+
+		// NOTE: The events are subscribed before the containers are listed, so that no event is lost between them.
+		runNotifyAPI.subscribe()
+		err := runNotifyAPI.resync()
+		if backoff *= 2; backoff > runNotifyAPI.MaxBackoff {
+			backoff = runNotifyAPI.MaxBackoff
+		}
+		if err == nil {
+			backoffField.Info("container events subscribed again")
+			next = backoff
+			return
+		}
+		backoffField.WithField("error", err).Warn("failed to subscribe container events again")
+	}
+}
+
+// subscribe ends the previous events and subscribes the events of the runtime.
+func (runNotifyAPI *API) subscribe() {
+	if runNotifyAPI.done != nil {
+		close(runNotifyAPI.done)
+	}
+	runNotifyAPI.done = make(chan struct{})
+	runNotifyAPI.Messages, runNotifyAPI.Err = runNotifyAPI.runtime.Events(runNotifyAPI.done)
+}
+
+// resync lists the containers of the runtime and notifies the differences from the containers,
+// which are the containers run, killed or changed while the events were not received.
+func (runNotifyAPI *API) resync() (err error) {
+	logrus.Debug("trying to resync containers")
+
+	var inspections []*container.Container
+	inspections, err = runNotifyAPI.runtime.List()
+	if err != nil {
+		logrus.WithField("error", err).Debug("failed to resync containers")
+		return
+	}
+	var runIDs, killIDs []string
+	listed := make(map[string]struct{}, len(inspections))
+	for _, inspection := range inspections {
+		listed[inspection.ID] = struct{}{}
+		if current, exist := runNotifyAPI.containers.LookupByID(inspection.ID); !exist || !sameInspection(current, inspection) {
+			runIDs = append(runIDs, inspection.ID)
+		}
+	}
+	runNotifyAPI.containers.RWMutex.RLock()
+	for _, current := range runNotifyAPI.containers.List {
+		if _, exist := listed[current.ID]; !exist {
+			killIDs = append(killIDs, current.ID)
+		}
+	}
+	runNotifyAPI.containers.RWMutex.RUnlock()
+
+	// NOTE: The channels are sent after the lock is released, because the receivers modify the containers.
+	for _, cid := range killIDs {
+		runNotifyAPI.killCh <- cid
+	}
+	for _, cid := range runIDs {
+		runNotifyAPI.runCh <- cid
+	}
+	logrus.WithFields(logrus.Fields{
+		"run_container_ids": runIDs,
+		"killed_container_ids": killIDs,
+	}).Debug("containers resynced")
+	return
+}
+
+// sameInspection reports whether the inspections of the container have the same process, name and networks.
+func sameInspection(x, y *container.Container) bool {
+	if x.Pid != y.Pid || x.Name != y.Name || x.HostNetwork != y.HostNetwork || len(x.IPAddresses) != len(y.IPAddresses) {
+		return false
+	}
+	for _, ipAddress := range x.IPAddresses {
+		if !containsIPAddress(y.IPAddresses, ipAddress) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsIPAddress(ipAddresses []net.IP, ipAddress net.IP) bool {
+	for _, listedIPAddress := range ipAddresses {
+		if listedIPAddress.Equal(ipAddress) {
+			return true
 		}
 	}
+	return false
 }
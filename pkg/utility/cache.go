@@ -2,13 +2,19 @@ package utility
 
 import (
 	"github.com/sirupsen/logrus"
+	"github.com/tomo-9925/cnet/pkg/conntrack"
 	"github.com/tomo-9925/cnet/pkg/policy"
 	"github.com/tomo-9925/cnet/pkg/proc"
 )
 
-// ClearCache clears cache of policy and proc package.
+// ClearCache clears cache of policy and proc package, and the verdicts of the flows recorded in conntrack table.
 func ClearCache() {
 	logrus.Infoln("clear cache")
 	proc.SocketCache.Flush()
+	proc.OwnerContainerCache.Flush()
+	proc.NetworkNamespaceCache.Flush()
 	policy.PolicyCache.Flush()
+	if err := conntrack.Verdicts.Reset(false); err != nil {
+		logrus.WithField("error", err).Warn("failed to reset the verdicts of the flows")
+	}
 }
@@ -0,0 +1,202 @@
+package conntrack_test
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/tomo-9925/cnet/pkg/conntrack"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"golang.org/x/sys/unix"
+)
+
+func TestTupleOfSocket(t *testing.T) {
+	socket := &proc.Socket{Protocol: layers.LayerTypeTCP, LocalIP: net.ParseIP("172.17.0.2"), RemoteIP: net.ParseIP("158.217.2.147"), LocalPort: 40000, RemotePort: 80}
+	tuple, err := conntrack.TupleOfSocket(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tuple.Protocol != uint8(layers.IPProtocolTCP) || !tuple.SrcIP.Equal(socket.LocalIP) || tuple.SrcPort != socket.LocalPort {
+		t.Error("tuple not made correctly", tuple)
+	}
+	reversed := tuple.Reverse()
+	if !reversed.SrcIP.Equal(socket.RemoteIP) || reversed.SrcPort != socket.RemotePort || reversed.DstPort != socket.LocalPort {
+		t.Error("tuple not reversed correctly", reversed)
+	}
+
+	socket.Protocol = layers.LayerTypeICMPv4
+	if _, err = conntrack.TupleOfSocket(socket); err == nil {
+		t.Error("tuple of icmp socket made unexpectedly")
+	}
+}
+
+func TestRecordVerdicts(t *testing.T) {
+	if err := conntrack.Verdicts.Open(); err != nil {
+		t.Skip("ctnetlink not available:", err)
+	}
+	defer conntrack.Verdicts.Close()
+
+	// NOTE: The flows are created in conntrack table with the addresses for documentation.
+	for _, socket := range []*proc.Socket{
+		{Protocol: layers.LayerTypeTCP, LocalIP: net.ParseIP("192.0.2.2"), RemoteIP: net.ParseIP("198.51.100.1"), LocalPort: 40000, RemotePort: 80},
+		{Protocol: layers.LayerTypeUDP, LocalIP: net.ParseIP("2001:db8:1::2"), RemoteIP: net.ParseIP("2001:db8::53"), LocalPort: 40001, RemotePort: 53},
+	} {
+		if err := conntrack.Verdicts.Deny(socket); err != nil {
+			t.Fatal(socket, err)
+		}
+		// NOTE: The denied flow exists, so the verdict is changed.
+		if err := conntrack.Verdicts.Accept(socket); err != nil {
+			t.Fatal(socket, err)
+		}
+	}
+	if err := conntrack.Verdicts.Reset(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := conntrack.Verdicts.Clear(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// conntrackRequest returns the ctnetlink request of the message type with the tuples of the flow.
+func conntrackRequest(messageType uint16, flags uint16, attributes ...netlink.Attribute) netlink.Message {
+	return netlink.Message{
+		Header: unix.NlMsghdr{Type: 1<<8 | messageType, Flags: flags},
+		Data:   append([]byte{unix.AF_INET, 0, 0, 0}, netlink.MarshalAttributes(attributes)...),
+	}
+}
+
+// tupleAttribute returns CTA_TUPLE_ORIG or CTA_TUPLE_REPLY of the ipv4 tcp flow.
+func tupleAttribute(attributeType uint16, srcIP, dstIP string, srcPort, dstPort uint16) netlink.Attribute {
+	return netlink.NewNestedAttribute(attributeType,
+		netlink.NewNestedAttribute(1,
+			netlink.NewAttribute(1, net.ParseIP(srcIP).To4()),
+			netlink.NewAttribute(2, net.ParseIP(dstIP).To4()),
+		),
+		netlink.NewNestedAttribute(2,
+			netlink.NewUint8Attribute(1, uint8(layers.IPProtocolTCP)),
+			netlink.NewUint16Attribute(2, srcPort),
+			netlink.NewUint16Attribute(3, dstPort),
+		),
+	)
+}
+
+func TestDenyFlowTranslatedByDNAT(t *testing.T) {
+	if err := conntrack.Verdicts.Open(); err != nil {
+		t.Skip("ctnetlink not available:", err)
+	}
+	defer conntrack.Verdicts.Close()
+	conn, err := netlink.Dial(unix.NETLINK_NETFILTER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// NOTE: The flow to the published port 8080 of the host is translated to the port 80 of the container.
+	remotePort := uint16(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(20000) + 40000)
+	socket := &proc.Socket{Protocol: layers.LayerTypeTCP, LocalIP: net.ParseIP("192.0.2.2"), RemoteIP: net.ParseIP("198.51.100.1"), LocalPort: 80, RemotePort: remotePort, Inbound: true}
+	if err = conntrack.Verdicts.Deny(socket); err == nil {
+		t.Error("the flow not confirmed marked as denied unexpectedly")
+	}
+	_, err = conn.Execute(conntrackRequest(0, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+		tupleAttribute(1, "198.51.100.1", "192.0.2.1", remotePort, 8080),
+		tupleAttribute(2, "192.0.2.2", "198.51.100.1", 80, remotePort),
+		netlink.NewUint32Attribute(7, 30),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conntrack.Verdicts.Clear()
+	if err = conntrack.Verdicts.Deny(socket); err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: The flow of the original tuple not translated is not created.
+	if _, err = conn.Execute(conntrackRequest(1, 0, tupleAttribute(1, "198.51.100.1", "192.0.2.2", remotePort, 80))); !errors.Is(err, syscall.ENOENT) {
+		t.Error("the flow not translated created unexpectedly", err)
+	}
+	replies, err := conn.Execute(conntrackRequest(1, 0, tupleAttribute(1, "198.51.100.1", "192.0.2.1", remotePort, 8080)))
+	if err != nil || len(replies) != 1 {
+		t.Fatal("the translated flow not found", err)
+	}
+	attributes, err := netlink.UnmarshalAttributes(replies[0].Data[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mark uint32
+	for _, attribute := range attributes {
+		if attribute.Kind() == 8 {
+			mark = attribute.Uint32()
+		}
+	}
+	if mark&conntrack.MarkMask != conntrack.MarkDenied {
+		t.Error("the translated flow not marked as denied", mark)
+	}
+}
+
+func TestFlowTable(t *testing.T) {
+	if err := conntrack.Verdicts.Open(); err != nil {
+		t.Skip("ctnetlink not available:", err)
+	}
+	defer conntrack.Verdicts.Close()
+	if err := conntrack.Flows.StartWatching(); err != nil {
+		t.Skip("conntrack events not available:", err)
+	}
+	defer conntrack.Flows.Flush()
+
+	// NOTE: The local port differs in each run, because the denied flow remains in conntrack table for a while.
+	localPort := uint16(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(20000) + 40000)
+	socket := &proc.Socket{Protocol: layers.LayerTypeTCP, LocalIP: net.ParseIP("192.0.2.3"), RemoteIP: net.ParseIP("198.51.100.1"), LocalPort: localPort, RemotePort: 443}
+	communicatedContainer := &container.Container{ID: "4e3a2b1c", Name: "/cnet_flow_test"}
+	communicatedProcesses := []*proc.Process{{ID: 101, Executable: "nc", Path: "/bin/nc"}}
+	// NOTE: The denied flow is created in conntrack table before it is recorded, so the id of the flow is retrieved.
+	if err := conntrack.Verdicts.Deny(socket); err != nil {
+		t.Fatal(err)
+	}
+	defer conntrack.Verdicts.Clear()
+	if err := conntrack.Flows.Record(socket, communicatedContainer, communicatedProcesses, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tuple, _ := conntrack.TupleOfSocket(socket)
+	var flow conntrack.Flow
+	for i := 0; i < 100; i++ {
+		var exist bool
+		flow, exist = conntrack.Flows.Lookup(tuple)
+		if !exist {
+			t.Fatal("the recorded flow not found")
+		}
+		if flow.ID != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if flow.ID == 0 {
+		t.Error("the flow not confirmed by the new event", &flow)
+	}
+	if flow.Accepted || !flow.Container.Equal(communicatedContainer) || flow.Processes[0].ID != 101 {
+		t.Error("the flow not recorded correctly", &flow)
+	}
+	if len(conntrack.Flows.List()) != 1 {
+		t.Error("the flows not listed correctly")
+	}
+	// NOTE: The flow not in conntrack table is never destroyed, so it is swept.
+	lostSocket := &proc.Socket{Protocol: layers.LayerTypeTCP, LocalIP: net.ParseIP("192.0.2.3"), RemoteIP: net.ParseIP("198.51.100.1"), LocalPort: localPort + 1, RemotePort: 443}
+	if err := conntrack.Flows.Record(lostSocket, communicatedContainer, communicatedProcesses, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := conntrack.Flows.Sweep(0); err != nil {
+		t.Fatal(err)
+	}
+	lostTuple, _ := conntrack.TupleOfSocket(lostSocket)
+	if _, exist := conntrack.Flows.Lookup(lostTuple); exist {
+		t.Error("the flow not in conntrack table not swept")
+	}
+	if _, exist := conntrack.Flows.Lookup(tuple); !exist {
+		t.Error("the flow in conntrack table swept unexpectedly")
+	}
+}
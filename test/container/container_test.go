@@ -1,6 +1,9 @@
 package container_test
 
 import (
+	"errors"
+	"net"
+	"sync"
 	"testing"
 
 	"github.com/tomo-9925/cnet/pkg/container"
@@ -31,3 +34,221 @@ func TestContainerEqual(t *testing.T) {
 		t.Error("expected fuga container equal fuga having hoge id container but actual fuga container not equal fuga having hoge id container")
 	}
 }
+
+func TestContainersIndex(t *testing.T) {
+	hoge := &container.Container{ID: "25f561f3d081", Name: "/hoge", IPAddresses: []net.IP{net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8:1::2")}}
+	fuga := &container.Container{ID: "f977b4e21a57", Name: "/fuga", IPAddresses: []net.IP{net.ParseIP("172.17.0.3")}}
+	containers := container.NewContainers([]*container.Container{hoge, fuga})
+
+	if found, exist := containers.LookupByIPAddress(net.ParseIP("2001:db8:1::2")); !exist || found != hoge {
+		t.Error("hoge container not looked up by the ipv6 address", found)
+	}
+	if found, exist := containers.LookupByIPAddress(net.ParseIP("172.17.0.3").To4()); !exist || found != fuga {
+		t.Error("fuga container not looked up by the 4 bytes ipv4 address", found)
+	}
+	if found, exist := containers.LookupByName("hoge"); !exist || found != hoge {
+		t.Error("hoge container not looked up by the name without slash", found)
+	}
+	if found, exist := containers.LookupByID("f977b4e21a57"); !exist || found != fuga {
+		t.Error("fuga container not looked up by the id", found)
+	}
+
+	// NOTE: The container having the same id is replaced, such as when its networks are changed.
+	reconnectedHoge := &container.Container{ID: hoge.ID, Name: hoge.Name, IPAddresses: []net.IP{net.ParseIP("172.18.0.2")}}
+	containers.Add(reconnectedHoge)
+	if len(containers.List) != 2 {
+		t.Error("the replaced container duplicated in the list", containers.List)
+	}
+	if _, exist := containers.LookupByIPAddress(net.ParseIP("172.17.0.2")); exist {
+		t.Error("the old ip address of the replaced container still indexed")
+	}
+	if found, exist := containers.LookupByIPAddress(net.ParseIP("172.18.0.2")); !exist || found != reconnectedHoge {
+		t.Error("the new ip address of the replaced container not indexed", found)
+	}
+
+	if removed, exist := containers.Remove(fuga.ID); !exist || removed != fuga {
+		t.Error("fuga container not removed", removed)
+	}
+	if _, exist := containers.Remove(fuga.ID); exist {
+		t.Error("fuga container removed twice")
+	}
+	if _, exist := containers.LookupByIPAddress(net.ParseIP("172.17.0.3")); exist {
+		t.Error("the ip address of the removed container still indexed")
+	}
+	if _, exist := containers.LookupByName("/fuga"); exist {
+		t.Error("the name of the removed container still indexed")
+	}
+	if len(containers.List) != 1 || containers.List[0] != reconnectedHoge {
+		t.Error("the list not consistent with the indexes", containers.List)
+	}
+}
+
+func TestContainersIndexConcurrently(t *testing.T) {
+	containers := container.NewContainers(nil)
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		waitGroup.Add(2)
+		ipAddress := net.IPv4(172, 17, 0, byte(i+2))
+		added := &container.Container{ID: ipAddress.String(), IPAddresses: []net.IP{ipAddress}}
+		go func() {
+			defer waitGroup.Done()
+			containers.Add(added)
+			containers.Remove(added.ID)
+		}()
+		go func() {
+			defer waitGroup.Done()
+			containers.LookupByIPAddress(ipAddress)
+		}()
+	}
+	waitGroup.Wait()
+	if len(containers.List) != 0 {
+		t.Error("the containers remained after removed", containers.List)
+	}
+}
+
+func TestContainersIndexSharedIPAddress(t *testing.T) {
+	// NOTE: The containers of the pod share the IP address of the pod.
+	pod := &container.Pod{ID: "a1b2c3d4", Name: "web", Namespace: "default"}
+	nginx := &container.Container{ID: "25f561f3d081", Name: "nginx", IPAddresses: []net.IP{net.ParseIP("10.244.1.5")}, Pod: pod}
+	envoy := &container.Container{ID: "f977b4e21a57", Name: "envoy", IPAddresses: []net.IP{net.ParseIP("10.244.1.5")}, Pod: pod}
+	containers := container.NewContainers([]*container.Container{nginx, envoy})
+
+	indexed, exist := containers.LookupByIPAddress(net.ParseIP("10.244.1.5"))
+	if !exist {
+		t.Fatal("the ip address of the pod not indexed")
+	}
+	containers.Remove(indexed.ID)
+	if found, exist := containers.LookupByIPAddress(net.ParseIP("10.244.1.5")); !exist || found == indexed {
+		t.Error("the ip address of the pod not indexed by the remaining container", found)
+	}
+	containers.Remove(containers.List[0].ID)
+	if _, exist := containers.LookupByIPAddress(net.ParseIP("10.244.1.5")); exist {
+		t.Error("the ip address of the removed pod still indexed")
+	}
+}
+
+func TestContainersIndexNetworkNamespace(t *testing.T) {
+	// NOTE: sidecar is started with --network container:<id> of web, and gone has stopped before it is indexed.
+	web := &container.Container{ID: "25f561f3d081", Name: "/web", Pid: 100, IPAddresses: []net.IP{net.ParseIP("172.17.0.2")}}
+	sidecar := &container.Container{ID: "f977b4e21a57", Name: "/sidecar", Pid: 200}
+	other := &container.Container{ID: "9b3c1e7a2d4f", Name: "/other", Pid: 300, IPAddresses: []net.IP{net.ParseIP("172.17.0.3")}}
+	gone := &container.Container{ID: "4a7e2c9d0b13", Name: "/gone", Pid: 400}
+	containers := container.NewContainers([]*container.Container{web, sidecar, other, gone})
+	networkNamespaces := map[int]string{100: "net:[4026532001]", 200: "net:[4026532001]", 300: "net:[4026532002]"}
+	resolved := 0
+	resolve := func(c *container.Container) (string, error) {
+		resolved++
+		networkNamespace, exist := networkNamespaces[c.Pid]
+		if !exist {
+			return "", errors.New("no such process")
+		}
+		return networkNamespace, nil
+	}
+
+	if sharing := containers.LookupSharingNetworkNamespace(web); len(sharing) != 1 || sharing[0] != web {
+		t.Error("the containers not indexed shared the network namespace", sharing)
+	}
+	containers.IndexNetworkNamespaces(resolve)
+	containers.IndexNetworkNamespaces(resolve)
+	if resolved != 4 {
+		t.Error("the network namespaces not resolved once per container", resolved)
+	}
+	if sharing := containers.LookupSharingNetworkNamespace(web); len(sharing) != 2 || sharing[0] != web || sharing[1] != sidecar {
+		t.Error("the containers sharing the network namespace not looked up", sharing)
+	}
+	if sharing := containers.LookupSharingNetworkNamespace(gone); len(sharing) != 1 || sharing[0] != gone {
+		t.Error("the container not resolved shared the network namespace", sharing)
+	}
+
+	// NOTE: The restarted sidecar joins the network namespace of other, and the removed container is not looked up.
+	restartedSidecar := &container.Container{ID: sidecar.ID, Name: sidecar.Name, Pid: 201}
+	networkNamespaces[201] = "net:[4026532002]"
+	containers.Replace(restartedSidecar)
+	containers.Remove(gone.ID)
+	containers.IndexNetworkNamespaces(resolve)
+	if resolved != 5 {
+		t.Error("the network namespace not resolved only for the replaced container", resolved)
+	}
+	if sharing := containers.LookupSharingNetworkNamespace(web); len(sharing) != 1 {
+		t.Error("the restarted container still shared the old network namespace", sharing)
+	}
+	if sharing := containers.LookupSharingNetworkNamespace(other); len(sharing) != 2 || sharing[1] != restartedSidecar {
+		t.Error("the restarted container not indexed by the new network namespace", sharing)
+	}
+}
+
+// fakeRuntime inspects the containers set by the test.
+type fakeRuntime struct {
+	inspections map[string]*container.Container
+}
+
+func (r *fakeRuntime) Name() string {
+	return "fake"
+}
+
+func (r *fakeRuntime) List() ([]*container.Container, error) {
+	return nil, nil
+}
+
+func (r *fakeRuntime) Inspect(cid string) (*container.Container, error) {
+	inspection, exist := r.inspections[cid]
+	if !exist {
+		return nil, errors.New("no such container")
+	}
+	return inspection, nil
+}
+
+func (r *fakeRuntime) Events(done <-chan struct{}) (<-chan container.Event, <-chan error) {
+	return nil, nil
+}
+
+func (r *fakeRuntime) DNSHelperPID() int {
+	return 0
+}
+
+func (r *fakeRuntime) ForwardChain() string {
+	return "FORWARD"
+}
+
+func TestContainersUpdate(t *testing.T) {
+	hoge := &container.Container{ID: "25f561f3d081", Name: "/hoge", Pid: 100, IPAddresses: []net.IP{net.ParseIP("172.17.0.2")}}
+	fuga := &container.Container{ID: "f977b4e21a57", Name: "/fuga", Pid: 200, IPAddresses: []net.IP{net.ParseIP("172.17.0.3")}}
+	containers := container.NewContainers([]*container.Container{hoge, fuga})
+
+	// NOTE: hoge is connected to the network and renamed, fuga has died, and piyo is not added yet.
+	connectedHoge := &container.Container{ID: hoge.ID, Name: "/renamed", Pid: 100, IPAddresses: []net.IP{net.ParseIP("172.17.0.2"), net.ParseIP("172.18.0.2")}}
+	container.CurrentRuntime = &fakeRuntime{inspections: map[string]*container.Container{
+		hoge.ID:        connectedHoge,
+		fuga.ID:        {ID: fuga.ID, Name: fuga.Name},
+		"9b3c1e7a2d4f": {ID: "9b3c1e7a2d4f", Name: "/piyo", Pid: 300, IPAddresses: []net.IP{net.ParseIP("172.17.0.4")}},
+	}}
+	defer func() { container.CurrentRuntime = nil }()
+
+	if updated, err := containers.UpdateContainer(hoge.ID); err != nil || !updated {
+		t.Fatal("the connected container not updated", err)
+	}
+	if found, exist := containers.LookupByIPAddress(net.ParseIP("172.18.0.2")); !exist || found != connectedHoge {
+		t.Error("the ip address of the connected network not indexed", found)
+	}
+	if found, exist := containers.LookupByName("renamed"); !exist || found != connectedHoge {
+		t.Error("the renamed container not looked up by the new name", found)
+	}
+	if _, exist := containers.LookupByName("hoge"); exist {
+		t.Error("the old name of the renamed container still indexed")
+	}
+	if updated, err := containers.UpdateContainer(fuga.ID); err != nil || updated {
+		t.Error("the dead container updated", err)
+	}
+	if found, exist := containers.LookupByID(fuga.ID); !exist || found != fuga {
+		t.Error("the inspection of the dead container replaced", found)
+	}
+	if updated, err := containers.UpdateContainer("9b3c1e7a2d4f"); err != nil || updated {
+		t.Error("the container not added updated", err)
+	}
+	if len(containers.List) != 2 {
+		t.Error("the container not added added by the update", containers.List)
+	}
+	if containers.Replace(&container.Container{ID: "9b3c1e7a2d4f"}) {
+		t.Error("the container not added replaced")
+	}
+}
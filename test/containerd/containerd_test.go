@@ -0,0 +1,249 @@
+package containerd_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	apievents "github.com/containerd/containerd/api/events"
+	containers "github.com/containerd/containerd/api/services/containers/v1"
+	events "github.com/containerd/containerd/api/services/events/v1"
+	tasks "github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/containerd/containerd/api/types"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/containerd"
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"github.com/tomo-9925/cnet/test/internal/fakegrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	// the task of the fixture of proc filesystem
+	fixtureRoot          string = "../proc/testdata"
+	fixtureContainerID   string = "4e3a2b1c"
+	fixtureContainerName string = "cnet_fixture_test"
+	fixturePid           uint32 = 100
+)
+
+// fakeContainerd serves the tasks and the events of containerd API with the task of the fixture.
+type fakeContainerd struct {
+	tasks.UnimplementedTasksServer
+	events.UnimplementedEventsServer
+	filters chan []string
+}
+
+func checkNamespace(ctx context.Context) error {
+	if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("containerd-namespace")) != 1 || md.Get("containerd-namespace")[0] != containerd.DefaultNamespace {
+		return status.Error(codes.InvalidArgument, "invalid namespace")
+	}
+	return nil
+}
+
+// taskEnvelope wraps the task event as containerd does, whose type URL is the name of the message.
+func taskEnvelope(namespace, topic string, event proto.Message) *types.Envelope {
+	value, _ := proto.Marshal(event)
+	return &types.Envelope{
+		Namespace: namespace,
+		Topic:     topic,
+		Event:     &anypb.Any{TypeUrl: string(proto.MessageName(event)), Value: value},
+	}
+}
+
+func (f *fakeContainerd) List(ctx context.Context, request *tasks.ListTasksRequest) (*tasks.ListTasksResponse, error) {
+	if err := checkNamespace(ctx); err != nil {
+		return nil, err
+	}
+	return &tasks.ListTasksResponse{Tasks: []*task.Process{
+		{ContainerID: fixtureContainerID, Pid: fixturePid, Status: task.Status_RUNNING},
+		{ContainerID: "9a8b7c6d", Status: task.Status_STOPPED},
+	}}, nil
+}
+
+func (f *fakeContainerd) Get(ctx context.Context, request *tasks.GetRequest) (*tasks.GetResponse, error) {
+	if err := checkNamespace(ctx); err != nil {
+		return nil, err
+	}
+	if request.GetContainerID() != fixtureContainerID {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	return &tasks.GetResponse{Process: &task.Process{ContainerID: fixtureContainerID, Pid: fixturePid, Status: task.Status_RUNNING}}, nil
+}
+
+// fakeContainers serves the containers service, whose Get conflicts with the one of the tasks service.
+type fakeContainers struct {
+	containers.UnimplementedContainersServer
+}
+
+func (f fakeContainers) Get(ctx context.Context, request *containers.GetContainerRequest) (*containers.GetContainerResponse, error) {
+	if err := checkNamespace(ctx); err != nil {
+		return nil, err
+	}
+	return &containers.GetContainerResponse{Container: &containers.Container{
+		ID:     request.GetID(),
+		Labels: map[string]string{"nerdctl/name": fixtureContainerName, "app": "web"},
+	}}, nil
+}
+
+func (f *fakeContainerd) Subscribe(request *events.SubscribeRequest, stream events.Events_SubscribeServer) error {
+	if err := checkNamespace(stream.Context()); err != nil {
+		return err
+	}
+	f.filters <- request.GetFilters()
+	// NOTE: The start in the other namespace and the exit of the exec process are not notified.
+	for _, envelope := range []*types.Envelope{
+		taskEnvelope("k8s.io", "/tasks/start", &apievents.TaskStart{ContainerID: "ffffffff"}),
+		taskEnvelope(containerd.DefaultNamespace, "/tasks/start", &apievents.TaskStart{ContainerID: fixtureContainerID}),
+		taskEnvelope(containerd.DefaultNamespace, "/tasks/exit", &apievents.TaskExit{ContainerID: fixtureContainerID, ID: "exec1"}),
+		taskEnvelope(containerd.DefaultNamespace, "/tasks/exit", &apievents.TaskExit{ContainerID: fixtureContainerID, ID: fixtureContainerID}),
+	} {
+		if err := stream.Send(envelope); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func (f *fakeContainerd) register(server *grpc.Server) {
+	tasks.RegisterTasksServer(server, f)
+	containers.RegisterContainersServer(server, fakeContainers{})
+	events.RegisterEventsServer(server, f)
+}
+
+// useFixture reads the proc filesystem of the fixture until the test ends.
+func useFixture(t *testing.T) {
+	previous := proc.SetRoot(fixtureRoot)
+	t.Cleanup(func() { proc.SetRoot(previous) })
+}
+
+func startFakeContainerd(t *testing.T) (address string, fake *fakeContainerd, server *grpc.Server) {
+	address = fakegrpc.Address(t, "containerd.sock")
+	fake = &fakeContainerd{filters: make(chan []string, 1)}
+	server = fakegrpc.Serve(t, address, fake.register)
+	return
+}
+
+func TestContainerdRuntime(t *testing.T) {
+	useFixture(t)
+	address, _, _ := startFakeContainerd(t)
+
+	runtime, err := containerd.NewRuntime(address, containerd.DefaultNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspections, err := runtime.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspections) != 1 {
+		t.Fatal("only the running task not listed", inspections)
+	}
+	inspection := inspections[0]
+	if inspection.ID != fixtureContainerID || inspection.Name != fixtureContainerName || inspection.Pid != int(fixturePid) || inspection.HostNetwork {
+		t.Error("the task not inspected correctly", inspection)
+	}
+	if !inspection.HasLabels(map[string]string{"app": "web"}) {
+		t.Error("the labels not inspected correctly", inspection.Labels)
+	}
+	if len(inspection.IPAddresses) != 2 || !inspection.IPAddresses[0].Equal(net.ParseIP("172.17.0.2")) || !inspection.IPAddresses[1].Equal(net.ParseIP("2001:db8:1::2")) {
+		t.Error("the ip addresses not retrieved correctly", inspection.IPAddresses)
+	}
+
+	inspection, err = runtime.Inspect(fixtureContainerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspection.Name != fixtureContainerName {
+		t.Error("the task not inspected correctly", inspection)
+	}
+	if _, err = runtime.Inspect("9a8b7c6d"); status.Code(err) != codes.NotFound {
+		t.Error("the status of the call not returned", err)
+	}
+}
+
+func TestContainerdEvents(t *testing.T) {
+	address, fake, server := startFakeContainerd(t)
+
+	runtime, err := containerd.NewRuntime(address, containerd.DefaultNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	eventCh, errCh := runtime.Events(done)
+	select {
+	case filters := <-fake.filters:
+		if len(filters) != 4 {
+			t.Error("the topics not filtered", filters)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the events not subscribed")
+	}
+	for _, expected := range []container.Event{{Action: container.ActionRun, ID: fixtureContainerID}, {Action: container.ActionKill, ID: fixtureContainerID}} {
+		select {
+		case event := <-eventCh:
+			if event != expected {
+				t.Error("the event not notified correctly", event, expected)
+			}
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("the event not notified", expected)
+		}
+	}
+
+	server.Stop()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("the closed connection not notified")
+		}
+	case event := <-eventCh:
+		t.Error("the unexpected event notified", event)
+	case <-time.After(time.Second):
+		t.Error("the closed connection not notified")
+	}
+}
+
+func TestContainerdReconnect(t *testing.T) {
+	useFixture(t)
+	address, fake, server := startFakeContainerd(t)
+
+	runtime, err := containerd.NewRuntime(address, containerd.DefaultNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	eventCh, errCh := runtime.Events(done)
+	<-fake.filters
+	server.Stop()
+	// NOTE: The events sent before the stop are received until the error.
+	for closed := false; !closed; {
+		select {
+		case <-eventCh:
+		case <-errCh:
+			closed = true
+		case <-time.After(time.Second):
+			t.Fatal("the closed connection not notified")
+		}
+	}
+
+	// NOTE: The restarted containerd listens on the same socket, which is dialed again by the next call.
+	fakegrpc.Serve(t, address, fake.register)
+
+	inspections, err := runtime.List()
+	if err != nil {
+		t.Fatal("the connection not dialed again", err)
+	}
+	if len(inspections) != 1 || inspections[0].ID != fixtureContainerID {
+		t.Error("the task not listed after the reconnection", inspections)
+	}
+}
@@ -0,0 +1,203 @@
+package cri_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/cri"
+	"github.com/tomo-9925/cnet/test/internal/fakegrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// the pods and the containers of the fake CRI
+type fakePod struct {
+	id, name, namespace string
+	labels              map[string]string
+	ipAddresses         []string
+	hostNetwork         bool
+}
+
+type fakeContainer struct {
+	id, name string
+	pod      *fakePod
+	pid      int
+}
+
+var (
+	webPod         *fakePod                  = &fakePod{id: "a1b2c3d4", name: "web-5d8f", namespace: "default", labels: map[string]string{"app": "web"}, ipAddresses: []string{"10.244.1.5", "fd00::5"}}
+	nodePod        *fakePod                  = &fakePod{id: "b2c3d4e5", name: "node-exporter-x7k2", namespace: "monitoring", labels: map[string]string{"app": "node-exporter"}, hostNetwork: true}
+	fakeContainers map[string]*fakeContainer = map[string]*fakeContainer{
+		"4e3a2b1c": {id: "4e3a2b1c", name: "nginx", pod: webPod, pid: 100},
+		"5d6e7f80": {id: "5d6e7f80", name: "envoy", pod: webPod, pid: 110},
+		"9a8b7c6d": {id: "9a8b7c6d", name: "node-exporter", pod: nodePod, pid: 120},
+	}
+)
+
+// fakeCRI serves the runtime service of CRI with the pods and the containers.
+type fakeCRI struct {
+	runtimeapi.UnimplementedRuntimeServiceServer
+}
+
+func (c *fakeContainer) container() *runtimeapi.Container {
+	return &runtimeapi.Container{
+		Id:           c.id,
+		PodSandboxId: c.pod.id,
+		Metadata:     &runtimeapi.ContainerMetadata{Name: c.name},
+		State:        runtimeapi.ContainerState_CONTAINER_RUNNING,
+		Labels:       map[string]string{"io.kubernetes.container.name": c.name},
+	}
+}
+
+func (p *fakePod) status() *runtimeapi.PodSandboxStatus {
+	status := &runtimeapi.PodSandboxStatus{
+		Id:       p.id,
+		Metadata: &runtimeapi.PodSandboxMetadata{Name: p.name, Namespace: p.namespace},
+		Network:  &runtimeapi.PodSandboxNetworkStatus{},
+		Linux:    &runtimeapi.LinuxPodSandboxStatus{Namespaces: &runtimeapi.Namespace{Options: &runtimeapi.NamespaceOption{}}},
+		Labels:   p.labels,
+	}
+	for i, ipAddress := range p.ipAddresses {
+		if i == 0 {
+			status.Network.Ip = ipAddress
+		} else {
+			status.Network.AdditionalIps = append(status.Network.AdditionalIps, &runtimeapi.PodIP{Ip: ipAddress})
+		}
+	}
+	if p.hostNetwork {
+		status.Linux.Namespaces.Options.Network = runtimeapi.NamespaceMode_NODE
+	}
+	return status
+}
+
+func (f *fakeCRI) ListContainers(ctx context.Context, request *runtimeapi.ListContainersRequest) (*runtimeapi.ListContainersResponse, error) {
+	response := &runtimeapi.ListContainersResponse{}
+	for _, id := range []string{"4e3a2b1c", "5d6e7f80", "9a8b7c6d"} {
+		if filterID := request.GetFilter().GetId(); filterID == "" || filterID == id {
+			response.Containers = append(response.Containers, fakeContainers[id].container())
+		}
+	}
+	return response, nil
+}
+
+func (f *fakeCRI) ContainerStatus(ctx context.Context, request *runtimeapi.ContainerStatusRequest) (*runtimeapi.ContainerStatusResponse, error) {
+	fakeContainer, exist := fakeContainers[request.GetContainerId()]
+	if !exist || !request.GetVerbose() {
+		return nil, status.Error(codes.NotFound, "container not found")
+	}
+	return &runtimeapi.ContainerStatusResponse{
+		Status: &runtimeapi.ContainerStatus{Id: fakeContainer.id},
+		Info:   map[string]string{"info": fmt.Sprintf(`{"sandboxID":%q,"pid":%d}`, fakeContainer.pod.id, fakeContainer.pid)},
+	}, nil
+}
+
+func (f *fakeCRI) PodSandboxStatus(ctx context.Context, request *runtimeapi.PodSandboxStatusRequest) (*runtimeapi.PodSandboxStatusResponse, error) {
+	for _, pod := range []*fakePod{webPod, nodePod} {
+		if pod.id == request.GetPodSandboxId() {
+			return &runtimeapi.PodSandboxStatusResponse{Status: pod.status()}, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "pod sandbox not found")
+}
+
+func (f *fakeCRI) GetContainerEvents(request *runtimeapi.GetEventsRequest, stream runtimeapi.RuntimeService_GetContainerEventsServer) error {
+	// NOTE: The created container is not notified.
+	for _, eventType := range []runtimeapi.ContainerEventType{
+		runtimeapi.ContainerEventType_CONTAINER_CREATED_EVENT,
+		runtimeapi.ContainerEventType_CONTAINER_STARTED_EVENT,
+		runtimeapi.ContainerEventType_CONTAINER_STOPPED_EVENT,
+	} {
+		if err := stream.Send(&runtimeapi.ContainerEventResponse{ContainerId: "4e3a2b1c", ContainerEventType: eventType}); err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func startFakeCRI(t *testing.T) (address string) {
+	address = fakegrpc.Address(t, "cri.sock")
+	fakegrpc.Serve(t, address, func(server *grpc.Server) {
+		runtimeapi.RegisterRuntimeServiceServer(server, &fakeCRI{})
+	})
+	return
+}
+
+func TestCRIRuntime(t *testing.T) {
+	address := startFakeCRI(t)
+
+	runtime, err := cri.NewRuntime(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspections, err := runtime.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspections) != len(fakeContainers) {
+		t.Fatal("the containers of the pods not listed", inspections)
+	}
+	for _, inspection := range inspections {
+		expected := fakeContainers[inspection.ID]
+		if inspection.Name != expected.name || inspection.Pid != expected.pid || inspection.HostNetwork != expected.pod.hostNetwork {
+			t.Error("the container not inspected correctly", inspection)
+		}
+		if inspection.Pod == nil || inspection.Pod.ID != expected.pod.id || inspection.Pod.Name != expected.pod.name || inspection.Pod.Namespace != expected.pod.namespace || !inspection.Pod.HasLabels(expected.pod.labels) {
+			t.Error("the pod of the container not inspected correctly", inspection, inspection.Pod)
+		}
+		if len(inspection.IPAddresses) != len(expected.pod.ipAddresses) {
+			t.Error("the ip addresses of the pod not inspected correctly", inspection.IPAddresses)
+			continue
+		}
+		for i, ipAddress := range inspection.IPAddresses {
+			if !ipAddress.Equal(net.ParseIP(expected.pod.ipAddresses[i])) {
+				t.Error("the ip addresses of the pod not inspected correctly", inspection.IPAddresses)
+			}
+		}
+	}
+	// NOTE: The containers of the pod share the pod.
+	if inspections[0].Pod != inspections[1].Pod {
+		t.Error("the pod not shared by the containers", inspections[0].Pod, inspections[1].Pod)
+	}
+
+	inspection, err := runtime.Inspect("5d6e7f80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspection.Name != "envoy" || inspection.Pid != 110 || inspection.Pod.Name != webPod.name {
+		t.Error("the container not inspected correctly", inspection)
+	}
+	if _, err := runtime.Inspect("ffffffff"); err == nil {
+		t.Error("the nonexistent container inspected")
+	}
+}
+
+func TestCRIEvents(t *testing.T) {
+	address := startFakeCRI(t)
+
+	runtime, err := cri.NewRuntime(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	eventCh, errCh := runtime.Events(done)
+	for _, expected := range []container.Event{{Action: container.ActionRun, ID: "4e3a2b1c"}, {Action: container.ActionKill, ID: "4e3a2b1c"}} {
+		select {
+		case event := <-eventCh:
+			if event != expected {
+				t.Error("the event not notified correctly", event, expected)
+			}
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("the event not notified", expected)
+		}
+	}
+}
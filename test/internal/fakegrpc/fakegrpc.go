@@ -0,0 +1,31 @@
+// Package fakegrpc serves the fake gRPC services of the container runtimes for the tests.
+package fakegrpc
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// Address returns the unix socket of the name in the temporary directory of the test.
+func Address(t *testing.T, name string) string {
+	return filepath.Join(t.TempDir(), name)
+}
+
+// Serve listens on the unix socket of the address and serves the services registered by register.
+// The socket left by the stopped server is removed, so that the restarted runtime listens on the same address.
+func Serve(t *testing.T, address string, register func(*grpc.Server)) (server *grpc.Server) {
+	os.Remove(address)
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = grpc.NewServer()
+	register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return
+}
@@ -0,0 +1,113 @@
+package network_test
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/tomo-9925/cnet/pkg/network"
+)
+
+// nfqueueRuleCount is the number of the rules of NFQueue rule per family.
+const nfqueueRuleCount int = 5
+
+// testIPAddresses are the IP addresses of the protected containers, each of which has two jump rules.
+var testIPAddresses []net.IP = []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}
+
+// testHostPorts are the ports which the protected container of the host network listens on.
+var testHostPorts []network.HostPort = []network.HostPort{{Protocol: "tcp", Port: 8080}, {Protocol: "udp", Port: 53}}
+
+func TestBackendsNFQueueRule(t *testing.T) {
+	for _, backend := range network.Backends {
+		backend := backend
+		t.Run(backend.Name(), func(t *testing.T) {
+			var testChainName string
+			for _, candidate := range []string{chainName, "FORWARD"} {
+				if backend.Available(candidate) {
+					testChainName = candidate
+					break
+				}
+			}
+			if testChainName == "" {
+				t.Skip("the backend not available")
+			}
+			if _, err := backend.RemoveOwnedRules(testChainName); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, testProtocol := range []string{protocol, "tcp"} {
+				err := backend.InsertNFQueueRule(testChainName, testProtocol, ruleNum, queueNum, queueCount, queueBypass)
+				if errors.Is(err, syscall.ENOENT) {
+					t.Skip("the queue of the backend not available")
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				// NOTE: The rules inserted before are replaced, so the insertion is idempotent.
+				err = backend.InsertNFQueueRule(testChainName, testProtocol, ruleNum, queueNum, queueCount, queueBypass)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !backend.ExistsNFQueueRule(testChainName, testProtocol, queueNum, queueCount, queueBypass) {
+					t.Fatal("couldn't insert nfqueue rule")
+				}
+			}
+
+			// NOTE: The jump rules set before are replaced.
+			for _, testCase := range []struct {
+				ipAddresses []net.IP
+				hostCgroups []string
+				hostPorts   []network.HostPort
+			}{
+				{testIPAddresses[:1], nil, nil},
+				{testIPAddresses, nil, testHostPorts},
+				{testIPAddresses, nil, nil},
+			} {
+				err := backend.SetJumpRules(testChainName, ruleNum, testCase.ipAddresses, testCase.hostCgroups, testCase.hostPorts)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// NOTE: The rules left by crashed cnet are found by the tag.
+			removed, err := backend.RemoveOwnedRules(testChainName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed < nfqueueRuleCount+2 {
+				t.Fatalf("%d rules removed", removed)
+			}
+			if backend.ExistsNFQueueRule(testChainName, protocol, queueNum, queueCount, queueBypass) {
+				t.Fatal("couldn't remove the rules tagged by cnet")
+			}
+
+			err = backend.InsertNFQueueRule(testChainName, protocol, ruleNum, queueNum, queueCount, queueBypass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = backend.DeleteNFQueueRule(testChainName, protocol, queueNum, queueCount, queueBypass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backend.ExistsNFQueueRule(testChainName, protocol, queueNum, queueCount, queueBypass) {
+				t.Fatal("couldn't delete nfqueue rule")
+			}
+		})
+	}
+}
+
+func TestSelectBackend(t *testing.T) {
+	for _, backend := range network.Backends {
+		selected, err := network.SelectBackend(backend.Name(), chainName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selected != backend {
+			t.Fatalf("%s selected instead of %s", selected.Name(), backend.Name())
+		}
+	}
+	if _, err := network.SelectBackend("ebtables", chainName); err == nil {
+		t.Fatal("unsupported backend selected")
+	}
+}
@@ -7,25 +7,27 @@ import (
 )
 
 const (
-	chainName string = "DOCKER-USER"
-	ruleNum   uint16 = 1
-	protocol  string = "all"
-	queueNum  uint16 = 2
+	chainName   string = "DOCKER-USER"
+	ruleNum     uint16 = 1
+	protocol    string = "all"
+	queueNum    uint16 = 2
+	queueCount  uint16 = 4
+	queueBypass bool   = true
 )
 
 func TestNFQueueRule(t *testing.T) {
-	err := network.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum)
+	err := network.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum, queueCount, queueBypass)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !network.ExistsNFQueueRule(chainName, protocol, queueNum) {
+	if !network.ExistsNFQueueRule(chainName, protocol, queueNum, queueCount, queueBypass) {
 		t.Fatal("couldn't insert nfqueue rule")
 	}
-	err = network.DeleteNFQueueRule(chainName, protocol, queueNum)
+	err = network.DeleteNFQueueRule(chainName, protocol, queueNum, queueCount, queueBypass)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if network.ExistsNFQueueRule(chainName, protocol, queueNum) {
+	if network.ExistsNFQueueRule(chainName, protocol, queueNum, queueCount, queueBypass) {
 		t.Fatal("couldn't delete nfqueue rule")
 	}
 }
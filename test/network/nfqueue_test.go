@@ -0,0 +1,24 @@
+package network_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tomo-9925/cnet/pkg/network"
+)
+
+func TestReadNFQueueStatistics(t *testing.T) {
+	statistics, err := network.ReadNFQueueStatistics()
+	if os.IsNotExist(err) {
+		t.Skip("nfnetlink_queue not loaded")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for queueNum, queueStatistics := range statistics {
+		if queueStatistics.QueueNum != queueNum {
+			t.Fatalf("the statistics of queue %d stored as queue %d", queueStatistics.QueueNum, queueNum)
+		}
+		t.Log(queueStatistics)
+	}
+}
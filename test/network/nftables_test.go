@@ -0,0 +1,40 @@
+package network_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/tomo-9925/cnet/pkg/network"
+)
+
+func TestNFTablesNFQueueRule(t *testing.T) {
+	backend := network.NFTablesNative
+	if !backend.Available(chainName) {
+		t.Skip("nftables not available")
+	}
+	for _, testProtocol := range []string{protocol, "tcp"} {
+		err := backend.InsertNFQueueRule(chainName, testProtocol, ruleNum, queueNum, queueCount, queueBypass)
+		if errors.Is(err, syscall.ENOENT) {
+			t.Skip("the queue expression of nftables not available")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NOTE: The rules are replaced, so the insertion is idempotent.
+		err = backend.InsertNFQueueRule(chainName, testProtocol, ruleNum, queueNum, queueCount, queueBypass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !backend.ExistsNFQueueRule(chainName, testProtocol, queueNum, queueCount, queueBypass) {
+			t.Fatal("couldn't insert nfqueue rule")
+		}
+		err = backend.DeleteNFQueueRule(chainName, testProtocol, queueNum, queueCount, queueBypass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if backend.ExistsNFQueueRule(chainName, testProtocol, queueNum, queueCount, queueBypass) {
+			t.Fatal("couldn't delete nfqueue rule")
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package network_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tomo-9925/cnet/pkg/netlink"
+	"github.com/tomo-9925/cnet/pkg/network"
+)
+
+// xtablesTestdataPath has the filter tables read from the kernel in the new network namespace, and the replacements of them.
+// The replacements were accepted by the kernel of x86_64.
+const xtablesTestdataPath string = "testdata/xtables"
+
+// testHostCgroup is the cgroup of the protected container of the host network, which existed when the replacements were accepted.
+const testHostCgroup string = "/system.slice/docker-7a8b9c0d.scope"
+
+func readHex(t *testing.T, name string) []byte {
+	rawData, err := ioutil.ReadFile(filepath.Join(xtablesTestdataPath, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := hex.DecodeString(strings.Join(strings.Fields(string(rawData)), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestXTablesReplacement(t *testing.T) {
+	if netlink.NativeEndian != binary.LittleEndian {
+		t.Skip("the replacements are of the little-endian host")
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		family := family
+		t.Run(family, func(t *testing.T) {
+			replacement, err := network.IPTablesLegacy.NFQueueReplacement(family, readHex(t, family+"_info.hex"), readHex(t, family+"_entries.hex"),
+				"FORWARD", protocol, queueNum, queueCount, queueBypass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := readHex(t, family+"_nfqueue.hex"); !bytes.Equal(replacement, expected) {
+				t.Errorf("the replacement of nfqueue rule differs\n%s\nexpected\n%s", hex.Dump(replacement), hex.Dump(expected))
+			}
+
+			// NOTE: The jump rules are set in the table having NFQueue rule.
+			replacement, err = network.IPTablesLegacy.JumpReplacement(family, readHex(t, family+"_nfqueue_info.hex"), readHex(t, family+"_nfqueue_entries.hex"),
+				"FORWARD", ruleNum, testIPAddresses, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := readHex(t, family+"_jump.hex"); !bytes.Equal(replacement, expected) {
+				t.Errorf("the replacement of the jump rules differs\n%s\nexpected\n%s", hex.Dump(replacement), hex.Dump(expected))
+			}
+
+			// NOTE: The packets of the container of the host network are matched by its cgroup in OUTPUT,
+			// and by the ports it listens on in INPUT, where the packet of the new connection is not attached to the socket.
+			replacement, err = network.IPTablesLegacy.JumpReplacement(family, readHex(t, family+"_nfqueue_info.hex"), readHex(t, family+"_nfqueue_entries.hex"),
+				"OUTPUT", ruleNum, testIPAddresses, []string{testHostCgroup}, testHostPorts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := readHex(t, family+"_cgroup_jump.hex"); !bytes.Equal(replacement, expected) {
+				t.Errorf("the replacement of the jump rules of the cgroup differs\n%s\nexpected\n%s", hex.Dump(replacement), hex.Dump(expected))
+			}
+			replacement, err = network.IPTablesLegacy.JumpReplacement(family, readHex(t, family+"_nfqueue_info.hex"), readHex(t, family+"_nfqueue_entries.hex"),
+				"INPUT", ruleNum, testIPAddresses, []string{testHostCgroup}, testHostPorts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := readHex(t, family+"_port_jump.hex"); !bytes.Equal(replacement, expected) {
+				t.Errorf("the replacement of the jump rules of the ports differs\n%s\nexpected\n%s", hex.Dump(replacement), hex.Dump(expected))
+			}
+		})
+	}
+	if _, err := network.IPTablesLegacy.JumpReplacement("ipv4", readHex(t, "ipv4_info.hex"), readHex(t, "ipv4_entries.hex"), "FORWARD", ruleNum, testIPAddresses, nil, nil); err == nil {
+		t.Error("the jump rules set without the chain of the containers")
+	}
+}
@@ -0,0 +1,134 @@
+package podman_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/podman"
+)
+
+const (
+	// the container of the compatible api
+	testContainerID   string = "7a8b9c0d"
+	testContainerName string = "cnet_podman_test"
+)
+
+// fakePodman serves the compatible api of podman with the container of netavark.
+func fakePodman(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/containers/json"):
+		json.NewEncoder(w).Encode([]map[string]interface{}{{"Id": testContainerID, "Names": []string{"/" + testContainerName}}})
+	case strings.HasSuffix(r.URL.Path, "/containers/"+testContainerID+"/json"):
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Id":         testContainerID,
+			"Name":       "/" + testContainerName,
+			"State":      map[string]interface{}{"Running": true, "Pid": 130},
+			"HostConfig": map[string]interface{}{"NetworkMode": "bridge"},
+			"Config":     map[string]interface{}{"Labels": map[string]string{"app": "web"}},
+			"NetworkSettings": map[string]interface{}{"Networks": map[string]interface{}{
+				"podman": map[string]interface{}{"IPAddress": "10.88.0.2", "GlobalIPv6Address": ""},
+			}},
+		})
+	case strings.HasSuffix(r.URL.Path, "/events"):
+		// NOTE: podman before v4 notifies "died" instead of "die".
+		// The network events have the ID of the network as the actor and the ID of the container as its attribute.
+		encoder := json.NewEncoder(w)
+		for _, event := range []map[string]interface{}{
+			{"Type": "container", "Action": "start", "id": testContainerID, "Actor": map[string]interface{}{"ID": testContainerID}},
+			{"Type": "network", "Action": "connect", "Actor": map[string]interface{}{"ID": "2f259bab93aa", "Attributes": map[string]string{"container": testContainerID, "name": "backend"}}},
+			{"Type": "container", "Action": "rename", "id": testContainerID, "Actor": map[string]interface{}{"ID": testContainerID, "Attributes": map[string]string{"oldName": testContainerName}}},
+			{"Type": "container", "Action": "died", "id": testContainerID, "Actor": map[string]interface{}{"ID": testContainerID}},
+		} {
+			encoder.Encode(event)
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func startFakePodman(t *testing.T) (address string, server *http.Server) {
+	dir, err := ioutil.TempDir("", "podman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	address = filepath.Join(dir, "podman.sock")
+	listener, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = &http.Server{Handler: http.HandlerFunc(fakePodman)}
+	go server.Serve(listener)
+	return
+}
+
+func TestPodmanRuntime(t *testing.T) {
+	address, server := startFakePodman(t)
+	defer server.Close()
+
+	runtime, err := podman.NewRuntime(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.Name() != podman.RuntimeName || runtime.ForwardChain() != "FORWARD" || runtime.DNSHelperPID() != 0 {
+		t.Error("the runtime not podman", runtime.Name(), runtime.ForwardChain(), runtime.DNSHelperPID())
+	}
+	inspections, err := runtime.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inspections) != 1 {
+		t.Fatal("the containers not listed", inspections)
+	}
+	inspection := inspections[0]
+	if inspection.ID != testContainerID || inspection.Pid != 130 || inspection.HostNetwork || !inspection.HasLabels(map[string]string{"app": "web"}) {
+		t.Error("the container not inspected correctly", inspection)
+	}
+	if !inspection.Equal(&container.Container{Name: "/" + testContainerName}) {
+		t.Error("the name of the container not inspected correctly", inspection.Name)
+	}
+	if len(inspection.IPAddresses) != 1 || !inspection.IPAddresses[0].Equal(net.ParseIP("10.88.0.2")) {
+		t.Error("the ip addresses of netavark not inspected correctly", inspection.IPAddresses)
+	}
+}
+
+func TestPodmanEvents(t *testing.T) {
+	address, server := startFakePodman(t)
+	defer server.Close()
+
+	runtime, err := podman.NewRuntime(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	eventCh, errCh := runtime.Events(done)
+	for _, expected := range []container.Event{
+		{Action: container.ActionRun, ID: testContainerID},
+		{Action: container.ActionUpdate, ID: testContainerID},
+		{Action: container.ActionUpdate, ID: testContainerID},
+		{Action: container.ActionKill, ID: testContainerID},
+	} {
+		select {
+		case event := <-eventCh:
+			if event != expected {
+				t.Error("the event not notified correctly", event, expected)
+			}
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("the event not notified", expected)
+		}
+	}
+}
@@ -82,3 +82,105 @@ func TestParseSecurityPolicy(t *testing.T) {
 	}
 }
 
+
+func TestParseSharedSocket(t *testing.T) {
+	for sharedSocket, expected := range map[string]policy.SharedSocketMatch{"": policy.MatchAnyOwner, "any": policy.MatchAnyOwner, "all": policy.MatchAllOwners, "some": -1} {
+		rawPolicies := fmt.Sprintf(
+`policies:
+  - container:
+      name: "%s"
+    shared_socket: "%s"
+`,
+			testContainerName, sharedSocket)
+
+		tmpPolicyFile, err := ioutil.TempFile("", "testPolicy.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpPolicyFile.Name())
+		if _, err := tmpPolicyFile.WriteString(rawPolicies); err != nil {
+			t.Fatal(err)
+		}
+		tmpPolicyFile.Close()
+
+		parsedPolicies, err := policy.Read(tmpPolicyFile.Name())
+		if expected < 0 {
+			if err == nil {
+				t.Error("unsupported shared_socket parsed", sharedSocket)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsedPolicies.List[0].SharedSocket != expected {
+			t.Error("shared_socket not parsed correctly", sharedSocket, parsedPolicies.List[0].SharedSocket)
+		}
+	}
+}
+
+func TestParseContainerLabels(t *testing.T) {
+	rawPolicies :=
+`policies:
+  - container:
+      labels:
+        app: "web"
+        nerdctl/name: "cnet_web_test"
+`
+	tmpPolicyFile, err := ioutil.TempFile("", "testPolicy.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpPolicyFile.Name())
+	if _, err := tmpPolicyFile.WriteString(rawPolicies); err != nil {
+		t.Fatal(err)
+	}
+	tmpPolicyFile.Close()
+
+	parsedPolicies, err := policy.Read(tmpPolicyFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLabels := map[string]string{"app": "web", "nerdctl/name": "cnet_web_test"}
+	if diff := cmp.Diff(parsedPolicies.List[0].Container.Labels, expectedLabels); diff != "" {
+		t.Error("labels differs:", diff)
+	}
+}
+
+func TestParsePodSelector(t *testing.T) {
+	rawPolicies :=
+`policies:
+  - pod:
+      namespace: "default"
+      labels:
+        app: "web"
+    container:
+      name: "nginx"
+  - container:
+      name: "cnet_netcat_test"
+`
+	tmpPolicyFile, err := ioutil.TempFile("", "testPolicy.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpPolicyFile.Name())
+	if _, err := tmpPolicyFile.WriteString(rawPolicies); err != nil {
+		t.Fatal(err)
+	}
+	tmpPolicyFile.Close()
+
+	parsedPolicies, err := policy.Read(tmpPolicyFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPod := &container.Pod{Namespace: "default", Labels: map[string]string{"app": "web"}}
+	if diff := cmp.Diff(parsedPolicies.List[0].Pod, expectedPod); diff != "" {
+		t.Error("pod differs:", diff)
+	}
+	if parsedPolicies.List[0].Container.Name != "nginx" {
+		t.Error("container of the pod not parsed correctly", parsedPolicies.List[0].Container)
+	}
+	if parsedPolicies.List[1].Pod != nil {
+		t.Error("pod parsed for the policy of the container", parsedPolicies.List[1].Pod)
+	}
+}
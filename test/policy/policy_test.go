@@ -7,6 +7,7 @@ import (
 
 	"github.com/google/gopacket/layers"
 	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/policy"
 	"github.com/tomo-9925/cnet/pkg/proc"
 )
 
@@ -51,3 +52,100 @@ func TestSocketIsDefined(t *testing.T) {
 	}
 }
 
+
+func TestSharedSocketIsDefined(t *testing.T) {
+	var (
+		testCommunicatedContainer *container.Container = &container.Container{ID: "49dae530fd5fee674a6b0d3da89a380fc93746095e7eca0f1b70188a95fd5d71", Name: testContainerName}
+		testMasterProcess *proc.Process = &proc.Process{ID: 1, Path: testProcessPath, Executable: testProcessExecutable}
+		testWorkerProcess *proc.Process = &proc.Process{ID: 2, Path: testProcessPath, Executable: testProcessExecutable, ParentID: 1}
+		testShellProcess *proc.Process = &proc.Process{ID: 3, Path: "/bin/sh", Executable: "sh", ParentID: 1}
+		testTargetSocket *proc.Socket = &proc.Socket{Protocol: testSocketProtocol, LocalIP: net.ParseIP("192.168.1.2"), RemoteIP: testSocketRemoteIP, LocalPort: 50000, RemotePort: testSocketRemotePort}
+	)
+	testCases := []struct {
+		sharedSocket policy.SharedSocketMatch
+		owners       []*proc.Process
+		expected     bool
+	}{
+		{policy.MatchAnyOwner, []*proc.Process{testMasterProcess, testWorkerProcess}, true},
+		{policy.MatchAnyOwner, []*proc.Process{testMasterProcess, testShellProcess}, true},
+		{policy.MatchAnyOwner, []*proc.Process{testShellProcess}, false},
+		{policy.MatchAllOwners, []*proc.Process{testMasterProcess, testWorkerProcess}, true},
+		{policy.MatchAllOwners, []*proc.Process{testMasterProcess, testShellProcess}, false},
+	}
+
+	for _, testCase := range testCases {
+		// NOTE: The judgement is cached regardless of the policies.
+		policy.PolicyCache.Flush()
+		testPolicies := &policy.Policies{List: []*policy.Policy{{
+			Container: expectedPolicies.List[0].Container,
+			Communications: expectedPolicies.List[0].Communications,
+			SharedSocket: testCase.sharedSocket,
+		}}}
+		if testPolicies.IsDefinedForOwners(testCommunicatedContainer, testCase.owners, testTargetSocket) != testCase.expected {
+			t.Error("the communication through the shared socket not judged correctly", testCase.sharedSocket, testCase.owners)
+		}
+	}
+	policy.PolicyCache.Flush()
+}
+
+func TestProtects(t *testing.T) {
+	testCases := []struct {
+		container *container.Container
+		expected  bool
+	}{
+		{&container.Container{ID: "49dae530fd5fee674a6b0d3da89a380fc93746095e7eca0f1b70188a95fd5d71", Name: testContainerName}, true},
+		{&container.Container{ID: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", Name: "cnet_quic_test"}, false},
+	}
+	for _, testCase := range testCases {
+		if expectedPolicies.Protects(testCase.container) != testCase.expected {
+			t.Error("the protection of the container not judged correctly", testCase.container)
+		}
+	}
+}
+
+func TestProtectsByLabels(t *testing.T) {
+	labelPolicies := policy.Policies{List: []*policy.Policy{
+		{Container: &container.Container{Labels: map[string]string{"app": "web"}}},
+		{Container: &container.Container{Name: "cnet_db_test", Labels: map[string]string{"tier": "db"}}},
+	}}
+	testCases := []struct {
+		container *container.Container
+		expected  bool
+	}{
+		{&container.Container{ID: "4e3a2b1c", Name: "cnet_web_test", Labels: map[string]string{"app": "web", "nerdctl/name": "cnet_web_test"}}, true},
+		{&container.Container{ID: "5d6e7f80", Name: "cnet_api_test", Labels: map[string]string{"app": "api"}}, false},
+		{&container.Container{ID: "6e7f8091", Name: "cnet_db_test", Labels: map[string]string{"tier": "db"}}, true},
+		{&container.Container{ID: "7f8091a2", Name: "cnet_cache_test", Labels: map[string]string{"tier": "db"}}, false},
+		{&container.Container{ID: "8091a2b3", Name: "cnet_db_test"}, false},
+	}
+	for _, testCase := range testCases {
+		if labelPolicies.Protects(testCase.container) != testCase.expected {
+			t.Error("the protection of the container by the labels not judged correctly", testCase.container)
+		}
+	}
+}
+
+func TestProtectsByPod(t *testing.T) {
+	podPolicies := policy.Policies{List: []*policy.Policy{
+		{Pod: &container.Pod{Namespace: "default", Labels: map[string]string{"app": "web"}}, Container: &container.Container{}},
+		{Pod: &container.Pod{Namespace: "payment"}, Container: &container.Container{Name: "api"}},
+	}}
+	webPod := &container.Pod{ID: "a1b2c3d4", Name: "web-5d8f", Namespace: "default", Labels: map[string]string{"app": "web"}}
+	paymentPod := &container.Pod{ID: "b2c3d4e5", Name: "api-7c9e", Namespace: "payment", Labels: map[string]string{"app": "api"}}
+	testCases := []struct {
+		container *container.Container
+		expected  bool
+	}{
+		{&container.Container{ID: "4e3a2b1c", Name: "nginx", Pod: webPod}, true},
+		{&container.Container{ID: "5d6e7f80", Name: "envoy", Pod: webPod}, true},
+		{&container.Container{ID: "6e7f8091", Name: "nginx", Pod: &container.Pod{Namespace: "staging", Labels: map[string]string{"app": "web"}}}, false},
+		{&container.Container{ID: "7f8091a2", Name: "api", Pod: paymentPod}, true},
+		{&container.Container{ID: "8091a2b3", Name: "envoy", Pod: paymentPod}, false},
+		{&container.Container{ID: "91a2b3c4", Name: "api"}, false},
+	}
+	for _, testCase := range testCases {
+		if podPolicies.Protects(testCase.container) != testCase.expected {
+			t.Error("the protection of the container by the pod not judged correctly", testCase.container, testCase.container.Pod)
+		}
+	}
+}
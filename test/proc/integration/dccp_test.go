@@ -0,0 +1,41 @@
+package integration_test
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/tomo-9925/cnet/pkg/proc"
+	"golang.org/x/sys/unix"
+)
+
+func TestSearchInodeFromDCCPOfPid(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("the network namespace cannot be entered without root")
+	}
+	// NOTE: DCCP is not supported by the kernel without the dccp module, and is removed since linux 6.16.
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DCCP, unix.IPPROTO_DCCP)
+	if err != nil {
+		t.Skip("dccp socket not supported", err)
+	}
+	defer unix.Close(fd)
+	if err = unix.Bind(fd, &unix.SockaddrInet4{Port: 5004, Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = unix.Listen(fd, 1); err != nil {
+		t.Fatal(err)
+	}
+	var stat unix.Stat_t
+	if err = unix.Fstat(fd, &stat); err != nil {
+		t.Fatal(err)
+	}
+
+	socket := &proc.Socket{Protocol: proc.LayerTypeDCCP, LocalIP: net.ParseIP("127.0.0.1"), LocalPort: 5004, RemoteIP: net.ParseIP("127.0.0.1"), RemotePort: 5005}
+	inode, err := proc.SearchInodeFromDCCPOfPid(socket, os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inode != stat.Ino {
+		t.Error("the inode of the listening dccp socket not found", inode, stat.Ino)
+	}
+}
@@ -1,4 +1,4 @@
-package proc_test
+package integration_test
 
 import (
 	"context"
@@ -14,7 +14,6 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	cnetContainer "github.com/tomo-9925/cnet/pkg/container"
-	"github.com/tomo-9925/cnet/pkg/docker"
 	cnetNetwork "github.com/tomo-9925/cnet/pkg/network"
 	"github.com/tomo-9925/cnet/pkg/proc"
 )
@@ -64,11 +63,11 @@ func TestIdentifyTCPCommunication(t *testing.T) {
 	}
 
 	// Setting iptables
-	if err := cnetNetwork.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum); err != nil {
+	if err := cnetNetwork.InsertNFQueueRule(chainName, protocol, ruleNum, queueNum, 1, false); err != nil {
 		t.Fatal(err)
 	}
 	defer func(){
-		err := cnetNetwork.DeleteNFQueueRule(chainName, protocol, queueNum)
+		err := cnetNetwork.DeleteNFQueueRule(chainName, protocol, queueNum, 1, false)
 		if err != nil {
 			t.Error(err)
 		}
@@ -114,7 +113,7 @@ func TestIdentifyTCPCommunication(t *testing.T) {
 	p := <-packets
 
 	// Get Socket Information
-	socket, _, err := proc.CheckSocketAndCommunicatedDockerContainer(&p.Packet, &docker.Containers{List: []*cnetContainer.Container{startedContainer}})
+	socket, _, err := proc.CheckSocketAndCommunicatedDockerContainer(&p.Packet, cnetContainer.NewContainers([]*cnetContainer.Container{startedContainer}))
 	if err != nil {
 		t.Fatal(err)
 	}
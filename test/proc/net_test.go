@@ -5,6 +5,9 @@ import (
 	"net"
 	"testing"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	cnetContainer "github.com/tomo-9925/cnet/pkg/container"
 	"github.com/tomo-9925/cnet/pkg/proc"
 )
 
@@ -23,3 +26,144 @@ func TestIPtoa(t *testing.T) {
 		t.Fatal("couldn't convert ipv6 address")
 	}
 }
+
+func TestIdentifyIPv6TCPCommunication(t *testing.T) {
+	useFixture(t)
+
+	containerIP, remoteIP := net.ParseIP("2001:db8:1::2"), net.ParseIP("2001:db8::80")
+	ipv6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolTCP, SrcIP: containerIP, DstIP: remoteIP}
+	tcp := &layers.TCP{SrcPort: 40001, DstPort: 443, SYN: true, Window: 64240}
+	if err := tcp.SetNetworkLayerForChecksum(ipv6); err != nil {
+		t.Fatal(err)
+	}
+	packet := makePacket(t, ipv6, tcp, layers.LayerTypeIPv6)
+
+	socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socket.Protocol != layers.LayerTypeTCP {
+		t.Error("protocol not located correctly")
+	}
+	if !socket.LocalIP.Equal(containerIP) {
+		t.Error("local ipv6 address not located correctly")
+	}
+	if !socket.RemoteIP.Equal(remoteIP) {
+		t.Error("remote ipv6 address not located correctly")
+	}
+	if socket.LocalPort != 40001 || socket.RemotePort != 443 {
+		t.Error("port number not get correctly")
+	}
+
+	communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if communicatedProcess.Path != "/bin/nc" {
+		t.Error("path not get correctly", communicatedProcess)
+	}
+}
+
+func TestIdentifyICMPv6EchoCommunication(t *testing.T) {
+	useFixture(t)
+
+	ipv6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6, SrcIP: net.ParseIP("2001:db8::80"), DstIP: net.ParseIP("2001:db8:1::2")}
+	icmpv6 := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoReply, 0)}
+	if err := icmpv6.SetNetworkLayerForChecksum(ipv6); err != nil {
+		t.Fatal(err)
+	}
+	icmpv6Echo := &layers.ICMPv6Echo{Identifier: 8, SeqNumber: 1}
+	packet := makePacket(t, ipv6, icmpv6, layers.LayerTypeIPv6, icmpv6Echo)
+
+	socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socket.Protocol != layers.LayerTypeICMPv6 {
+		t.Error("protocol not located correctly")
+	}
+	identifier, err := proc.CheckIdentifierOfICMP(socket, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identifier != 8 {
+		t.Error("identifier of icmpv6 echo not get correctly")
+	}
+
+	communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if communicatedProcess.ID != 102 {
+		t.Error("process id not get correctly", communicatedProcess)
+	}
+}
+
+func TestIdentifyICMPErrorCommunication(t *testing.T) {
+	useFixture(t)
+
+	containerIPv4, remoteIPv4 := net.ParseIP("172.17.0.2"), net.ParseIP("158.217.2.147")
+	containerIPv6, remoteIPv6 := net.ParseIP("2001:db8:1::2"), net.ParseIP("2001:db8::80")
+	testCases := []struct {
+		name                   string
+		networkLayer, icmp     gopacket.SerializableLayer
+		originalNetworkLayer   gopacket.NetworkLayer
+		originalSrcPort        layers.TCPPort
+		originalDstPort        layers.TCPPort
+		firstLayerType         gopacket.LayerType
+		expectedLocalPort      uint16
+		expectedRemotePort     uint16
+		expectedProcessID      int
+		originalHeaderPrefixes []byte
+	}{
+		{
+			"destination unreachable",
+			&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.ParseIP("10.1.3.1"), DstIP: containerIPv4},
+			&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable, layers.ICMPv4CodeFragmentationNeeded)},
+			&layers.IPv4{Version: 4, TTL: 63, Protocol: layers.IPProtocolTCP, SrcIP: containerIPv4, DstIP: remoteIPv4},
+			40000, 80, layers.LayerTypeIPv4, 40000, 80, 101, nil,
+		},
+		{
+			"packet too big",
+			&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolICMPv6, SrcIP: net.ParseIP("2001:db8::1"), DstIP: containerIPv6},
+			&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypePacketTooBig, 0)},
+			&layers.IPv6{Version: 6, HopLimit: 63, NextHeader: layers.IPProtocolTCP, SrcIP: containerIPv6, DstIP: remoteIPv6},
+			40001, 443, layers.LayerTypeIPv6, 40001, 443, 101, []byte{0, 0, 0x05, 0x00},
+		},
+	}
+
+	for _, testCase := range testCases {
+		// NOTE: The original packet is truncated to the network header and the first 8 bytes of the 20 bytes transport header.
+		tcp := &layers.TCP{SrcPort: testCase.originalSrcPort, DstPort: testCase.originalDstPort, SYN: true, Window: 64240}
+		if err := tcp.SetNetworkLayerForChecksum(testCase.originalNetworkLayer); err != nil {
+			t.Fatal(err)
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, testCase.originalNetworkLayer.(gopacket.SerializableLayer), tcp); err != nil {
+			t.Fatal(err)
+		}
+		originalHeader := buf.Bytes()[:len(buf.Bytes())-20+8]
+		payload := gopacket.Payload(append(testCase.originalHeaderPrefixes, originalHeader...))
+		if icmpv6, ok := testCase.icmp.(*layers.ICMPv6); ok {
+			if err := icmpv6.SetNetworkLayerForChecksum(testCase.networkLayer.(gopacket.NetworkLayer)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		packet := makePacket(t, testCase.networkLayer, testCase.icmp, testCase.firstLayerType, payload)
+
+		socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer}))
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if socket.Protocol != layers.LayerTypeTCP || socket.LocalPort != testCase.expectedLocalPort || socket.RemotePort != testCase.expectedRemotePort {
+			t.Error(testCase.name, "socket of the original packet not located correctly", socket)
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if communicatedProcess.ID != testCase.expectedProcessID {
+			t.Error(testCase.name, "process id not get correctly", communicatedProcess)
+		}
+	}
+}
@@ -0,0 +1,621 @@
+package proc_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	cnetContainer "github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/proc"
+)
+
+var (
+	// synthetic proc filesystem settings
+	fixtureRoot string = "testdata"
+	fixtureContainer *cnetContainer.Container = &cnetContainer.Container{
+		ID: "4e3a2b1c",
+		IPAddresses: []net.IP{net.ParseIP("172.17.0.2"), net.ParseIP("2001:db8:1::2")},
+		Name: "/cnet_fixture_test",
+		Pid: 100,
+	}
+)
+
+func useFixture(t *testing.T) {
+	// NOTE: The net tables of the fixtures are written in little endian.
+	if proc.HostByteOrder != binary.LittleEndian {
+		t.Skip("the fixtures are not available in big endian host")
+	}
+	// NOTE: The root is restored after the test, so that the other tests read the proc filesystem of the host.
+	previous := proc.SetRoot(fixtureRoot)
+	t.Cleanup(func() { proc.SetRoot(previous) })
+	proc.SocketCache.Flush()
+	proc.OwnerContainerCache.Flush()
+	proc.NetworkNamespaceCache.Flush()
+	proc.ContainerProcesses.Flush()
+}
+
+func makePacket(t *testing.T, networkLayer gopacket.SerializableLayer, transportLayer gopacket.SerializableLayer, firstLayerType gopacket.LayerType, payloadLayers ...gopacket.SerializableLayer) *gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, append([]gopacket.SerializableLayer{networkLayer, transportLayer}, payloadLayers...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), firstLayerType, gopacket.Default)
+	return &packet
+}
+
+func TestRetrievePIDsFromFixture(t *testing.T) {
+	useFixture(t)
+
+	expectedPIDs := []int{100, 101, 102, 103, 104}
+	containerPIDs, err := proc.RetrievePIDsOfContainer(fixtureContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(containerPIDs)
+	if len(containerPIDs) != len(expectedPIDs) {
+		t.Fatal("retrieved pids of container not equal pids in cgroup.procs", containerPIDs)
+	}
+	for i := range expectedPIDs {
+		if containerPIDs[i] != expectedPIDs[i] {
+			t.Error("retrieved pids of container not equal pids in cgroup.procs", containerPIDs)
+		}
+	}
+
+	childPIDs, err := proc.RetrieveChildPIDs(90)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(childPIDs)
+	if len(childPIDs) != len(expectedPIDs) {
+		t.Fatal("retrieved child pids not equal pids in children", childPIDs)
+	}
+	for i := range expectedPIDs {
+		if childPIDs[i] != expectedPIDs[i] {
+			t.Error("retrieved child pids not equal pids in children", childPIDs)
+		}
+	}
+}
+
+func TestRetrievePPIDOfProcessNameHavingParenthesesFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The process name of 142 has the spaces and the parentheses, which look like the fields of stat.
+	ppid, err := proc.RetrievePPID(142)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ppid != 1 {
+		t.Error("the ppid not retrieved after the process name", ppid)
+	}
+	process, err := proc.MakeProcessStruct(142)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process.Executable != "tmux: (1) S 9)" || process.ParentID != 1 {
+		t.Error("the process struct not made correctly", process)
+	}
+}
+
+func TestRetrievePIDsOfPodmanContainerFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The container has no cgroup, so the pids are retrieved from conmon (129) and conmon of the exec session (131).
+	podmanContainer := &cnetContainer.Container{ID: "7a8b9c0d", Name: "/cnet_podman_test", Pid: 130}
+	expectedPIDs := []int{130, 132}
+	containerPIDs, err := proc.RetrievePIDsOfContainer(podmanContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(containerPIDs)
+	if len(containerPIDs) != len(expectedPIDs) {
+		t.Fatal("retrieved pids of container not equal children of conmon", containerPIDs)
+	}
+	for i := range expectedPIDs {
+		if containerPIDs[i] != expectedPIDs[i] {
+			t.Error("retrieved pids of container not equal children of conmon", containerPIDs)
+		}
+	}
+}
+
+func TestIdentifyTCPCommunicationFromFixture(t *testing.T) {
+	useFixture(t)
+
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer})
+	testCases := []struct {
+		name               string
+		srcIP, dstIP       net.IP
+		srcPort, dstPort   layers.TCPPort
+		expectedProcessID  int
+		expectedExecutable string
+		expectedPath       string
+	}{
+		{"client", net.ParseIP("172.17.0.2"), net.ParseIP("158.217.2.147"), 40000, 80, 101, "nc", "/bin/nc"},
+		{"server", net.ParseIP("10.1.3.10"), net.ParseIP("172.17.0.2"), 51000, 8080, 100, "httpd", "/usr/sbin/httpd"},
+	}
+
+	for _, testCase := range testCases {
+		ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: testCase.srcIP, DstIP: testCase.dstIP}
+		tcp := &layers.TCP{SrcPort: testCase.srcPort, DstPort: testCase.dstPort, SYN: true, Window: 64240}
+		if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+			t.Fatal(err)
+		}
+		packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+
+		socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if !communicatedContainer.Equal(fixtureContainer) {
+			t.Error(testCase.name, "communicated container not located correctly")
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if communicatedProcess.ID != testCase.expectedProcessID {
+			t.Error(testCase.name, "process id not get correctly", communicatedProcess)
+		}
+		if communicatedProcess.Executable != testCase.expectedExecutable {
+			t.Error(testCase.name, "executable not get correctly", communicatedProcess)
+		}
+		if communicatedProcess.Path != testCase.expectedPath {
+			t.Error(testCase.name, "path not get correctly", communicatedProcess)
+		}
+	}
+}
+
+func TestRecallSnapshottedSocketFromFixture(t *testing.T) {
+	useFixture(t)
+	proc.InodeHistory.Flush()
+	proc.EntryHistory.Flush()
+
+	if err := proc.SnapshotSocketsOfContainer(fixtureContainer); err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: nc (101) exits and its socket is closed before the packet is identified.
+	proc.SetRoot(t.TempDir())
+	socket := &proc.Socket{Protocol: layers.LayerTypeTCP, LocalIP: net.ParseIP("172.17.0.2"), LocalPort: 40000, RemoteIP: net.ParseIP("158.217.2.147"), RemotePort: 80}
+	recalledProcess, err := proc.RecallProcessOfContainer(socket, fixtureContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recalledProcess.ID != 101 || recalledProcess.Executable != "nc" {
+		t.Error("the snapshotted process not recalled", recalledProcess)
+	}
+}
+
+func TestIdentifyHostNetworkCommunicationFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The container of the host network has no IP address, so the socket on the loopback address is attributed by the processes in the cgroup.
+	hostNetworkContainer := &cnetContainer.Container{ID: "7f1e9d0a", Name: "/cnet_fixture_host_test", Pid: 100, HostNetwork: true}
+	goneContainer := &cnetContainer.Container{ID: "8b2c6e4f", Name: "/cnet_fixture_gone_test", Pid: 999, HostNetwork: true}
+	// NOTE: The socket of 15001 is owned by the process of the other cgroup, and the socket of 41000 is not found, such as the closed socket.
+	testCases := []struct {
+		name              string
+		containers        []*cnetContainer.Container
+		srcPort, dstPort  layers.TCPPort
+		expectedContainer *cnetContainer.Container
+		expectedErr       error
+	}{
+		{"host network", []*cnetContainer.Container{fixtureContainer, goneContainer, hostNetworkContainer}, 40000, 80, hostNetworkContainer, nil},
+		{"no host network", []*cnetContainer.Container{fixtureContainer}, 40000, 80, nil, proc.ErrContainerNotFound},
+		{"host", []*cnetContainer.Container{goneContainer, hostNetworkContainer}, 15001, 443, nil, proc.ErrContainerNotFound},
+		{"closed socket", []*cnetContainer.Container{goneContainer, hostNetworkContainer}, 41000, 80, nil, proc.ErrContainerNotFound},
+	}
+
+	for _, testCase := range testCases {
+		ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("127.0.0.1"), DstIP: net.ParseIP("158.217.2.147")}
+		tcp := &layers.TCP{SrcPort: testCase.srcPort, DstPort: testCase.dstPort, SYN: true, Window: 64240}
+		if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+			t.Fatal(err)
+		}
+		packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+
+		containers := cnetContainer.NewContainers(testCase.containers)
+		socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+		if testCase.expectedContainer == nil {
+			if !errors.Is(err, testCase.expectedErr) {
+				t.Error(testCase.name, "the packet of the host attributed", communicatedContainer, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if !communicatedContainer.Equal(testCase.expectedContainer) {
+			t.Error(testCase.name, "communicated container not located correctly", communicatedContainer)
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if communicatedProcess.ID != 101 {
+			t.Error(testCase.name, "process id not get correctly", communicatedProcess)
+		}
+	}
+}
+
+func TestRecallHostNetworkOwnerFromFixture(t *testing.T) {
+	useFixture(t)
+	proc.InodeHistory.Flush()
+	proc.EntryHistory.Flush()
+	proc.OwnerContainerCache.Flush()
+
+	// NOTE: The containers of the host network share the entries, so the socket is attributed to the container whose process held the inode.
+	hostNetworkContainer := &cnetContainer.Container{ID: "7f1e9d0a", Name: "/cnet_fixture_host_test", Pid: 100, HostNetwork: true}
+	otherContainer := &cnetContainer.Container{ID: "5d6e7f80", Name: "/cnet_fixture_other_test", Pid: 110, HostNetwork: true}
+	for _, snapshottedContainer := range []*cnetContainer.Container{otherContainer, hostNetworkContainer} {
+		if err := proc.SnapshotSocketsOfContainer(snapshottedContainer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// NOTE: nc (101) exits and its socket is closed before the packet is identified.
+	proc.SetRoot(t.TempDir())
+	ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("127.0.0.1"), DstIP: net.ParseIP("158.217.2.147")}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, ACK: true, Window: 64240}
+	if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+		t.Fatal(err)
+	}
+	packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{otherContainer, hostNetworkContainer})
+	_, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !communicatedContainer.Equal(hostNetworkContainer) {
+		t.Error("the container holding the closed socket not recalled", communicatedContainer)
+	}
+}
+
+func TestListeningPortsOfContainerFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The socket of 8080 is listening, and the other sockets of the container are connected.
+	ports, err := proc.ListeningPortsOfContainer(fixtureContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || ports[0] != (proc.ListeningPort{Protocol: layers.LayerTypeTCP, Port: 8080}) {
+		t.Error("the listening ports not listed correctly", ports)
+	}
+}
+
+func TestIdentifySharedNetworkNamespaceCommunicationFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The sidecar started with --network container:<id> has no IP address, and sends the packets by the IP address of the container.
+	sidecarContainer := &cnetContainer.Container{ID: "5d6e7f80", Name: "/cnet_fixture_sidecar_test", Pid: 110}
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer, sidecarContainer})
+	testCases := []struct {
+		name               string
+		srcPort, dstPort   layers.TCPPort
+		expectedContainer  *cnetContainer.Container
+		expectedProcessID  int
+		expectedExecutable string
+	}{
+		{"sidecar", 15001, 443, sidecarContainer, 110, "envoy"},
+		{"container", 40000, 80, fixtureContainer, 101, "nc"},
+	}
+
+	for _, testCase := range testCases {
+		ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("172.17.0.2"), DstIP: net.ParseIP("158.217.2.147")}
+		tcp := &layers.TCP{SrcPort: testCase.srcPort, DstPort: testCase.dstPort, SYN: true, Window: 64240}
+		if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+			t.Fatal(err)
+		}
+		packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+
+		socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if !communicatedContainer.Equal(testCase.expectedContainer) {
+			t.Error(testCase.name, "communicated container not located correctly", communicatedContainer)
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if communicatedProcess.ID != testCase.expectedProcessID {
+			t.Error(testCase.name, "process id not get correctly", communicatedProcess)
+		}
+		if communicatedProcess.Executable != testCase.expectedExecutable {
+			t.Error(testCase.name, "executable not get correctly", communicatedProcess)
+		}
+	}
+}
+
+func TestNetworkNamespaceOfRestartedContainerFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The container restarted with the same ID has the other process, whose network namespace is not the cached one.
+	networkNamespace, err := proc.NetworkNamespaceOfContainer(fixtureContainer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkNamespace != "net:[4026532001]" {
+		t.Error("the network namespace not retrieved correctly", networkNamespace)
+	}
+	restartedContainer := &cnetContainer.Container{ID: fixtureContainer.ID, Name: fixtureContainer.Name, Pid: 999}
+	if networkNamespace, err := proc.NetworkNamespaceOfContainer(restartedContainer); err == nil {
+		t.Error("the network namespace of the stopped process returned from the cache", networkNamespace)
+	}
+}
+
+func TestIdentifyPodCommunicationFromFixture(t *testing.T) {
+
+	// NOTE: The containers of the pod share the IP addresses of the pod, so the socket is attributed to the container owning it.
+	pod := &cnetContainer.Pod{ID: "a1b2c3d4", Name: "web-5d8f", Namespace: "default"}
+	nginxContainer := &cnetContainer.Container{ID: fixtureContainer.ID, IPAddresses: fixtureContainer.IPAddresses, Name: "nginx", Pid: fixtureContainer.Pid, Pod: pod}
+	envoyContainer := &cnetContainer.Container{ID: "5d6e7f80", IPAddresses: fixtureContainer.IPAddresses, Name: "envoy", Pid: 110, Pod: pod}
+	for _, list := range [][]*cnetContainer.Container{{nginxContainer, envoyContainer}, {envoyContainer, nginxContainer}} {
+		useFixture(t)
+		containers := cnetContainer.NewContainers(list)
+		for srcPort, expectedContainer := range map[layers.TCPPort]*cnetContainer.Container{15001: envoyContainer, 40000: nginxContainer} {
+			ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("172.17.0.2"), DstIP: net.ParseIP("158.217.2.147")}
+			tcp := &layers.TCP{SrcPort: srcPort, DstPort: 443, SYN: true, Window: 64240}
+			if srcPort == 40000 {
+				tcp.DstPort = 80
+			}
+			if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+				t.Fatal(err)
+			}
+			packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+
+			_, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+			if err != nil {
+				t.Fatal(srcPort, err)
+			}
+			if communicatedContainer != expectedContainer {
+				t.Error("the container of the pod owning the socket not located correctly", srcPort, communicatedContainer)
+			}
+		}
+	}
+}
+
+func TestCheckEndpointsBetweenContainersFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The packet between the containers has the endpoints of both, regardless of the order of the containers.
+	peerContainer := &cnetContainer.Container{ID: "9a0b1c2d", IPAddresses: []net.IP{net.ParseIP("10.1.3.10")}, Name: "/cnet_fixture_peer_test", Pid: 999}
+	ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("10.1.3.10"), DstIP: net.ParseIP("172.17.0.2")}
+	tcp := &layers.TCP{SrcPort: 51000, DstPort: 8080, SYN: true, Window: 64240}
+	if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+		t.Fatal(err)
+	}
+	packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+
+	for _, list := range [][]*cnetContainer.Container{{fixtureContainer, peerContainer}, {peerContainer, fixtureContainer}} {
+		endpoints, err := proc.CheckSocketsAndCommunicatedDockerContainers(packet, cnetContainer.NewContainers(list))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(endpoints) != 2 {
+			t.Fatal("the endpoints of both containers not checked", endpoints)
+		}
+		sender, receiver := endpoints[0], endpoints[1]
+		if !sender.Container.Equal(peerContainer) || sender.Socket.Inbound || sender.Socket.LocalPort != 51000 || sender.Socket.RemotePort != 8080 {
+			t.Error("the endpoint of the sender not checked correctly", sender)
+		}
+		if !receiver.Container.Equal(fixtureContainer) || !receiver.Socket.Inbound || receiver.Socket.LocalPort != 8080 || receiver.Socket.RemotePort != 51000 {
+			t.Error("the endpoint of the receiver not checked correctly", receiver)
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(receiver.Socket, receiver.Container, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if communicatedProcess.ID != 100 {
+			t.Error("process id of the receiver not get correctly", communicatedProcess)
+		}
+
+		_, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, cnetContainer.NewContainers(list))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !communicatedContainer.Equal(peerContainer) {
+			t.Error("the sender not returned as the communicated container", communicatedContainer)
+		}
+	}
+}
+
+func TestIdentifyHostNetworkICMPCommunicationFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The raw sockets are owned by the ping processes of the first container, and the processes of the other container have none.
+	hostNetworkContainer := &cnetContainer.Container{ID: "7f1e9d0a", Name: "/cnet_fixture_host_test", Pid: 100, HostNetwork: true}
+	otherContainer := &cnetContainer.Container{ID: "5d6e7f80", Name: "/cnet_fixture_other_test", Pid: 110, HostNetwork: true}
+	testCases := []struct {
+		name              string
+		containers        []*cnetContainer.Container
+		expectedContainer *cnetContainer.Container
+	}{
+		{"owner", []*cnetContainer.Container{otherContainer, hostNetworkContainer}, hostNetworkContainer},
+		{"no owner", []*cnetContainer.Container{otherContainer}, nil},
+	}
+
+	for _, testCase := range testCases {
+		ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.ParseIP("127.0.0.1"), DstIP: net.ParseIP("10.1.3.10")}
+		icmpv4 := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 8, Seq: 1}
+		packet := makePacket(t, ipv4, icmpv4, layers.LayerTypeIPv4)
+
+		containers := cnetContainer.NewContainers(testCase.containers)
+		_, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+		if testCase.expectedContainer == nil {
+			if !errors.Is(err, proc.ErrContainerNotFound) {
+				t.Error(testCase.name, "the packet of no raw socket attributed", communicatedContainer, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if !communicatedContainer.Equal(testCase.expectedContainer) {
+			t.Error(testCase.name, "communicated container not located correctly", communicatedContainer)
+		}
+	}
+}
+
+func TestIdentifyICMPCommunicationFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: Both ping processes have raw socket, so the process is identified by NSpid and identifier of ICMP.
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer})
+	for identifier, expectedProcessID := range map[uint16]int{8: 102, 9: 103} {
+		ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: net.ParseIP("172.17.0.2"), DstIP: net.ParseIP("10.1.3.10")}
+		icmpv4 := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: identifier, Seq: 1}
+		packet := makePacket(t, ipv4, icmpv4, layers.LayerTypeIPv4)
+
+		socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if communicatedProcess.ID != expectedProcessID {
+			t.Error("process id not get correctly by the icmp identifier", identifier, communicatedProcess)
+		}
+		if communicatedProcess.Path != "/bin/ping" {
+			t.Error("path not get correctly", communicatedProcess)
+		}
+	}
+}
+
+func TestIdentifySCTPAndUDPLiteCommunicationFromFixture(t *testing.T) {
+	useFixture(t)
+
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer})
+	testCases := []struct {
+		name              string
+		protocol          layers.IPProtocol
+		header            []byte
+		expectedProcessID int
+	}{
+		// NOTE: The headers are only common headers including ports, because gopacket cannot serialize them.
+		{"sctp", layers.IPProtocolSCTP, []byte{0x96, 0x0c, 0x8e, 0x3c, 0, 0, 0, 1, 0, 0, 0, 0}, 101},
+		{"udplite", layers.IPProtocolUDPLite, []byte{0x13, 0x8c, 0x13, 0x8c, 0, 8, 0, 0}, 101},
+	}
+
+	for _, testCase := range testCases {
+		ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: testCase.protocol, SrcIP: net.ParseIP("172.17.0.2"), DstIP: net.ParseIP("10.1.3.10")}
+		packet := makePacket(t, ipv4, gopacket.Payload(testCase.header), layers.LayerTypeIPv4)
+
+		socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		communicatedProcess, err := proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(testCase.name, err)
+		}
+		if communicatedProcess.ID != testCase.expectedProcessID {
+			t.Error(testCase.name, "process id not get correctly", communicatedProcess)
+		}
+	}
+}
+
+func TestDCCPCommunicationWithoutNetworkNamespaceNotIdentified(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: DCCP sockets are dumped in the network namespace of the container, which the fixture does not have.
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer})
+	ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: proc.IPProtocolDCCP, SrcIP: net.ParseIP("172.17.0.2"), DstIP: net.ParseIP("10.1.3.10")}
+	// NOTE: The data offset is 4 words (16 bytes) with extended sequence number.
+	dccp := gopacket.Payload([]byte{0x13, 0x8c, 0x13, 0x8d, 4, 0, 0, 0, 0x01, 0, 0, 0, 0, 0, 0, 1})
+	packet := makePacket(t, ipv4, dccp, layers.LayerTypeIPv4)
+
+	socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if socket.Protocol != proc.LayerTypeDCCP || socket.LocalPort != 5004 || socket.RemotePort != 5005 {
+		t.Error("dccp socket not checked correctly", socket)
+	}
+	if _, err = proc.IdentifyProcessOfContainer(socket, communicatedContainer, packet); err == nil {
+		t.Error("the process of dccp socket identified unexpectedly")
+	}
+}
+
+func TestIdentifySharedSocketOwnersFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The listening socket of httpd is shared by the master (100) and the worker (104).
+	containers := cnetContainer.NewContainers([]*cnetContainer.Container{fixtureContainer})
+	ipv4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: net.ParseIP("10.1.3.10"), DstIP: net.ParseIP("172.17.0.2")}
+	tcp := &layers.TCP{SrcPort: 51001, DstPort: 8080, SYN: true, Window: 64240}
+	if err := tcp.SetNetworkLayerForChecksum(ipv4); err != nil {
+		t.Fatal(err)
+	}
+	packet := makePacket(t, ipv4, tcp, layers.LayerTypeIPv4)
+
+	socket, communicatedContainer, err := proc.CheckSocketAndCommunicatedDockerContainer(packet, containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NOTE: The owners are identified twice to check the result from the cache.
+	for i := 0; i < 2; i++ {
+		owners, err := proc.IdentifyProcessesOfContainer(socket, communicatedContainer, packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(owners) != 2 || owners[0].ID != 100 || owners[1].ID != 104 {
+			t.Error("owners of the shared socket not get correctly", owners)
+		}
+	}
+}
+
+func TestRetrieveIPAddressesFromFixture(t *testing.T) {
+	useFixture(t)
+
+	// NOTE: The loopback, link-local and duplicated addresses are excluded.
+	ipAddresses, err := proc.RetrieveIPAddresses(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ipAddresses) != len(fixtureContainer.IPAddresses) {
+		t.Fatal("ip addresses not retrieved correctly", ipAddresses)
+	}
+	for i, ipAddress := range ipAddresses {
+		if !ipAddress.Equal(fixtureContainer.IPAddresses[i]) {
+			t.Error("ip address not retrieved correctly", ipAddress, fixtureContainer.IPAddresses[i])
+		}
+	}
+	if _, err := proc.RetrieveIPAddresses(999); err == nil {
+		t.Error("ip addresses of the nonexistent process retrieved")
+	}
+}
+
+func TestRetrieveUnifiedCgroupFromFixture(t *testing.T) {
+	useFixture(t)
+
+	cgroup, err := proc.RetrieveUnifiedCgroupPath(110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cgroup != "/system.slice/docker-5d6e7f80.scope" {
+		t.Error("the unified cgroup path not retrieved correctly", cgroup)
+	}
+	id, err := proc.RetrieveUnifiedCgroupID(cgroup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(filepath.Join(fixtureRoot, "sys", "fs", "cgroup", cgroup), &stat); err != nil {
+		t.Fatal(err)
+	}
+	if id != stat.Ino {
+		t.Error("the unified cgroup id not retrieved correctly", id, stat.Ino)
+	}
+	if _, err := proc.RetrieveUnifiedCgroupID("/system.slice/docker-8b2c6e4f.scope"); err == nil {
+		t.Error("the id of the nonexistent cgroup retrieved")
+	}
+}
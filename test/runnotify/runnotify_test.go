@@ -1,19 +1,203 @@
 package runnotify_test
 
 import (
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/tomo-9925/cnet/pkg/container"
+	"github.com/tomo-9925/cnet/pkg/docker"
 	"github.com/tomo-9925/cnet/pkg/runnotify"
 )
 
+// fakeRuntime notifies the events sent by the test, and lists the containers set by the test.
+// The events fail right after the subscription if unavailable is set, such as the events of CRI without the container events.
+type fakeRuntime struct {
+	events        chan container.Event
+	errs          chan error
+	unavailable   error
+	mutex         sync.Mutex
+	list          []*container.Container
+	subscriptions int
+	dones         []<-chan struct{}
+}
+
+func (r *fakeRuntime) Name() string {
+	return "fake"
+}
+
+func (r *fakeRuntime) List() ([]*container.Container, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.list, nil
+}
+
+func (r *fakeRuntime) Inspect(cid string) (*container.Container, error) {
+	return &container.Container{ID: cid}, nil
+}
+
+func (r *fakeRuntime) Events(done <-chan struct{}) (<-chan container.Event, <-chan error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.subscriptions++
+	r.dones = append(r.dones, done)
+	if r.unavailable != nil {
+		errs := make(chan error, 1)
+		errs <- r.unavailable
+		return r.events, errs
+	}
+	return r.events, r.errs
+}
+
+func (r *fakeRuntime) DNSHelperPID() int {
+	return 0
+}
+
+func (r *fakeRuntime) ForwardChain() string {
+	return "FORWARD"
+}
+
 func TestRunnotify(t *testing.T) {
+	runtime, err := docker.NewRuntime()
+	if err != nil {
+		t.Fatal(err)
+	}
 	runCh := make(chan string)
 	killCh := make(chan string)
 	runErrCh := make(chan error)
-	runNotifyAPI:= runnotify.NewAPI(runCh,killCh,runErrCh)
+	runNotifyAPI:= runnotify.NewAPI(runtime,container.NewContainers(nil),runCh,killCh,make(chan string),runErrCh)
 	if runNotifyAPI.Messages == nil{
 		t.Fatal("failed to innitialize runNotifyAPI(Messages is nil)")
 	}else if runNotifyAPI.Err == nil{
 		t.Fatal("failed to innitialize runNotifyAPI(Err is nil)")
 	}
 }
+
+func TestRunnotifyWithFakeRuntime(t *testing.T) {
+	runtime := &fakeRuntime{events: make(chan container.Event), errs: make(chan error)}
+	runCh := make(chan string)
+	killCh := make(chan string)
+	updateCh := make(chan string)
+	runErrCh := make(chan error)
+	go runnotify.NewAPI(runtime, container.NewContainers(nil), runCh, killCh, updateCh, runErrCh).Start()
+
+	testCases := []struct {
+		event    container.Event
+		expected chan string
+	}{
+		{container.Event{Action: container.ActionRun, ID: "25f561f3d081"}, runCh},
+		{container.Event{Action: container.ActionKill, ID: "25f561f3d081"}, killCh},
+		// NOTE: The container stopped and started again is notified again.
+		{container.Event{Action: container.ActionRun, ID: "25f561f3d081"}, runCh},
+		{container.Event{Action: container.ActionKill, ID: "25f561f3d081"}, killCh},
+		// NOTE: The container connected to two networks is updated twice.
+		{container.Event{Action: container.ActionUpdate, ID: "f977b4e21a57"}, updateCh},
+		{container.Event{Action: container.ActionUpdate, ID: "f977b4e21a57"}, updateCh},
+	}
+	for _, testCase := range testCases {
+		runtime.events <- testCase.event
+		select {
+		case cid := <-testCase.expected:
+			if cid != testCase.event.ID {
+				t.Error("the container id of the event not notified correctly", cid)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the event not notified", testCase.event)
+		}
+	}
+}
+
+func TestRunnotifyResyncAfterReconnect(t *testing.T) {
+	containers := container.NewContainers([]*container.Container{{ID: "25f561f3d081", Pid: 100}, {ID: "9b3c1e7a2d4f", Pid: 200}})
+	runtime := &fakeRuntime{events: make(chan container.Event), errs: make(chan error), list: []*container.Container{{ID: "25f561f3d081", Pid: 100}, {ID: "9b3c1e7a2d4f", Pid: 200}}}
+	runCh := make(chan string)
+	killCh := make(chan string)
+	runErrCh := make(chan error)
+	runNotifyAPI := runnotify.NewAPI(runtime, containers, runCh, killCh, make(chan string), runErrCh)
+	runNotifyAPI.InitialBackoff = time.Millisecond
+	go runNotifyAPI.Start()
+
+	// NOTE: The event is received after the first resync, in which no container is notified.
+	runtime.events <- container.Event{Action: container.ActionRun, ID: "25f561f3d081"}
+	select {
+	case cid := <-runCh:
+		if cid != "25f561f3d081" {
+			t.Error("the container id of the event not notified correctly", cid)
+		}
+	case cid := <-killCh:
+		t.Fatal("the listed container notified as killed", cid)
+	case <-time.After(time.Second):
+		t.Fatal("the event not notified")
+	}
+
+	// NOTE: The container restarted and the container run while the events are interrupted are notified by the resync.
+	runtime.mutex.Lock()
+	runtime.list = []*container.Container{{ID: "25f561f3d081", Pid: 101}, {ID: "4a7e2c9d0b13", Pid: 300}}
+	runtime.mutex.Unlock()
+	interruption := errors.New("the runtime restarted")
+	runtime.errs <- interruption
+	select {
+	case err := <-runErrCh:
+		if err != interruption {
+			t.Error("the error of the events not notified correctly", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the error of the events not notified")
+	}
+
+	testCases := []struct {
+		id       string
+		expected chan string
+	}{
+		{"9b3c1e7a2d4f", killCh},
+		{"25f561f3d081", runCh},
+		{"4a7e2c9d0b13", runCh},
+	}
+	for _, testCase := range testCases {
+		select {
+		case cid := <-testCase.expected:
+			if cid != testCase.id {
+				t.Error("the container id of the resync not notified correctly", cid, testCase.id)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the container not resynced", testCase.id)
+		}
+	}
+	runtime.mutex.Lock()
+	defer runtime.mutex.Unlock()
+	if runtime.subscriptions != 2 {
+		t.Error("the events not subscribed again", runtime.subscriptions)
+	}
+}
+
+func TestRunnotifyBackoffOfUnavailableEvents(t *testing.T) {
+	runtime := &fakeRuntime{events: make(chan container.Event), unavailable: errors.New("unknown service runtime.v1.RuntimeService")}
+	runErrCh := make(chan error)
+	runNotifyAPI := runnotify.NewAPI(runtime, container.NewContainers(nil), make(chan string), make(chan string), make(chan string), runErrCh)
+	runNotifyAPI.InitialBackoff = 10 * time.Millisecond
+	runNotifyAPI.MaxBackoff = time.Second
+	go runNotifyAPI.Start()
+
+	// NOTE: The events are subscribed again after 10, 30, 70 and 150 milliseconds, because the backoff is not reset by the resync.
+	timeout := time.After(300 * time.Millisecond)
+	for waiting := true; waiting; {
+		select {
+		case <-runErrCh:
+		case <-timeout:
+			waiting = false
+		}
+	}
+	runtime.mutex.Lock()
+	defer runtime.mutex.Unlock()
+	if runtime.subscriptions < 3 || runtime.subscriptions > 6 {
+		t.Error("the backoff not doubled", runtime.subscriptions)
+	}
+	for i, done := range runtime.dones[:len(runtime.dones)-1] {
+		select {
+		case <-done:
+		default:
+			t.Error("the previous events not done", i)
+		}
+	}
+}